@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"go-pip-server/repository"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// yankRequest is the optional body of yank requests
+type yankRequest struct {
+	Reason string `json:"reason"`
+}
+
+// statusRequest is the body of project status requests
+type statusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// requireAdmin only lets requests through which carry the admin token as a bearer token
+func (p *PipServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(p.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Invalid or missing admin token.", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(repository.WithActor(r.Context(), "admin")))
+	}
+}
+
+// readYankReason reads the reason from the body of a yank request, which may be empty
+func readYankReason(r *http.Request) (string, error) {
+	var req yankRequest
+	err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 64<<10)).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimSpace(req.Reason), nil
+}
+
+// HandleYankRelease yanks or unyanks all the files of a release, depending on the method
+func (p *PipServer) HandleYankRelease(w http.ResponseWriter, r *http.Request) {
+	project, version := r.PathValue("project"), r.PathValue("version")
+	var err error
+	if r.Method == http.MethodDelete {
+		err = p.Repo.UnyankRelease(project, version, r.Context())
+	} else {
+		reason, rErr := readYankReason(r)
+		if rErr != nil {
+			http.Error(w, "Invalid request body.", http.StatusBadRequest)
+			return
+		}
+		err = p.Repo.YankRelease(project, version, reason, r.Context())
+	}
+	writeYankResult(w, err, "release", "project", project, "version", version, "method", r.Method)
+}
+
+// HandleYankFile yanks or unyanks a single distribution file, depending on the method
+func (p *PipServer) HandleYankFile(w http.ResponseWriter, r *http.Request) {
+	project, filename := r.PathValue("project"), r.PathValue("filename")
+	var err error
+	if r.Method == http.MethodDelete {
+		err = p.Repo.UnyankFile(project, filename, r.Context())
+	} else {
+		reason, rErr := readYankReason(r)
+		if rErr != nil {
+			http.Error(w, "Invalid request body.", http.StatusBadRequest)
+			return
+		}
+		err = p.Repo.YankFile(project, filename, reason, r.Context())
+	}
+	writeYankResult(w, err, "file", "project", project, "filename", filename, "method", r.Method)
+}
+
+// writeYankResult writes the response of a yank request and logs it
+func writeYankResult(w http.ResponseWriter, err error, target string, args ...any) {
+	switch {
+	case errors.Is(err, repository.ErrProjectNotFound),
+		errors.Is(err, repository.ErrReleaseNotFound),
+		errors.Is(err, repository.ErrFileNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case err != nil:
+		slog.Error("Error updating yanked "+target, append(args, "error", err)...)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Updated yanked "+target, args...)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleProjectStatus moves a project to another status. Soft-deleted projects are
+// restored by moving them back to active.
+func (p *PipServer) HandleProjectStatus(w http.ResponseWriter, r *http.Request) {
+	p.handleProjectStatus(w, r, p.Repo.SetProjectStatus)
+}
+
+// HandleOwnerProjectStatus archives or unarchives a project on behalf of its owners
+func (p *PipServer) HandleOwnerProjectStatus(w http.ResponseWriter, r *http.Request) {
+	p.handleProjectStatus(w, r, p.Repo.SetOwnedProjectStatus)
+}
+
+// handleProjectStatus moves a project to the status of a request with setStatus
+func (p *PipServer) handleProjectStatus(
+	w http.ResponseWriter,
+	r *http.Request,
+	setStatus func(string, repository.ProjectStatus, string, context.Context) error,
+) {
+	var req statusRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	status, err := repository.ParseProjectStatus(req.Status)
+	if err != nil {
+		http.Error(w, "Unknown project status.", http.StatusBadRequest)
+		return
+	}
+
+	project := r.PathValue("project")
+	err = setStatus(project, status, strings.TrimSpace(req.Reason), r.Context())
+	writeStatusResult(w, err, project, status)
+}
+
+// writeStatusResult writes the response of a project status change and logs it
+func writeStatusResult(w http.ResponseWriter, err error, project string, status repository.ProjectStatus) {
+	switch {
+	case errors.Is(err, repository.ErrProjectNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrPermissionDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, repository.ErrStatusTransition), errors.Is(err, repository.ErrRetentionExpired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		slog.Error("Error updating project status", "project", project, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Updated project status", "project", project, "status", status)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleDeleteProject permanently deletes a project with all its releases and files
+func (p *PipServer) HandleDeleteProject(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	err := p.Repo.PurgeProject(project, r.Context())
+	writeDeleteResult(w, err, "project", "project", project)
+}
+
+// HandleOwnerDeleteProject soft-deletes a project on behalf of its owners. Only admins
+// can restore it during the retention window or purge it.
+func (p *PipServer) HandleOwnerDeleteProject(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	err := p.Repo.SetOwnedProjectStatus(project, repository.ProjectDeleted, "", r.Context())
+	writeStatusResult(w, err, project, repository.ProjectDeleted)
+}
+
+// HandleDeleteRelease permanently deletes a release with all its files
+func (p *PipServer) HandleDeleteRelease(w http.ResponseWriter, r *http.Request) {
+	project, version := r.PathValue("project"), r.PathValue("version")
+	err := p.Repo.DeleteRelease(project, version, r.Context())
+	writeDeleteResult(w, err, "release", "project", project, "version", version)
+}
+
+// HandleDeleteFile permanently deletes a single distribution file
+func (p *PipServer) HandleDeleteFile(w http.ResponseWriter, r *http.Request) {
+	project, filename := r.PathValue("project"), r.PathValue("filename")
+	err := p.Repo.DeleteFile(project, filename, r.Context())
+	writeDeleteResult(w, err, "file", "project", project, "filename", filename)
+}
+
+// writeDeleteResult writes the response of a delete request and logs it
+func writeDeleteResult(w http.ResponseWriter, err error, target string, args ...any) {
+	switch {
+	case errors.Is(err, repository.ErrProjectNotFound),
+		errors.Is(err, repository.ErrReleaseNotFound),
+		errors.Is(err, repository.ErrFileNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case err != nil:
+		slog.Error("Error deleting "+target, append(args, "error", err)...)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Deleted "+target, args...)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
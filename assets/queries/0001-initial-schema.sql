@@ -0,0 +1,72 @@
+
+create table if not exists projects (
+    id integer primary key autoincrement,
+    name nvarchar(256) not null,
+    normalized_name nvarchar(256),
+    status nvarchar(32) not null default 'active',
+    created_at datetime default current_timestamp,
+    updated_at datetime default current_timestamp
+);
+
+-- [SEP] --
+
+create unique index if not exists idx_project_name on projects (name);
+
+-- [SEP] --
+
+create unique index if not exists idx_project_normalized_name on projects (normalized_name);
+
+-- [SEP] --
+
+create table if not exists releases (
+    id integer primary key autoincrement,
+    project_id integer not null,
+    version nvarchar(64) not null,
+    created_at datetime default current_timestamp,
+    updated_at datetime default current_timestamp,
+
+    foreign key (project_id) references projects(id) on delete cascade
+);
+
+-- [SEP] --
+
+create unique index if not exists idx_release_version on releases (project_id, version);
+
+-- [SEP] --
+
+create table if not exists release_files (
+    id integer primary key autoincrement,
+    release_id integer not null,
+    filename nvarchar(256) not null,
+    filepath nvarchar(256) not null,
+    file_type nvarchar(16) not null, -- bdist_wheel or sdist
+    python_tag nvarchar(64) not null default '', -- e.g. py3, cp312 or source
+    requires_python nvarchar(256) not null default '',
+    size integer not null default 0,
+    sha256_digest nvarchar(64) not null,
+    md5_digest nvarchar(32),
+    blake2_256_digest nvarchar(64),
+    metadata_digest nvarchar(128), -- SHA256 of the extracted core metadata file, if any
+    upload_time datetime default current_timestamp,
+    created_at datetime default current_timestamp,
+    updated_at datetime default current_timestamp,
+
+    foreign key (release_id) references releases(id) on delete cascade
+);
+
+-- [SEP] --
+
+create unique index if not exists idx_release_file_name on release_files (filename);
+
+-- [SEP] --
+
+create table if not exists release_metadata_fields (
+    id integer primary key autoincrement,
+    release_id integer not null,
+    key nvarchar(255) not null,
+    value nvarchar(1024) not null,
+    created_at datetime default current_timestamp,
+    updated_at datetime default current_timestamp,
+
+    foreign key (release_id) references releases(id) on delete cascade
+);
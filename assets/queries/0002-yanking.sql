@@ -0,0 +1,15 @@
+-- Releases and files can be withdrawn without being deleted (PEP 592). A file is yanked
+-- if it was yanked itself or if its release was.
+alter table releases add column yanked integer not null default 0;
+
+-- [SEP] --
+
+alter table releases add column yanked_reason nvarchar(1024) not null default '';
+
+-- [SEP] --
+
+alter table release_files add column yanked integer not null default 0;
+
+-- [SEP] --
+
+alter table release_files add column yanked_reason nvarchar(1024) not null default '';
@@ -0,0 +1,7 @@
+-- Projects move between the active, archived, quarantined and deleted states. The time of
+-- the last change starts the retention window of deleted projects.
+alter table projects add column status_reason nvarchar(1024) not null default '';
+
+-- [SEP] --
+
+alter table projects add column status_changed_at datetime;
@@ -0,0 +1,38 @@
+-- Every change to a project is recorded in the journal, which serves as the audit log.
+-- Entries refer to projects by normalized name so that they outlive deleted projects,
+-- and the highest entry ID is the serial of the index (X-PyPI-Last-Serial).
+create table if not exists journal_entries (
+    id integer primary key autoincrement,
+    project_name nvarchar(256) not null, -- normalized project name
+    version nvarchar(64),
+    filename nvarchar(256),
+    action nvarchar(256) not null,
+    actor nvarchar(256) not null default '',
+    created_at datetime default current_timestamp
+);
+
+-- [SEP] --
+
+create index if not exists idx_journal_project on journal_entries (project_name, id);
+
+-- [SEP] --
+
+-- File names are looked up to prevent the reuse of names of deleted files
+create index if not exists idx_journal_filename on journal_entries (filename);
+
+-- [SEP] --
+
+-- Existing projects and files get entries so that serials keep increasing
+insert into journal_entries (project_name, action, created_at)
+select normalized_name, 'create', created_at
+from projects
+order by id;
+
+-- [SEP] --
+
+insert into journal_entries (project_name, version, filename, action, created_at)
+select p.normalized_name, rl.version, f.filename, 'add ' || f.file_type || ' file', f.upload_time
+from release_files as f
+join releases as rl on f.release_id = rl.id
+join projects as p on rl.project_id = p.id
+order by f.id;
@@ -0,0 +1,10 @@
+-- Users authenticate uploads with HTTP Basic auth. Passwords are stored as argon2id
+-- hashes in the PHC string format, and disabled users cannot authenticate.
+create table if not exists users (
+    id integer primary key autoincrement,
+    username nvarchar(64) not null unique collate nocase,
+    password_hash nvarchar(256) not null,
+    disabled boolean not null default 0,
+    created_at datetime default current_timestamp,
+    updated_at datetime default current_timestamp
+);
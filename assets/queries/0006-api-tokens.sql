@@ -0,0 +1,24 @@
+-- API tokens authenticate uploads as their user, with the user name __token__ as on PyPI.
+-- Only the SHA256 hash of a token is stored, along with its first characters so that
+-- users can tell their tokens apart.
+create table if not exists api_tokens (
+    id integer primary key autoincrement,
+    user_id integer not null,
+    name nvarchar(128) not null,
+    token_prefix nvarchar(16) not null,
+    token_hash char(64) not null unique,
+    expires_at datetime,
+    last_used_at datetime,
+    created_at datetime default current_timestamp,
+    foreign key (user_id) references users(id) on delete cascade
+);
+
+-- [SEP] --
+
+-- Tokens without projects can upload to any project, others only to the listed ones
+create table if not exists api_token_projects (
+    token_id integer not null,
+    project_name nvarchar(256) not null, -- normalized project name
+    primary key (token_id, project_name),
+    foreign key (token_id) references api_tokens(id) on delete cascade
+);
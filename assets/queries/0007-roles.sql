@@ -0,0 +1,44 @@
+-- Groups of users, which can be given roles on projects like single users
+create table if not exists groups (
+    id integer primary key autoincrement,
+    name nvarchar(64) not null unique collate nocase,
+    created_at datetime default current_timestamp
+);
+
+-- [SEP] --
+
+create table if not exists group_members (
+    group_id integer not null,
+    user_id integer not null,
+    primary key (group_id, user_id),
+    foreign key (group_id) references groups(id) on delete cascade,
+    foreign key (user_id) references users(id) on delete cascade
+);
+
+-- [SEP] --
+
+-- Roles of users and groups on projects: owner, maintainer, uploader or reader. Each role
+-- is given either to a user or to a group.
+create table if not exists project_roles (
+    id integer primary key autoincrement,
+    project_id integer not null,
+    user_id integer,
+    group_id integer,
+    role nvarchar(16) not null,
+    created_at datetime default current_timestamp,
+    check ((user_id is null) != (group_id is null)),
+    unique (project_id, user_id),
+    unique (project_id, group_id),
+    foreign key (project_id) references projects(id) on delete cascade,
+    foreign key (user_id) references users(id) on delete cascade,
+    foreign key (group_id) references groups(id) on delete cascade
+);
+
+-- [SEP] --
+
+-- Projects created by a user, as recorded in the journal, are owned by that user
+insert or ignore into project_roles (project_id, user_id, role)
+select p.id, u.id, 'owner'
+from projects as p
+join journal_entries as j on j.project_name = p.normalized_name and j.action = 'create'
+join users as u on j.actor = u.username or j.actor like u.username || ' (token %';
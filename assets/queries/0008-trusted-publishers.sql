@@ -0,0 +1,38 @@
+-- Trusted publishers let CI jobs upload to a project with short-lived tokens, minted in
+-- exchange for an OIDC token of the issuer whose claims match the constraints.
+create table if not exists trusted_publishers (
+    id integer primary key autoincrement,
+    project_id integer not null,
+    issuer nvarchar(512) not null,
+    audience nvarchar(256) not null,
+    claims text not null, -- JSON object of claim names to expected values or patterns
+    created_at datetime default current_timestamp,
+    foreign key (project_id) references projects(id) on delete cascade
+);
+
+-- [SEP] --
+
+create index if not exists idx_trusted_publishers_issuer on trusted_publishers (issuer);
+
+-- [SEP] --
+
+-- Minted tokens, of which only the hash is stored. The ID of the exchanged OIDC token is
+-- kept so that it cannot be exchanged twice.
+create table if not exists publisher_tokens (
+    id integer primary key autoincrement,
+    token_hash char(64) not null unique,
+    jti nvarchar(256) unique,
+    expires_at datetime not null,
+    created_at datetime default current_timestamp
+);
+
+-- [SEP] --
+
+-- The publishers a minted token was issued for, whose projects it can upload to
+create table if not exists publisher_token_scopes (
+    token_id integer not null,
+    publisher_id integer not null,
+    primary key (token_id, publisher_id),
+    foreign key (token_id) references publisher_tokens(id) on delete cascade,
+    foreign key (publisher_id) references trusted_publishers(id) on delete cascade
+);
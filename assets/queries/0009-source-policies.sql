@@ -0,0 +1,9 @@
+-- Source policies decide whether a project is served from the local index, from the
+-- upstream index, or merged from both. Names without a policy are local if they were ever
+-- used locally, so that upstream projects cannot shadow internal ones. Policies refer to
+-- projects by normalized name, as they also apply to projects which only exist upstream.
+create table if not exists source_policies (
+    project_name nvarchar(256) primary key, -- normalized project name
+    policy nvarchar(16) not null check (policy in ('local', 'upstream', 'merged')),
+    created_at datetime default current_timestamp
+);
@@ -0,0 +1,94 @@
+-- Indexes are separate package indexes hosted by one server, such as dev, staging and
+-- prod. Projects, files and their journal belong to one index, and names only need to be
+-- unique within it. The default index is served at the root of the server, the others
+-- under /<name>/.
+create table if not exists indexes (
+    id integer primary key autoincrement,
+    name nvarchar(64) not null unique collate nocase,
+    storage_prefix nvarchar(256) not null, -- directory of the index's files under the data path
+    private integer, -- null follows the server setting
+    upload_policy nvarchar(16) check (upload_policy in ('authenticated', 'anonymous', 'disabled')),
+    upstream_url nvarchar(512), -- Simple API of the upstream index serving missing projects
+    created_at datetime default current_timestamp
+);
+
+-- [SEP] --
+
+insert into indexes (id, name, storage_prefix) values (1, 'default', '');
+
+-- [SEP] --
+
+-- Existing rows belong to the default index. The columns cannot reference indexes, as
+-- SQLite only adds such columns with a null default.
+alter table projects add column index_id integer not null default 1;
+
+-- [SEP] --
+
+drop index if exists idx_project_name;
+
+-- [SEP] --
+
+drop index if exists idx_project_normalized_name;
+
+-- [SEP] --
+
+create unique index if not exists idx_project_name on projects (index_id, name);
+
+-- [SEP] --
+
+create unique index if not exists idx_project_normalized_name on projects (index_id, normalized_name);
+
+-- [SEP] --
+
+-- File names are unique within an index, so that a file can be promoted from one index
+-- to another
+alter table release_files add column index_id integer not null default 1;
+
+-- [SEP] --
+
+drop index if exists idx_release_file_name;
+
+-- [SEP] --
+
+create unique index if not exists idx_release_file_name on release_files (index_id, filename);
+
+-- [SEP] --
+
+alter table journal_entries add column index_id integer not null default 1;
+
+-- [SEP] --
+
+drop index if exists idx_journal_project;
+
+-- [SEP] --
+
+create index if not exists idx_journal_project on journal_entries (index_id, project_name, id);
+
+-- [SEP] --
+
+-- Minted tokens can only upload to the index their publishers belong to
+alter table publisher_tokens add column index_id integer not null default 1;
+
+-- [SEP] --
+
+create table source_policies_new (
+    index_id integer not null default 1,
+    project_name nvarchar(256) not null, -- normalized project name
+    policy nvarchar(16) not null check (policy in ('local', 'upstream', 'merged')),
+    created_at datetime default current_timestamp,
+    primary key (index_id, project_name),
+    foreign key (index_id) references indexes(id) on delete cascade
+);
+
+-- [SEP] --
+
+insert into source_policies_new (project_name, policy, created_at)
+select project_name, policy, created_at from source_policies;
+
+-- [SEP] --
+
+drop table source_policies;
+
+-- [SEP] --
+
+alter table source_policies_new rename to source_policies;
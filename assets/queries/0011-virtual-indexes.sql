@@ -0,0 +1,21 @@
+-- Virtual indexes have no projects of their own, they serve the projects of other indexes
+-- layered in priority order. Either the first layer with a project serves it, or the files
+-- of all layers are merged.
+alter table indexes add column resolution nvarchar(16) check (resolution in ('first-match', 'union'));
+
+-- [SEP] --
+
+-- Uploads to a virtual index go to its upload target, if it has one
+alter table indexes add column upload_target_id integer references indexes(id);
+
+-- [SEP] --
+
+create table if not exists index_layers (
+    virtual_id integer not null,
+    layer_id integer not null,
+    position integer not null, -- priority of the layer, lowest first
+    primary key (virtual_id, position),
+    unique (virtual_id, layer_id),
+    foreign key (virtual_id) references indexes(id) on delete cascade,
+    foreign key (layer_id) references indexes(id)
+);
@@ -0,0 +1,8 @@
+-- API tokens limited to some projects are limited to the index of these projects, as other
+-- indexes may have projects with the same names. Tokens without projects have no index.
+alter table api_tokens add column index_id integer references indexes(id) on delete cascade;
+
+-- [SEP] --
+
+-- Tokens limited to projects were issued for the default index before indexes existed
+update api_tokens set index_id = 1 where id in (select token_id from api_token_projects);
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go-pip-server/repository"
+	"log/slog"
+	"net/http"
+)
+
+// tokenKey is the context key of the API token a request was authenticated with
+type tokenKey struct{}
+
+// requestUser returns the authenticated user of a request, or nil for anonymous requests
+func requestUser(c context.Context) *repository.User {
+	return repository.UserFrom(c)
+}
+
+// requestToken returns the API token a request was authenticated with, or nil if it was
+// authenticated with a password or not at all
+func requestToken(c context.Context) *repository.APIToken {
+	t, _ := c.Value(tokenKey{}).(*repository.APIToken)
+	return t
+}
+
+// authenticate checks the HTTP Basic credentials of a request, if it has any: either a
+// user name and password, or an API token with the user name __token__ as sent by twine
+// and pip. The user and token are added to the returned request's context, and the user
+// is recorded as the actor of changes. If the credentials are invalid, the error is
+// written and false is returned.
+func (p *PipServer) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return r, true
+	}
+
+	var u *repository.User
+	var token *repository.APIToken
+	var err error
+	if username == repository.TokenUsername {
+		u, token, err = p.Repo.AuthenticateToken(password, r.Context())
+	} else {
+		u, err = p.Repo.Authenticate(username, password, r.Context())
+	}
+	switch {
+	case errors.Is(err, repository.ErrTokenExpired):
+		unauthorized(w, "API token has expired.")
+		return nil, false
+	case errors.Is(err, repository.ErrInvalidCredentials):
+		slog.Warn("Rejected credentials", "user", username, "path", r.URL.Path)
+		unauthorized(w, "Invalid username or password.")
+		return nil, false
+	case errors.Is(err, repository.ErrUserDisabled):
+		slog.Warn("Rejected disabled user", "user", username, "path", r.URL.Path)
+		http.Error(w, "User is disabled.", http.StatusForbidden)
+		return nil, false
+	case err != nil:
+		slog.Error("Error authenticating user", "user", username, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	// Tokens minted for trusted publishers have no user
+	c := r.Context()
+	if u != nil {
+		c = repository.WithUser(c, u)
+	}
+	if token != nil {
+		actor := token.Name
+		if u != nil {
+			actor = u.Username
+		}
+		c = context.WithValue(c, tokenKey{}, token)
+		c = repository.WithActor(c, actor+" (token "+token.Prefix+")")
+		if token.Publisher {
+			c = repository.WithPublisher(c)
+		}
+	}
+	return r.WithContext(c), true
+}
+
+// requireUser only lets requests through which carry valid credentials, see authenticate,
+// including the tokens of trusted publishers. If AllowAnonymousUploads is set, requests
+// without credentials are let through.
+func (p *PipServer) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, ok := p.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if requestUser(r.Context()) == nil && requestToken(r.Context()) == nil && !p.AllowAnonymousUploads {
+			unauthorized(w, "Authentication is required.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireUploader applies the upload policy of the index of a request: it either rejects
+// all uploads, lets anonymous uploads through, or requires credentials like requireUser.
+// API tokens minted for another index are refused. Uploads to a virtual index go to its
+// upload target.
+func (p *PipServer) requireUploader(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idx := requestIndex(r.Context())
+		if idx.Virtual() {
+			if idx.UploadTarget == nil {
+				http.Error(w, "This virtual index has no upload target.", http.StatusForbidden)
+				return
+			}
+			idx = idx.UploadTarget
+			r = r.WithContext(withServedIndex(r.Context(), idx))
+		}
+		if idx.UploadPolicy == repository.UploadsDisabled {
+			http.Error(w, "Uploads to this index are disabled.", http.StatusForbidden)
+			return
+		}
+		r, ok := p.authenticate(w, r)
+		if !ok {
+			return
+		}
+		token := requestToken(r.Context())
+		if requestUser(r.Context()) == nil && token == nil && idx.UploadPolicy != repository.UploadsAnonymous {
+			unauthorized(w, "Authentication is required.")
+			return
+		}
+		if token != nil && !token.AllowsIndex(idx.ID) {
+			http.Error(w, "API token is not allowed to upload to this index.", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireReader only lets requests through which carry valid credentials if the index is
+// private, so that pip prompts for credentials or looks them up in its keyring. Public
+// indexes let all requests through without checking credentials.
+func (p *PipServer) requireReader(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requestIndex(r.Context()).Private {
+			next(w, r)
+			return
+		}
+		r, ok := p.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if requestUser(r.Context()) == nil {
+			unauthorized(w, "Authentication is required.")
+			return
+		}
+		// Responses depend on the user, so shared caches must not keep them
+		w.Header().Set("Cache-Control", "private")
+		next(w, r)
+	}
+}
+
+// canRead checks if the user of a request can read a project. Everyone can read every
+// project of public indexes, while on private indexes users need a role on the project,
+// and their API token must cover it. Missing projects can only be read if they may come
+// from the upstream index.
+func (p *PipServer) canRead(r *http.Request, project string) (bool, error) {
+	idx := requestIndex(r.Context())
+	if !idx.Private {
+		return true, nil
+	}
+	u := requestUser(r.Context())
+	if u == nil {
+		return false, nil
+	}
+	if token := requestToken(r.Context()); token != nil && !token.Allows(idx.ID, project) {
+		return false, nil
+	}
+	ok, err := p.Repo.HasPermission(project, u, repository.PermissionRead, r.Context())
+	if errors.Is(err, repository.ErrProjectNotFound) {
+		// Projects of the upstream index can be read by all users
+		return idx.Upstream != nil, nil
+	}
+	return ok, err
+}
+
+// unauthorized asks the client for HTTP Basic credentials, which makes twine and pip
+// prompt for them
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="pypi", charset="UTF-8"`)
+	http.Error(w, msg, http.StatusUnauthorized)
+}
+
+// requireRole only lets requests through from users with a role on the project in the
+// request path which grants the permission. API tokens are only meant for uploads, so
+// they are refused.
+func (p *PipServer) requireRole(perm repository.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return p.requireUser(func(w http.ResponseWriter, r *http.Request) {
+		if requestToken(r.Context()) != nil {
+			http.Error(w, "API tokens can only be used to upload.", http.StatusForbidden)
+			return
+		}
+		u := requestUser(r.Context())
+		if u == nil {
+			unauthorized(w, "Authentication is required.")
+			return
+		}
+
+		project := r.PathValue("project")
+		ok, err := p.Repo.HasPermission(project, u, perm, r.Context())
+		switch {
+		case errors.Is(err, repository.ErrProjectNotFound):
+			http.Error(w, "Not Found", http.StatusNotFound)
+		case err != nil:
+			slog.Error("Error checking permission", "user", u.Username, "project", project, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		case !ok:
+			slog.Warn("Denied permission", "user", u.Username, "project", project, "permission", perm)
+			http.Error(w, "You do not have the permission to "+string(perm)+" this project.", http.StatusForbidden)
+		default:
+			next(w, r)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"go-pip-server/repository"
+	"log/slog"
+	"net/http"
+)
+
+// roleRequest is the body of requests setting the role of a collaborator
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+// collaboratorKinds maps the collaborator kinds in request paths to repository kinds
+var collaboratorKinds = map[string]string{
+	"users":  repository.CollaboratorUser,
+	"groups": repository.CollaboratorGroup,
+}
+
+// HandleListCollaborators returns the users and groups with a role on a project
+func (p *PipServer) HandleListCollaborators(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	collaborators, err := p.Repo.GetCollaborators(project, r.Context())
+	if errors.Is(err, repository.ErrProjectNotFound) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("Error fetching collaborators", "project", project, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, collaborators)
+}
+
+// HandleSetCollaborator gives a role on a project to a user or a group, or removes it,
+// depending on the method
+func (p *PipServer) HandleSetCollaborator(w http.ResponseWriter, r *http.Request) {
+	project, name := r.PathValue("project"), r.PathValue("name")
+	kind, ok := collaboratorKinds[r.PathValue("kind")]
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var err error
+	if r.Method == http.MethodDelete {
+		err = p.Repo.RemoveCollaborator(project, kind, name, r.Context())
+	} else {
+		var req roleRequest
+		if dErr := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); dErr != nil {
+			http.Error(w, "Invalid request body.", http.StatusBadRequest)
+			return
+		}
+		role, rErr := repository.ParseRole(req.Role)
+		if rErr != nil {
+			http.Error(w, "Unknown role, use owner, maintainer, uploader or reader.", http.StatusBadRequest)
+			return
+		}
+		err = p.Repo.SetCollaboratorRole(project, kind, name, role, r.Context())
+	}
+
+	switch {
+	case errors.Is(err, repository.ErrProjectNotFound),
+		errors.Is(err, repository.ErrUserNotFound),
+		errors.Is(err, repository.ErrGroupNotFound),
+		errors.Is(err, repository.ErrCollaboratorNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrLastOwner):
+		http.Error(w, "The project must keep at least one owner.", http.StatusConflict)
+	case err != nil:
+		slog.Error("Error updating collaborator", "project", project, "kind", kind, "name", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Updated collaborator", "project", project, "kind", kind, "name", name, "method", r.Method)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleListGroups returns all groups with their members
+func (p *PipServer) HandleListGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := p.Repo.GetAllGroups(r.Context())
+	if err != nil {
+		slog.Error("Error fetching groups", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+// HandleGroup creates or deletes a group, depending on the method
+func (p *PipServer) HandleGroup(w http.ResponseWriter, r *http.Request) {
+	group := r.PathValue("group")
+	var err error
+	if r.Method == http.MethodDelete {
+		err = p.Repo.DeleteGroup(group, r.Context())
+	} else {
+		_, err = p.Repo.CreateGroup(group, r.Context())
+		if errors.Is(err, repository.ErrGroupExists) {
+			// Creating a group is idempotent
+			err = nil
+		}
+	}
+	writeGroupResult(w, err, "group", group, "method", r.Method)
+}
+
+// HandleGroupMember adds a user to a group or removes it, depending on the method
+func (p *PipServer) HandleGroupMember(w http.ResponseWriter, r *http.Request) {
+	group, username := r.PathValue("group"), r.PathValue("username")
+	var err error
+	if r.Method == http.MethodDelete {
+		err = p.Repo.RemoveGroupMember(group, username, r.Context())
+	} else {
+		err = p.Repo.AddGroupMember(group, username, r.Context())
+	}
+	writeGroupResult(w, err, "group", group, "user", username, "method", r.Method)
+}
+
+// writeGroupResult writes the response of a group request and logs it
+func writeGroupResult(w http.ResponseWriter, err error, args ...any) {
+	switch {
+	case errors.Is(err, repository.ErrGroupNotFound), errors.Is(err, repository.ErrUserNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidUsername):
+		http.Error(w, "Invalid group name.", http.StatusBadRequest)
+	case errors.Is(err, repository.ErrLastOwner):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		slog.Error("Error updating group", append(args, "error", err)...)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Updated group", args...)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,807 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"go-pip-server/repository"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// CommandsUsage describes the commands that can be run instead of the server
+const CommandsUsage = `Commands:
+  migrate status    List the database migrations and whether they have been applied
+  migrate up        Apply all pending database migrations
+
+  status <project>                           Show the status of a project
+  status <project> <status> [reason]         Move a project to active, archived, quarantined
+                                             or deleted (restorable until -deletion-retention)
+
+  yank <project> <version> [reason]          Yank a release, pip then ignores it unless pinned
+  unyank <project> <version>                 Revert the yanking of a release
+  yank-file <project> <filename> [reason]    Yank a single distribution file
+  unyank-file <project> <filename>           Revert the yanking of a single file
+
+  delete <project> [version]                 Permanently delete a project or a release
+  delete-file <project> <filename>           Permanently delete a single distribution file
+  purge-expired                              Permanently delete the projects whose deletion
+                                             is older than -deletion-retention
+  journal [project] [limit]                  Show the latest changes of all or one project
+
+  user list                                  List the users who can upload
+  user create <username>                     Create a user, the password is read from stdin
+                                             or from PIP_SERVER_PASSWORD
+  user reset-password <username>             Replace the password of a user, read likewise
+  user disable <username>                    Prevent a user from authenticating
+  user enable <username>                     Allow a disabled user to authenticate again
+
+  token list [username]                      List the API tokens of all users or of one
+  token create [-expires <duration>] [-project <name>]... <username> <name>
+                                             Issue an API token, limited to the given
+                                             projects of -index if any, and print it once
+  token revoke <id>                          Revoke an API token
+
+  role list <project>                        List the users and groups with a role on a project
+  role set <project> user|group <name> <role>
+                                             Give the owner, maintainer, uploader or reader
+                                             role on a project to a user or a group
+  role remove <project> user|group <name>    Remove the role of a user or a group
+
+  group list                                 List the groups and their members
+  group create|delete <group>                Create or delete a group
+  group add|remove <group> <username>        Add a user to a group or remove it
+
+  publisher list [project]                   List the trusted publishers of all or one project
+  publisher add [-audience <aud>] -claim <name>=<value>... <project> <issuer>
+                                             Let CI jobs of the OIDC issuer upload to a project
+                                             if their token claims match, values may contain *
+  publisher remove <project> <id>            Remove a trusted publisher
+
+  source list                                List the projects with an explicit source policy
+  source get <project>                       Show where a project is served from
+  source set <project> local|upstream|merged Serve a project from the local index, the
+                                             upstream index, or both
+  source reset <project>                     Give a project the default source policy
+
+  index list                                 List the indexes and their settings
+  index create [settings] [-storage-prefix <dir>] <name>
+                                             Create an index served under /<name>/
+  index update [settings] <name>             Replace the settings of an index, which are
+                                             -private true|false, -upload-policy
+                                             authenticated|anonymous|disabled and
+                                             -upstream-url <url>, unset ones follow the flags
+                                             Virtual indexes are given with -layer <index>
+                                             once per layer in priority order, and
+                                             optionally -resolution first-match|union and
+                                             -upload-target <index>
+  index delete <name>                        Delete an index without projects
+
+Commands on projects, their roles, publishers and source policies apply to the index
+given with -index.
+`
+
+// runCommand runs a command given on the command line instead of starting the server
+func runCommand(cfg *Config, db *sql.DB, args []string) error {
+	repo, err := repository.NewRepository(db, cfg.QueriesSource)
+	if err != nil {
+		return err
+	}
+	repo.DeletionRetention = cfg.DeletionRetention
+	repo.AllowLoopbackHTTPIssuers = cfg.OIDCAllowLoopbackHTTP
+	if cfg.Index != repository.DefaultIndexName && args[0] != "migrate" {
+		idx, err := repo.GetIndex(cfg.Index, context.Background())
+		if err != nil {
+			return err
+		}
+		commandIndex = idx.ID
+	}
+
+	switch args[0] {
+	case "migrate":
+		return runMigrate(repo, args[1:])
+	case "status":
+		return runStatus(repo, args[1:])
+	case "yank", "unyank", "yank-file", "unyank-file":
+		return runYank(repo, args[0], args[1:])
+	case "delete", "delete-file":
+		return runDelete(repo, args[0], args[1:])
+	case "purge-expired":
+		return runPurgeExpired(repo, args[1:])
+	case "journal":
+		return runJournal(repo, args[1:])
+	case "user":
+		return runUser(repo, args[1:])
+	case "token":
+		return runToken(repo, args[1:])
+	case "role":
+		return runRole(repo, args[1:])
+	case "group":
+		return runGroup(repo, args[1:])
+	case "publisher":
+		return runPublisher(repo, cfg.OIDCAudience, args[1:])
+	case "source":
+		return runSource(repo, args[1:])
+	case "index":
+		return runIndex(repo, cfg.DataPath, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], CommandsUsage)
+	}
+}
+
+// commandIndex is the ID of the index which commands apply to, set with -index
+var commandIndex int64 = repository.DefaultIndexID
+
+// commandContext returns the context of commands, in which changes are recorded in the
+// journal as made from the command line by the current user, in the index of -index
+func commandContext() context.Context {
+	c := repository.WithActor(context.Background(), "cli:"+os.Getenv("USER"))
+	return repository.WithIndex(c, commandIndex)
+}
+
+// runMigrate shows the status of the database migrations or applies the pending ones
+func runMigrate(repo *repository.Repository, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate status|up")
+	}
+	ctx := commandContext()
+
+	switch args[0] {
+	case "status":
+		states, err := repo.MigrationStatus(ctx)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, st := range states {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", st.Version, st.Name, applied)
+		}
+		tw.Flush()
+		return err
+	case "up":
+		applied, err := repo.MigrateUp(ctx)
+		fmt.Printf("Applied %d migration(s)\n", applied)
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected status or up", args[0])
+	}
+}
+
+// runStatus shows or changes the status of a project
+func runStatus(repo *repository.Repository, args []string) error {
+	if len(args) < 1 || len(args) > 3 {
+		return errors.New("usage: status <project> [<status> [reason]]")
+	}
+	ctx := commandContext()
+
+	if len(args) > 1 {
+		status, err := repository.ParseProjectStatus(args[1])
+		if err != nil {
+			return err
+		}
+		var reason string
+		if len(args) == 3 {
+			reason = args[2]
+		}
+		err = repo.SetProjectStatus(args[0], status, reason, ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	proj, err := repo.GetProject(args[0], ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s", proj.Name, proj.Status)
+	if proj.StatusReason != "" {
+		fmt.Printf(" (%s)", proj.StatusReason)
+	}
+	if proj.StatusChangedAt != nil {
+		fmt.Printf(", since %s", proj.StatusChangedAt.UTC().Format(time.RFC3339))
+	}
+	fmt.Println()
+	return nil
+}
+
+// runYank yanks or unyanks a release or a single file
+func runYank(repo *repository.Repository, cmd string, args []string) error {
+	maxArgs := 3
+	if strings.HasPrefix(cmd, "unyank") {
+		maxArgs = 2
+	}
+	target := "version"
+	if strings.HasSuffix(cmd, "-file") {
+		target = "filename"
+	}
+	if len(args) < 2 || len(args) > maxArgs {
+		usage := fmt.Sprintf("usage: %s <project> <%s>", cmd, target)
+		if maxArgs == 3 {
+			usage += " [reason]"
+		}
+		return errors.New(usage)
+	}
+	var reason string
+	if len(args) == 3 {
+		reason = args[2]
+	}
+	ctx := commandContext()
+
+	var err error
+	var done string
+	switch cmd {
+	case "yank":
+		err, done = repo.YankRelease(args[0], args[1], reason, ctx), "Yanked release"
+	case "unyank":
+		err, done = repo.UnyankRelease(args[0], args[1], ctx), "Unyanked release"
+	case "yank-file":
+		err, done = repo.YankFile(args[0], args[1], reason, ctx), "Yanked file"
+	case "unyank-file":
+		err, done = repo.UnyankFile(args[0], args[1], ctx), "Unyanked file"
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s %s of %s\n", done, args[1], args[0])
+	return nil
+}
+
+// runDelete permanently deletes a project, a release or a single file
+func runDelete(repo *repository.Repository, cmd string, args []string) error {
+	ctx := commandContext()
+	switch {
+	case cmd == "delete" && len(args) == 1:
+		if err := repo.PurgeProject(args[0], ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted project %s\n", args[0])
+	case cmd == "delete" && len(args) == 2:
+		if err := repo.DeleteRelease(args[0], args[1], ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted release %s of %s\n", args[1], args[0])
+	case cmd == "delete-file" && len(args) == 2:
+		if err := repo.DeleteFile(args[0], args[1], ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted file %s of %s\n", args[1], args[0])
+	case cmd == "delete":
+		return errors.New("usage: delete <project> [version]")
+	default:
+		return errors.New("usage: delete-file <project> <filename>")
+	}
+	return nil
+}
+
+// runPurgeExpired permanently deletes the projects past their deletion retention
+func runPurgeExpired(repo *repository.Repository, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: purge-expired")
+	}
+	purged, err := repo.PurgeExpiredProjects(commandContext())
+	for _, name := range purged {
+		fmt.Printf("Deleted project %s\n", name)
+	}
+	fmt.Printf("Purged %d project(s)\n", len(purged))
+	return err
+}
+
+// runJournal shows the latest journal entries of all projects or of a single one
+func runJournal(repo *repository.Repository, args []string) error {
+	if len(args) > 2 {
+		return errors.New("usage: journal [project] [limit]")
+	}
+	var project string
+	limit := 50
+	if len(args) > 0 {
+		project = args[0]
+	}
+	if len(args) == 2 {
+		var err error
+		if limit, err = strconv.Atoi(args[1]); err != nil || limit < 1 {
+			return fmt.Errorf("invalid limit %q", args[1])
+		}
+	}
+
+	entries, err := repo.GetJournal(project, limit, commandContext())
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tTIME\tPROJECT\tVERSION\tFILE\tACTION\tACTOR")
+	for _, e := range entries {
+		fmt.Fprintf(
+			tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.ID, e.CreatedAt.UTC().Format(time.RFC3339), e.ProjectName, e.Version, e.Filename, e.Action, e.Actor,
+		)
+	}
+	return tw.Flush()
+}
+
+// runUser lists, creates, disables and enables users, and resets their passwords
+func runUser(repo *repository.Repository, args []string) error {
+	if len(args) == 1 && args[0] == "list" {
+		users, err := repo.GetAllUsers(commandContext())
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tSTATUS\tCREATED AT")
+		for _, u := range users {
+			status := "enabled"
+			if u.Disabled {
+				status = "disabled"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", u.Username, status, u.CreatedAt.UTC().Format(time.RFC3339))
+		}
+		return tw.Flush()
+	}
+	if len(args) != 2 {
+		return errors.New("usage: user list|create|reset-password|disable|enable [<username>]")
+	}
+	ctx := commandContext()
+	username := args[1]
+
+	switch args[0] {
+	case "create":
+		password, err := readPassword(username)
+		if err != nil {
+			return err
+		}
+		if _, err := repo.CreateUser(username, password, ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Created user %s\n", username)
+	case "reset-password":
+		password, err := readPassword(username)
+		if err != nil {
+			return err
+		}
+		if err := repo.SetUserPassword(username, password, ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Reset the password of user %s\n", username)
+	case "disable", "enable":
+		if err := repo.SetUserDisabled(username, args[0] == "disable", ctx); err != nil {
+			return err
+		}
+		fmt.Printf("User %s is %sd\n", username, args[0])
+	default:
+		return fmt.Errorf("unknown user command %q, expected list, create, reset-password, disable or enable", args[0])
+	}
+	return nil
+}
+
+// readPassword reads the password of a user from PIP_SERVER_PASSWORD, or else from the
+// first line of stdin
+func readPassword(username string) (string, error) {
+	if password := os.Getenv("PIP_SERVER_PASSWORD"); password != "" {
+		return password, nil
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s: ", username)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("error reading password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// projectList collects the values of a repeated flag, such as -project
+type projectList []string
+
+func (l *projectList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *projectList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// runToken lists, issues and revokes API tokens
+func runToken(repo *repository.Repository, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: token list|create|revoke")
+	}
+	ctx := commandContext()
+
+	switch args[0] {
+	case "list":
+		if len(args) > 2 {
+			return errors.New("usage: token list [username]")
+		}
+		var username string
+		if len(args) == 2 {
+			username = args[1]
+		}
+		tokens, err := repo.GetAPITokens(username, ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSERNAME\tNAME\tPREFIX\tPROJECTS\tEXPIRES AT\tLAST USED AT")
+		for _, t := range tokens {
+			projects := strings.Join(t.Projects, ",")
+			if projects == "" {
+				projects = "*"
+			} else if t.Index != repository.DefaultIndexName {
+				projects = t.Index + ":" + projects
+			}
+			fmt.Fprintf(
+				tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				t.ID, t.Username, t.Name, t.Prefix, projects, formatOptionalTime(t.ExpiresAt, "never"),
+				formatOptionalTime(t.LastUsedAt, "never"),
+			)
+		}
+		return tw.Flush()
+	case "create":
+		fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+		expires := fs.Duration("expires", 0, "Lifetime of the token, it does not expire if 0")
+		var projects projectList
+		fs.Var(&projects, "project", "Project the token can upload to, can be repeated")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return errors.New("usage: token create [-expires <duration>] [-project <name>]... <username> <name>")
+		}
+		ins := &repository.APITokenInsert{Name: fs.Arg(1), Projects: projects}
+		if *expires > 0 {
+			expiresAt := time.Now().Add(*expires)
+			ins.ExpiresAt = &expiresAt
+		}
+		_, secret, err := repo.CreateAPIToken(fs.Arg(0), ins, ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(secret)
+		return nil
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: token revoke <id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid token id %q", args[1])
+		}
+		if err := repo.RevokeAPIToken("", id, ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked API token %d\n", id)
+		return nil
+	default:
+		return fmt.Errorf("unknown token command %q, expected list, create or revoke", args[0])
+	}
+}
+
+// runRole lists, gives and removes the roles of users and groups on a project
+func runRole(repo *repository.Repository, args []string) error {
+	ctx := commandContext()
+	switch {
+	case len(args) == 2 && args[0] == "list":
+		collaborators, err := repo.GetCollaborators(args[1], ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tNAME\tROLE\tSINCE")
+		for _, cb := range collaborators {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", cb.Kind, cb.Name, cb.Role, cb.CreatedAt.UTC().Format(time.RFC3339))
+		}
+		return tw.Flush()
+	case len(args) == 5 && args[0] == "set":
+		role, err := repository.ParseRole(args[4])
+		if err != nil {
+			return err
+		}
+		if err := repo.SetCollaboratorRole(args[1], args[2], args[3], role, ctx); err != nil {
+			return err
+		}
+		fmt.Printf("The %s %s is %s of %s\n", args[2], args[3], role, args[1])
+		return nil
+	case len(args) == 4 && args[0] == "remove":
+		if err := repo.RemoveCollaborator(args[1], args[2], args[3], ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Removed the role of %s %s on %s\n", args[2], args[3], args[1])
+		return nil
+	default:
+		return errors.New("usage: role list <project> | role set <project> user|group <name> <role> | role remove <project> user|group <name>")
+	}
+}
+
+// runGroup lists, creates and deletes groups, and manages their members
+func runGroup(repo *repository.Repository, args []string) error {
+	ctx := commandContext()
+	var err error
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		groups, err := repo.GetAllGroups(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "GROUP\tMEMBERS")
+		for _, g := range groups {
+			fmt.Fprintf(tw, "%s\t%s\n", g.Name, strings.Join(g.Members, ","))
+		}
+		return tw.Flush()
+	case len(args) == 2 && args[0] == "create":
+		_, err = repo.CreateGroup(args[1], ctx)
+	case len(args) == 2 && args[0] == "delete":
+		err = repo.DeleteGroup(args[1], ctx)
+	case len(args) == 3 && args[0] == "add":
+		err = repo.AddGroupMember(args[1], args[2], ctx)
+	case len(args) == 3 && args[0] == "remove":
+		err = repo.RemoveGroupMember(args[1], args[2], ctx)
+	default:
+		return errors.New("usage: group list | group create|delete <group> | group add|remove <group> <username>")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Done")
+	return nil
+}
+
+// claimList collects the values of a repeated -claim flag
+type claimList map[string]string
+
+func (l claimList) String() string {
+	pairs := make([]string, 0, len(l))
+	for name, value := range l {
+		pairs = append(pairs, name+"="+value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l claimList) Set(v string) error {
+	name, value, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected <name>=<value>, got %q", v)
+	}
+	l[name] = value
+	return nil
+}
+
+// runPublisher lists, registers and removes the trusted publishers of projects
+func runPublisher(repo *repository.Repository, audience string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: publisher list|add|remove")
+	}
+	ctx := commandContext()
+
+	switch args[0] {
+	case "list":
+		if len(args) > 2 {
+			return errors.New("usage: publisher list [project]")
+		}
+		var project string
+		if len(args) == 2 {
+			project = args[1]
+		}
+		publishers, err := repo.GetTrustedPublishers(project, ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tPROJECT\tISSUER\tAUDIENCE\tCLAIMS")
+		for _, tp := range publishers {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", tp.ID, tp.Project, tp.Issuer, tp.Audience, claimList(tp.Claims))
+		}
+		return tw.Flush()
+	case "add":
+		fs := flag.NewFlagSet("publisher add", flag.ContinueOnError)
+		aud := fs.String("audience", audience, "Audience of the OIDC tokens")
+		claims := make(claimList)
+		fs.Var(claims, "claim", "Claim the tokens must have, as <name>=<value>, can be repeated")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return errors.New("usage: publisher add [-audience <aud>] -claim <name>=<value>... <project> <issuer>")
+		}
+		ins := &repository.TrustedPublisherInsert{Issuer: fs.Arg(1), Audience: *aud, Claims: claims}
+		tp, err := repo.AddTrustedPublisher(fs.Arg(0), ins, ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added trusted publisher %d to %s\n", tp.ID, tp.Project)
+		return nil
+	case "remove":
+		if len(args) != 3 {
+			return errors.New("usage: publisher remove <project> <id>")
+		}
+		id, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid publisher id %q", args[2])
+		}
+		if err := repo.RemoveTrustedPublisher(args[1], id, ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Removed trusted publisher %d\n", id)
+		return nil
+	default:
+		return fmt.Errorf("unknown publisher command %q, expected list, add or remove", args[0])
+	}
+}
+
+// runSource lists, shows and sets the source policies of projects
+func runSource(repo *repository.Repository, args []string) error {
+	ctx := commandContext()
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		sources, err := repo.GetSourcePolicies(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PROJECT\tPOLICY\tSINCE")
+		for _, ps := range sources {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", ps.Project, ps.Policy, ps.CreatedAt.UTC().Format(time.RFC3339))
+		}
+		return tw.Flush()
+	case len(args) == 2 && args[0] == "get":
+		policy, err := repo.GetSourcePolicy(args[1], ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := repo.GetProjectSource(args[1], ctx); errors.Is(err, repository.ErrSourcePolicyNotFound) {
+			fmt.Printf("%s (default)\n", policy)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fmt.Println(policy)
+		return nil
+	case len(args) == 3 && args[0] == "set":
+		policy, err := repository.ParseSourcePolicy(args[2])
+		if err != nil {
+			return err
+		}
+		if err := repo.SetSourcePolicy(args[1], policy, ctx); err != nil {
+			return err
+		}
+		fmt.Printf("%s is served from %s\n", args[1], sourceDescriptions[policy])
+		return nil
+	case len(args) == 2 && args[0] == "reset":
+		if err := repo.ResetSourcePolicy(args[1], ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Reset the source policy of %s\n", args[1])
+		return nil
+	default:
+		return errors.New("usage: source list | source get <project> | source set <project> local|upstream|merged | source reset <project>")
+	}
+}
+
+// sourceDescriptions describes where projects are served from for each source policy
+var sourceDescriptions = map[repository.SourcePolicy]string{
+	repository.SourceLocal:    "the local index only",
+	repository.SourceUpstream: "the upstream index only",
+	repository.SourceMerged:   "both the local and upstream indexes",
+}
+
+// runIndex lists, creates, updates and deletes indexes
+func runIndex(repo *repository.Repository, dataPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: index list|create|update|delete")
+	}
+	ctx := commandContext()
+
+	switch args[0] {
+	case "list":
+		indexes, err := repo.GetIndexes(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSTORAGE PREFIX\tPRIVATE\tUPLOADS\tUPSTREAM\tLAYERS")
+		for _, idx := range indexes {
+			private := "default"
+			if idx.Private != nil {
+				private = strconv.FormatBool(*idx.Private)
+			}
+			uploads := cmp.Or(string(idx.UploadPolicy), "default")
+			layers := "-"
+			if idx.Virtual() {
+				uploads = "to " + cmp.Or(idx.UploadTarget, "nowhere")
+				layers = fmt.Sprintf("%s (%s)", strings.Join(idx.Layers, ","), idx.Resolution)
+			}
+			fmt.Fprintf(
+				tw,
+				"%s\t%s\t%s\t%s\t%s\t%s\n",
+				idx.Name,
+				cmp.Or(idx.StoragePrefix, "-"),
+				private,
+				uploads,
+				cmp.Or(idx.UpstreamURL, "-"),
+				layers,
+			)
+		}
+		return tw.Flush()
+	case "create", "update":
+		fs := flag.NewFlagSet("index "+args[0], flag.ContinueOnError)
+		private := fs.String("private", "", "Require authentication to read the index, true or false")
+		policy := fs.String("upload-policy", "", "Who can upload: authenticated, anonymous or disabled")
+		upstreamURL := fs.String("upstream-url", "", "Simple API of an upstream index serving missing projects")
+		var layers projectList
+		fs.Var(&layers, "layer", "Index layered by a virtual index, repeated in priority order")
+		resolution := fs.String("resolution", "", "How a virtual index resolves projects: first-match or union")
+		uploadTarget := fs.String("upload-target", "", "Index which uploads to a virtual index go to")
+		var prefix *string
+		if args[0] == "create" {
+			prefix = fs.String("storage-prefix", "", "Directory of the index's files, defaults to its name")
+		}
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: index %s [flags] <name>", args[0])
+		}
+		settings := &repository.Index{
+			Name:         fs.Arg(0),
+			UploadPolicy: repository.UploadPolicy(*policy),
+			UpstreamURL:  *upstreamURL,
+			Layers:       layers,
+			Resolution:   repository.Resolution(*resolution),
+			UploadTarget: *uploadTarget,
+		}
+		if *private != "" {
+			v, err := strconv.ParseBool(*private)
+			if err != nil {
+				return fmt.Errorf("invalid -private value %q", *private)
+			}
+			settings.Private = &v
+		}
+
+		var idx *repository.Index
+		var err error
+		if prefix != nil {
+			settings.StoragePrefix = *prefix
+			idx, err = repo.CreateIndex(settings, ctx)
+		} else {
+			idx, err = repo.UpdateIndex(settings.Name, settings, ctx)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Index %s is served under /%s/\n", idx.Name, idx.Name)
+		return nil
+	case "delete":
+		if len(args) != 2 {
+			return errors.New("usage: index delete <name>")
+		}
+		idx, err := repo.DeleteIndex(args[1], ctx)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(indexDataPath(dataPath, idx)); err != nil {
+			return fmt.Errorf("deleted index %s but not its files: %w", idx.Name, err)
+		}
+		fmt.Printf("Deleted index %s and its files\n", args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown index command %q, expected list, create, update or delete", args[0])
+	}
+}
+
+// formatOptionalTime formats a time which may be missing
+func formatOptionalTime(t *time.Time, missing string) string {
+	if t == nil {
+		return missing
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -1,10 +1,59 @@
 package main
 
-// APIVersion Pip API version
-const APIVersion = "1.0"
+import "time"
+
+// APIVersion Pip API version, 1.2 with the sizes and upload times of PEP 700 and the
+// tracks and alternate locations of PEP 708. Provenance (PEP 740, 1.3) is not implemented,
+// so project statuses (PEP 792) are served as an optional field of 1.2.
+const APIVersion = "1.2"
 
 // JSONHeader Content-Type for JSON responses
 const JSONHeader = "application/vnd.pypi.simple.v1+json"
 
-// RequiredFields are the fields required to be in package metadata
-const RequiredFields = "name;version;metadata-version;filetype"
+// HTMLHeader Content-Type for HTML responses (PEP 691)
+const HTMLHeader = "application/vnd.pypi.simple.v1+html"
+
+// LegacyHTMLHeader Content-Type for HTML responses to PEP 503 clients
+const LegacyHTMLHeader = "text/html"
+
+// SimpleIndexTemplate is the HTML template for the /simple/ endpoint
+const SimpleIndexTemplate = "simple-index.html"
+
+// SimpleProjectTemplate is the HTML template for the /simple/<project>/ endpoint
+const SimpleProjectTemplate = "simple-project.html"
+
+// FormatQueryParam is the query parameter that overrides the Accept header of Simple API requests
+const FormatQueryParam = "format"
+
+// RequiredFields are the form fields required in upload requests (semicolon-separated)
+const RequiredFields = "name;version;metadata_version;filetype"
+
+// UploadTimeFormat is the ISO 8601 format of upload times in Simple API responses
+const UploadTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// FileCacheControl is the Cache-Control header for distribution files, which never change once uploaded
+const FileCacheControl = "public, max-age=31536000, immutable"
+
+// PrivateFileCacheControl is the Cache-Control header for distribution files on private servers
+const PrivateFileCacheControl = "private, max-age=31536000, immutable"
+
+// MetadataFileSuffix is appended to the file name of a distribution to get its core metadata file (PEP 658)
+const MetadataFileSuffix = ".metadata"
+
+// MaxFormFieldsSize is the maximum total size of the non-file fields of an upload request
+const MaxFormFieldsSize = 10 << 20
+
+// UploadFileField is the upload form field carrying the distribution file
+const UploadFileField = "content"
+
+// UpstreamCacheDir is the directory under the data path where pages and files of the
+// upstream index are cached, which cannot clash with normalized project names
+const UpstreamCacheDir = "_upstream"
+
+// IndexesDir is the directory under the data path where the files of indexes other than
+// the default one are stored, under their storage prefix
+const IndexesDir = "_indexes"
+
+// IndexCacheTTL is how long the settings of an index are cached for its requests, which
+// bounds how long changes made with the index commands take to reach a running server
+const IndexCacheTTL = 30 * time.Second
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"go-pip-server/distribution"
+	"go-pip-server/pep440"
+	"go-pip-server/repository"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// readFileMetadata extracts the core metadata of an uploaded distribution file and
+// checks that the name and version it declares match the ones given in the form. The
+// version must be given in its PEP 440 normal form. Invalid files are reported as *uploadError.
+func readFileMetadata(fp, filename, name, version string) ([]*repository.KeyVal, error) {
+	meta, err := distribution.ReadCoreMetadata(fp, filename)
+	if err != nil {
+		return nil, badUpload(fmt.Sprintf("Invalid distribution file. Error: %v", err))
+	}
+
+	if repository.NormalizeName(meta.Name()) != repository.NormalizeName(name) {
+		return nil, invalidField(
+			"name",
+			fmt.Sprintf("The name %q does not match the name %q in the distribution metadata.", name, meta.Name()),
+		)
+	}
+	if mv, err := pep440.Normalize(meta.Version()); err != nil || mv != version {
+		return nil, invalidField(
+			"version",
+			fmt.Sprintf("The version %q does not match the version %q in the distribution metadata.", version, meta.Version()),
+		)
+	}
+	return metadataKeyVals(meta), nil
+}
+
+// metadataKeyVals converts parsed core metadata into key-value pairs for storage.
+// Multi-valued fields are stored as one pair per value.
+func metadataKeyVals(meta *distribution.CoreMetadata) []*repository.KeyVal {
+	kvs := make([]*repository.KeyVal, 0, len(meta.Fields)+1)
+	for _, f := range meta.Fields {
+		kvs = append(kvs, &repository.KeyVal{Key: f.Key, Val: f.Value})
+	}
+	if meta.Description != "" {
+		kvs = append(kvs, &repository.KeyVal{Key: "description", Val: meta.Description})
+	}
+	return kvs
+}
+
+// writeMetadataFile extracts the core metadata file of the distribution at src and stores it
+// at dst, normally next to the distribution, so that it can be served on its own (PEP 658).
+// Returns the SHA256 digest of the metadata file.
+func writeMetadataFile(src, filename, dst string) (string, error) {
+	raw, err := distribution.ReadMetadataFile(src, filename)
+	if err != nil {
+		return "", fmt.Errorf("error reading distribution metadata: %w", err)
+	}
+	err = os.WriteFile(dst, raw, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error writing metadata file: %w", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(raw)), nil
+}
+
+// isWheel checks if a distribution file is a wheel. Metadata files are only served for
+// wheels, as the PKG-INFO of an sdist may not reflect the metadata of built packages.
+func isWheel(filename string) bool {
+	return strings.HasSuffix(filename, ".whl")
+}
+
+// extractMissingMetadata extracts the metadata files of the wheels uploaded before
+// metadata files were stored, and records their digest. It runs in the background while
+// the server is up, pages announcing metadata files as they are extracted. Wheels whose
+// metadata cannot be read are recorded without a metadata file, and not retried.
+func (p *PipServer) extractMissingMetadata(c context.Context) error {
+	files, err := p.Repo.GetWheelsWithoutMetadata(c)
+	if err != nil {
+		return err
+	}
+	extracted := 0
+	for _, f := range files {
+		digest, err := writeMetadataFile(f.FilePath, f.Filename, f.FilePath+MetadataFileSuffix)
+		if err != nil {
+			slog.Warn("Unable to extract metadata file", "file", f.FilePath, "error", err)
+		}
+		if err := p.Repo.SetFileMetadataDigest(f.ID, digest, c); err != nil {
+			return fmt.Errorf("error storing metadata digest: %w", err)
+		}
+		if digest != "" {
+			extracted++
+		}
+	}
+	if extracted > 0 {
+		slog.Info("Extracted the metadata files of older wheels", "files", extracted)
+	}
+	return nil
+}
+
+// metadataValue returns the first value of a metadata field, or an empty string
+func metadataValue(kvs []*repository.KeyVal, key string) string {
+	for _, kv := range kvs {
+		if kv.Key == key {
+			return kv.Val
+		}
+	}
+	return ""
+}
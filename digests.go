@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// fileDigests holds the hex-encoded digests of an uploaded file
+type fileDigests struct {
+	SHA256     string
+	MD5        string
+	Blake2b256 string
+}
+
+// digester computes all the supported digests of a file in a single pass
+type digester struct {
+	sha256     hash.Hash
+	md5        hash.Hash
+	blake2b256 hash.Hash
+}
+
+// newDigester creates a digester for the SHA256, MD5 and BLAKE2b-256 digests
+func newDigester() *digester {
+	b2, err := blake2b.New256(nil)
+	if err != nil {
+		// Only fails for invalid keys, and no key is used
+		panic(err)
+	}
+	return &digester{
+		sha256:     sha256.New(),
+		md5:        md5.New(),
+		blake2b256: b2,
+	}
+}
+
+// Writer returns a writer that feeds all the hashes
+func (d *digester) Writer() io.Writer {
+	return io.MultiWriter(d.sha256, d.md5, d.blake2b256)
+}
+
+// Sum returns the digests of the data written so far
+func (d *digester) Sum() *fileDigests {
+	return &fileDigests{
+		SHA256:     hex.EncodeToString(d.sha256.Sum(nil)),
+		MD5:        hex.EncodeToString(d.md5.Sum(nil)),
+		Blake2b256: hex.EncodeToString(d.blake2b256.Sum(nil)),
+	}
+}
+
+// ByType returns the digest for a digest type name as used in upload forms,
+// or false if the type is not supported.
+func (d *fileDigests) ByType(digestType string) (string, bool) {
+	switch digestType {
+	case "sha256":
+		return d.SHA256, true
+	case "md5":
+		return d.MD5, true
+	case "blake2_256":
+		return d.Blake2b256, true
+	default:
+		return "", false
+	}
+}
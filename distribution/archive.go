@@ -0,0 +1,143 @@
+package distribution
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"go-pip-server/pep440"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// MaxMetadataSize is the largest metadata file that will be read from a distribution
+const MaxMetadataSize = 16 << 20
+
+// ErrMetadataNotFound is returned when a distribution does not contain a metadata file
+var ErrMetadataNotFound = errors.New("metadata file not found in distribution")
+
+// ReadMetadataFile extracts the raw core metadata file from the distribution at fp, whose
+// file name is given separately as it may be stored under another name: the
+// {name}-{version}.dist-info/METADATA file of a wheel, or the top-level PKG-INFO file of
+// an sdist.
+func ReadMetadataFile(fp, filename string) ([]byte, error) {
+	switch {
+	case strings.HasSuffix(filename, ".whl"):
+		wf, err := ParseWheelFilename(filename)
+		if err != nil {
+			return nil, err
+		}
+		return readZipMember(fp, func(name string) bool { return isWheelMetadata(name, wf.Name, wf.Version) })
+	case strings.HasSuffix(filename, ".tar.gz"):
+		return readTarGzMember(fp, isSdistMetadata)
+	case strings.HasSuffix(filename, ".zip"):
+		return readZipMember(fp, isSdistMetadata)
+	default:
+		return nil, fmt.Errorf("unsupported distribution file: %s", filename)
+	}
+}
+
+// ReadCoreMetadata extracts and parses the core metadata of a distribution
+func ReadCoreMetadata(fp, filename string) (*CoreMetadata, error) {
+	raw, err := ReadMetadataFile(fp, filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCoreMetadata(bytes.NewReader(raw))
+}
+
+// isWheelMetadata checks if an archive member is the METADATA file of a wheel, in the
+// .dist-info directory of the project and version of the wheel. Wheels may vendor the
+// .dist-info directories of other projects.
+func isWheelMetadata(member, name, version string) bool {
+	dir, file := path.Split(member)
+	stem, ok := strings.CutSuffix(strings.TrimSuffix(dir, "/"), ".dist-info")
+	if file != "METADATA" || !ok || strings.Contains(stem, "/") {
+		return false
+	}
+	dirName, dirVersion, ok := cutLast(stem, "-")
+	return ok && NormalizeName(dirName) == NormalizeName(name) && sameVersion(dirVersion, version)
+}
+
+// sameVersion compares versions in their PEP 440 normal form, or as given if they are not
+// valid versions
+func sameVersion(a, b string) bool {
+	na, errA := pep440.Normalize(a)
+	nb, errB := pep440.Normalize(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return na == nb
+}
+
+// isSdistMetadata checks if an archive member is the PKG-INFO file at the root of an sdist
+func isSdistMetadata(name string) bool {
+	parts := strings.Split(strings.TrimPrefix(name, "./"), "/")
+	return len(parts) == 2 && parts[1] == "PKG-INFO"
+}
+
+// readZipMember reads the first member of a zip archive matching the given predicate
+func readZipMember(fp string, match func(string) bool) ([]byte, error) {
+	zr, err := zip.OpenReader(fp)
+	if err != nil {
+		return nil, fmt.Errorf("error opening zip archive: %w", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !match(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return readLimited(rc)
+	}
+	return nil, ErrMetadataNotFound
+}
+
+// readTarGzMember reads the first member of a gzipped tar archive matching the given predicate
+func readTarGzMember(fp string, match func(string) bool) ([]byte, error) {
+	fh, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	gz, err := gzip.NewReader(fh)
+	if err != nil {
+		return nil, fmt.Errorf("error opening gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, ErrMetadataNotFound
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading tar archive: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg && match(hdr.Name) {
+			return readLimited(tr)
+		}
+	}
+}
+
+// readLimited reads a metadata file, refusing files larger than MaxMetadataSize
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxMetadataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxMetadataSize {
+		return nil, fmt.Errorf("metadata file exceeds %d bytes", MaxMetadataSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,119 @@
+package distribution
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeZip creates a zip archive with the given members
+func writeZip(t *testing.T, fp string, members map[string]string) {
+	fh, err := os.Create(fp)
+	if err != nil {
+		t.Fatalf("Error creating archive: %v", err)
+	}
+	defer fh.Close()
+	zw := zip.NewWriter(fh)
+	for name, content := range members {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Error adding archive member: %v", err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Error closing archive: %v", err)
+	}
+}
+
+// writeTarGz creates a gzipped tar archive with the given members
+func writeTarGz(t *testing.T, fp string, members map[string]string) {
+	fh, err := os.Create(fp)
+	if err != nil {
+		t.Fatalf("Error creating archive: %v", err)
+	}
+	defer fh.Close()
+	gz := gzip.NewWriter(fh)
+	tw := tar.NewWriter(gz)
+	for name, content := range members {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Error adding archive member: %v", err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+}
+
+// TestIsWheelMetadata tests matching the METADATA file of a wheel's project and version
+func TestIsWheelMetadata(t *testing.T) {
+	for member, expected := range map[string]bool{
+		"my_pkg-1.0.dist-info/METADATA":     true,
+		"My.Pkg-01.0.dist-info/METADATA":    true,
+		"my_pkg-1.0.dist-info/RECORD":       false,
+		"my_pkg-1.1.dist-info/METADATA":     false,
+		"other-1.0.dist-info/METADATA":      false,
+		"my_pkg.dist-info/METADATA":         false,
+		"lib/my_pkg-1.0.dist-info/METADATA": false,
+	} {
+		if isWheelMetadata(member, "my_pkg", "1.0") != expected {
+			t.Errorf("Expected isWheelMetadata(%q) to be %v", member, expected)
+		}
+	}
+}
+
+// TestReadCoreMetadata tests extracting metadata from wheels and sdists
+func TestReadCoreMetadata(t *testing.T) {
+	dir := t.TempDir()
+	meta := "Metadata-Version: 2.1\nName: pkg\nVersion: 1.0\n"
+
+	wheel := filepath.Join(dir, "pkg-1.0-py3-none-any.whl")
+	writeZip(t, wheel, map[string]string{
+		"pkg/__init__.py":                 "",
+		"pkg/sub.dist-info/METADATA":      "Name: wrong\nVersion: 0\n",
+		"vendored-2.0.dist-info/METADATA": "Name: vendored\nVersion: 2.0\n",
+		"pkg-1.0.dist-info/METADATA":      meta,
+		"pkg-1.0.dist-info/WHEEL":         "Wheel-Version: 1.0\n",
+		"pkg-1.0.dist-info/RECORD":        "",
+	})
+	sdist := filepath.Join(dir, "pkg-1.0.tar.gz")
+	writeTarGz(t, sdist, map[string]string{
+		"pkg-1.0/src/PKG-INFO": "Name: wrong\nVersion: 0\n",
+		"pkg-1.0/PKG-INFO":     meta,
+		"pkg-1.0/setup.py":     "",
+	})
+	zipSdist := filepath.Join(dir, "pkg-1.0.zip")
+	writeZip(t, zipSdist, map[string]string{"pkg-1.0/PKG-INFO": meta})
+
+	for _, fp := range []string{wheel, sdist, zipSdist} {
+		m, err := ReadCoreMetadata(fp, filepath.Base(fp))
+		if err != nil {
+			t.Fatalf("ReadCoreMetadata(%s) failed: %v", filepath.Base(fp), err)
+		}
+		if m.Name() != "pkg" || m.Version() != "1.0" {
+			t.Errorf("Unexpected metadata from %s: %+v", filepath.Base(fp), m)
+		}
+	}
+
+	// Archives without metadata are reported as such
+	empty := filepath.Join(dir, "empty-1.0-py3-none-any.whl")
+	writeZip(t, empty, map[string]string{"empty/__init__.py": ""})
+	if _, err := ReadMetadataFile(empty, filepath.Base(empty)); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("Expected ErrMetadataNotFound, got %v", err)
+	}
+	// Only the .dist-info directory of the wheel's own project and version is read
+	if _, err := ReadMetadataFile(wheel, "other-1.0-py3-none-any.whl"); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("Expected ErrMetadataNotFound for another project, got %v", err)
+	}
+	if _, err := ReadMetadataFile(wheel, "pkg-2.0-py3-none-any.whl"); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("Expected ErrMetadataNotFound for another version, got %v", err)
+	}
+	if _, err := ReadMetadataFile(filepath.Join(dir, "pkg-1.0.exe"), "pkg-1.0.exe"); err == nil {
+		t.Errorf("Expected error for unsupported file type")
+	}
+}
@@ -0,0 +1,135 @@
+package distribution
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidFilename is returned when a file name does not follow the distribution naming specs
+var ErrInvalidFilename = errors.New("invalid file name")
+
+// wheelNamePart matches the name and version components of a wheel file name, in which
+// dashes are escaped as underscores
+var wheelNamePart = regexp.MustCompile(`^[A-Za-z0-9._+!]+$`)
+
+// wheelBuildTag matches the optional build tag of a wheel, which must start with a digit
+var wheelBuildTag = regexp.MustCompile(`^[0-9][A-Za-z0-9._]*$`)
+
+// wheelTag matches the compatibility tags of a wheel, which may be compressed tag sets
+var wheelTag = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// nameSeparators matches the runs of separators which PEP 503 normalizes to a dash
+var nameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// sdistExtensions lists the archive formats of source distributions
+var sdistExtensions = []string{".tar.gz", ".zip"}
+
+// Filename holds the components of a distribution file name
+type Filename struct {
+	Name        string
+	Version     string
+	BuildTag    string
+	PythonTag   string
+	ABITag      string
+	PlatformTag string
+	// Wheel is true for binary distributions and false for source distributions
+	Wheel bool
+}
+
+// ParseWheelFilename parses a file name following the binary distribution format:
+// {name}-{version}(-{build tag})?-{python tag}-{abi tag}-{platform tag}.whl
+func ParseWheelFilename(fn string) (*Filename, error) {
+	if err := checkBaseName(fn); err != nil {
+		return nil, err
+	}
+	stem, ok := strings.CutSuffix(fn, ".whl")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q does not end with .whl", ErrInvalidFilename, fn)
+	}
+	parts := strings.Split(stem, "-")
+	if len(parts) != 5 && len(parts) != 6 {
+		return nil, fmt.Errorf("%w: %q does not have 5 or 6 dash-separated components", ErrInvalidFilename, fn)
+	}
+
+	wf := &Filename{
+		Name:        parts[0],
+		Version:     parts[1],
+		PythonTag:   parts[len(parts)-3],
+		ABITag:      parts[len(parts)-2],
+		PlatformTag: parts[len(parts)-1],
+		Wheel:       true,
+	}
+	if !wheelNamePart.MatchString(wf.Name) {
+		return nil, fmt.Errorf("%w: invalid project name %q in %q", ErrInvalidFilename, wf.Name, fn)
+	}
+	if !wheelNamePart.MatchString(wf.Version) {
+		return nil, fmt.Errorf("%w: invalid version %q in %q", ErrInvalidFilename, wf.Version, fn)
+	}
+	if len(parts) == 6 {
+		wf.BuildTag = parts[2]
+		if !wheelBuildTag.MatchString(wf.BuildTag) {
+			return nil, fmt.Errorf("%w: invalid build tag %q in %q", ErrInvalidFilename, wf.BuildTag, fn)
+		}
+	}
+	for _, tag := range []string{wf.PythonTag, wf.ABITag, wf.PlatformTag} {
+		if !wheelTag.MatchString(tag) {
+			return nil, fmt.Errorf("%w: invalid tag %q in %q", ErrInvalidFilename, tag, fn)
+		}
+	}
+	return wf, nil
+}
+
+// ParseSdistFilename parses a file name following the source distribution format:
+// {name}-{version}.tar.gz. Legacy sdists may use .zip archives and have dashes in the
+// name, so the version is taken after the last dash.
+func ParseSdistFilename(fn string) (*Filename, error) {
+	if err := checkBaseName(fn); err != nil {
+		return nil, err
+	}
+	var stem string
+	for _, ext := range sdistExtensions {
+		if s, ok := strings.CutSuffix(fn, ext); ok {
+			stem = s
+			break
+		}
+	}
+	if stem == "" {
+		return nil, fmt.Errorf(
+			"%w: %q does not have a source distribution extension (%s)",
+			ErrInvalidFilename, fn, strings.Join(sdistExtensions, ", "),
+		)
+	}
+
+	name, version, ok := cutLast(stem, "-")
+	if !ok || name == "" || version == "" {
+		return nil, fmt.Errorf("%w: %q is not of the form {name}-{version}", ErrInvalidFilename, fn)
+	}
+	return &Filename{Name: name, Version: version}, nil
+}
+
+// NormalizeName normalizes a project name following PEP 503, so that names differing
+// only in case or in the use of '-', '_' and '.' refer to the same project.
+func NormalizeName(n string) string {
+	return strings.ToLower(nameSeparators.ReplaceAllString(n, "-"))
+}
+
+// checkBaseName rejects file names which are not plain base names, such as paths
+func checkBaseName(fn string) error {
+	if fn == "" || fn == "." || fn == ".." || strings.ContainsAny(fn, "/\\\x00") {
+		return fmt.Errorf("%w: %q is not a plain file name", ErrInvalidFilename, fn)
+	}
+	if strings.HasPrefix(fn, ".") {
+		return fmt.Errorf("%w: %q is a hidden file name", ErrInvalidFilename, fn)
+	}
+	return nil
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,67 @@
+package distribution
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestParseWheelFilename tests parsing of valid and invalid wheel file names
+func TestParseWheelFilename(t *testing.T) {
+	wf, err := ParseWheelFilename("demo_pkg-1.0.0-1build-cp312-cp312-manylinux_2_17_x86_64.manylinux2014_x86_64.whl")
+	if err != nil {
+		t.Fatalf("ParseWheelFilename failed: %v", err)
+	}
+	expected := Filename{
+		Name:        "demo_pkg",
+		Version:     "1.0.0",
+		BuildTag:    "1build",
+		PythonTag:   "cp312",
+		ABITag:      "cp312",
+		PlatformTag: "manylinux_2_17_x86_64.manylinux2014_x86_64",
+		Wheel:       true,
+	}
+	if *wf != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *wf)
+	}
+
+	invalid := []string{
+		"demo_pkg-1.0.0-py3-none-any.tar.gz",
+		"demo_pkg-1.0.0-any.whl",
+		"demo-pkg-1.0.0-py3-none-any-extra.whl",
+		"demo_pkg-1.0.0-build-py3-none-any.whl",
+		"../demo_pkg-1.0.0-py3-none-any.whl",
+		".demo_pkg-1.0.0-py3-none-any.whl",
+		"demo pkg-1.0.0-py3-none-any.whl",
+	}
+	for _, fn := range invalid {
+		if _, err := ParseWheelFilename(fn); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("Expected %q to be invalid, got %v", fn, err)
+		}
+	}
+}
+
+// TestParseSdistFilename tests parsing of valid and invalid sdist file names
+func TestParseSdistFilename(t *testing.T) {
+	cases := map[string]Filename{
+		"demo_pkg-1.0.0.tar.gz":  {Name: "demo_pkg", Version: "1.0.0"},
+		"Demo-Pkg-1.0.0rc1.zip":  {Name: "Demo-Pkg", Version: "1.0.0rc1"},
+		"demo.pkg-2024.1.tar.gz": {Name: "demo.pkg", Version: "2024.1"},
+	}
+	for fn, expected := range cases {
+		sf, err := ParseSdistFilename(fn)
+		if err != nil {
+			t.Errorf("ParseSdistFilename(%q) failed: %v", fn, err)
+			continue
+		}
+		if *sf != expected {
+			t.Errorf("Expected %+v for %q, got %+v", expected, fn, *sf)
+		}
+	}
+
+	invalid := []string{"demo_pkg-1.0.0.tar.bz2", "demo_pkg.tar.gz", "-1.0.tar.gz", "dir/demo-1.0.tar.gz", ".."}
+	for _, fn := range invalid {
+		if _, err := ParseSdistFilename(fn); !errors.Is(err, ErrInvalidFilename) {
+			t.Errorf("Expected %q to be invalid, got %v", fn, err)
+		}
+	}
+}
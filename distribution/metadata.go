@@ -0,0 +1,113 @@
+// Package distribution reads Python distribution files (wheels and sdists) and the
+// core metadata they contain.
+package distribution
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Field is a single field of the core metadata. Multi-valued fields such as
+// Requires-Dist or Classifier appear once per value.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// CoreMetadata holds the parsed core metadata of a distribution. Field keys are
+// lower-cased. Description holds the message body, which is where metadata 2.1+
+// places the long description.
+type CoreMetadata struct {
+	Fields      []Field
+	Description string
+}
+
+// Get returns the first value of a field, or an empty string if it is not present.
+func (m *CoreMetadata) Get(key string) string {
+	key = strings.ToLower(key)
+	for _, f := range m.Fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// GetAll returns all values of a multi-valued field.
+func (m *CoreMetadata) GetAll(key string) []string {
+	key = strings.ToLower(key)
+	out := make([]string, 0, 4)
+	for _, f := range m.Fields {
+		if f.Key == key {
+			out = append(out, f.Value)
+		}
+	}
+	return out
+}
+
+// Name returns the project name declared in the metadata
+func (m *CoreMetadata) Name() string {
+	return m.Get("name")
+}
+
+// Version returns the project version declared in the metadata
+func (m *CoreMetadata) Version() string {
+	return m.Get("version")
+}
+
+// ParseCoreMetadata parses core metadata in the RFC 822 format used by METADATA and
+// PKG-INFO files. Continuation lines are joined to the preceding field with newlines,
+// which keeps multi-line descriptions in older metadata versions readable.
+func ParseCoreMetadata(r io.Reader) (*CoreMetadata, error) {
+	br := bufio.NewReader(r)
+	meta := &CoreMetadata{Fields: make([]Field, 0, 32)}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		eof := err == io.EOF
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			// A blank line ends the headers, the rest of the message is the description
+			if !eof {
+				body, err := io.ReadAll(br)
+				if err != nil {
+					return nil, err
+				}
+				meta.Description = string(bytes.TrimRight(body, "\r\n"))
+			}
+			break
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(meta.Fields) == 0 {
+				return nil, fmt.Errorf("continuation line before first field: %q", line)
+			}
+			last := &meta.Fields[len(meta.Fields)-1]
+			last.Value += "\n" + strings.TrimLeft(line, " \t")
+		} else {
+			key, val, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, fmt.Errorf("malformed metadata line: %q", line)
+			}
+			meta.Fields = append(meta.Fields, Field{
+				Key:   strings.ToLower(strings.TrimSpace(key)),
+				Value: strings.TrimSpace(val),
+			})
+		}
+		if eof {
+			break
+		}
+	}
+
+	if meta.Name() == "" || meta.Version() == "" {
+		return nil, fmt.Errorf("metadata is missing the name or version field")
+	}
+	return meta, nil
+}
@@ -0,0 +1,70 @@
+package distribution
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMetadata = `Metadata-Version: 2.1
+Name: My_Package
+Version: 1.2.0
+Summary: A test package
+Requires-Python: >=3.9
+Classifier: Programming Language :: Python :: 3
+Classifier: License :: OSI Approved :: MIT License
+Requires-Dist: requests>=2.0
+Requires-Dist: click; extra == "cli"
+License: MIT
+        with a second line
+Description-Content-Type: text/markdown
+
+# My Package
+
+The long description.
+`
+
+// TestParseCoreMetadata tests parsing of single and multi-valued metadata fields
+func TestParseCoreMetadata(t *testing.T) {
+	meta, err := ParseCoreMetadata(strings.NewReader(testMetadata))
+	if err != nil {
+		t.Fatalf("ParseCoreMetadata failed: %v", err)
+	}
+
+	if meta.Name() != "My_Package" {
+		t.Errorf("Expected name My_Package, got %s", meta.Name())
+	}
+	if meta.Version() != "1.2.0" {
+		t.Errorf("Expected version 1.2.0, got %s", meta.Version())
+	}
+	if rp := meta.Get("Requires-Python"); rp != ">=3.9" {
+		t.Errorf("Expected requires-python >=3.9, got %s", rp)
+	}
+	if cs := meta.GetAll("classifier"); len(cs) != 2 {
+		t.Errorf("Expected 2 classifiers, got %v", cs)
+	}
+	rd := meta.GetAll("requires-dist")
+	if len(rd) != 2 || rd[1] != `click; extra == "cli"` {
+		t.Errorf("Unexpected requires-dist values: %v", rd)
+	}
+	if l := meta.Get("license"); l != "MIT\nwith a second line" {
+		t.Errorf("Expected continuation line to be joined, got %q", l)
+	}
+	if meta.Description != "# My Package\n\nThe long description." {
+		t.Errorf("Unexpected description: %q", meta.Description)
+	}
+}
+
+// TestParseCoreMetadataInvalid tests that malformed metadata is rejected
+func TestParseCoreMetadataInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"Metadata-Version: 2.1\nName: pkg\n",
+		"Metadata-Version: 2.1\nnot a header\n",
+		"  leading continuation\nName: pkg\nVersion: 1.0\n",
+	}
+	for _, c := range cases {
+		if _, err := ParseCoreMetadata(strings.NewReader(c)); err == nil {
+			t.Errorf("Expected error parsing %q", c)
+		}
+	}
+}
@@ -1,110 +1,168 @@
 package main
 
 import (
-	"crypto/sha256"
 	"fmt"
+	"go-pip-server/pep440"
 	"go-pip-server/repository"
-	"io"
 	"mime/multipart"
 	"os"
 	"path/filepath"
 )
 
-// PrepareFormData Extracts and validates form data from a multipart form for inserting a new project version.
-func (p *PipServer) PrepareFormData(f *multipart.Form) (*repository.ProjectVersionInsert, error) {
-	// Get required fields: name, version, filetype, and file content
-	name, ok := f.Value["name"]
-	if !ok || len(name) == 0 || name[0] == "" {
-		return nil, fmt.Errorf("missing required field: name")
-	}
-	delete(f.Value, "name")
+// stagedFile is a file written to a temporary path, to be moved to its final path once the
+// upload is stored in the database
+type stagedFile struct {
+	tmpPath   string
+	finalPath string
+}
+
+// stagedUpload is an upload whose files are written to disk but not yet moved into place
+type stagedUpload struct {
+	Insert    *repository.ProjectVersionInsert
+	files     []*stagedFile
+	committed bool
+}
 
-	version, ok := f.Value["version"]
-	if !ok || len(version) == 0 || version[0] == "" {
-		return nil, fmt.Errorf("missing required field: version")
+// Commit moves the staged files to their final paths. Files already moved are removed
+// again if one of them fails, so that either all files are in place or none are.
+func (s *stagedUpload) Commit() error {
+	for i, f := range s.files {
+		err := os.Rename(f.tmpPath, f.finalPath)
+		if err != nil {
+			for _, done := range s.files[:i] {
+				os.Remove(done.finalPath)
+			}
+			return fmt.Errorf("error moving file into place: %w", err)
+		}
 	}
-	delete(f.Value, "version")
+	s.committed = true
+	return nil
+}
 
-	fType, ok := f.Value["filetype"]
-	if !ok || len(fType) == 0 {
-		return nil, fmt.Errorf("missing required field: filetype")
-	} else if fType[0] != "source" && fType[0] != "bdist_wheel" {
-		return nil, fmt.Errorf("invalid filetype: %s", fType[0])
+// Discard removes the temporary files of an upload that was not committed
+func (s *stagedUpload) Discard() {
+	if s == nil || s.committed {
+		return
+	}
+	for _, f := range s.files {
+		os.Remove(f.tmpPath)
 	}
-	delete(f.Value, "filetype")
+}
 
-	fileData, ok := f.File["content"]
-	if !ok || len(fileData) == 0 || fileData[0] == nil {
-		return nil, fmt.Errorf("missing required file: content")
+// Revert removes the files of a committed upload, when storing it in the database failed
+func (s *stagedUpload) Revert() {
+	if s == nil || !s.committed {
+		return
 	}
+	for _, f := range s.files {
+		os.Remove(f.finalPath)
+	}
+	s.committed = false
+}
 
-	// Compute SHA256 checksum of the uploaded file if not given
-	dg, ok := f.Value["digest"]
-	dt, ok2 := f.Value["digest_type"]
-	var digest, digestType string
+// stageFile creates a temporary file in the directory of its final path. The temporary name
+// keeps the file name as a suffix so that the distribution type can still be told from it.
+func (s *stagedUpload) stageFile(finalPath string) (*os.File, error) {
+	out, err := os.CreateTemp(filepath.Dir(finalPath), ".upload-*-"+filepath.Base(finalPath))
+	if err != nil {
+		return nil, err
+	}
+	s.files = append(s.files, &stagedFile{tmpPath: out.Name(), finalPath: finalPath})
+	return out, nil
+}
 
-	fh, err := fileData[0].Open()
+// PrepareFormData Reads and validates an upload form from a multipart stream for inserting a new project version.
+// The uploaded file is staged on disk under the data path of its index and must be committed
+// or discarded by the caller. Errors caused by the request itself are returned as *uploadError.
+func (p *PipServer) PrepareFormData(mr *multipart.Reader, dataPath string) (*stagedUpload, error) {
+	// Indexes created at runtime have no directory until their first upload
+	if err := os.MkdirAll(dataPath, 0755); err != nil {
+		return nil, fmt.Errorf("error creating data directory: %w", err)
+	}
+	up := &stagedUpload{}
+	us, err := readUploadStream(mr, dataPath)
+	if us.TmpPath != "" {
+		up.files = append(up.files, &stagedFile{tmpPath: us.TmpPath})
+	}
+	if err == nil {
+		err = validateUploadForm(us.Values)
+	}
+	if err == nil && us.TmpPath == "" {
+		err = badUpload("Upload payload does not have a file.")
+	}
+	if err == nil {
+		err = validateFilename(us.Filename, us.Values)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error opening uploaded file: %w", err)
+		up.Discard()
+		return nil, err
 	}
-	defer fh.Close()
+	name := formValue(us.Values, "name")
+	// Versions are stored in their normal form, the form was validated so it parses
+	version, _ := pep440.Normalize(formValue(us.Values, "version"))
+	fType := formValue(us.Values, "filetype")
 
-	if !(ok && ok2) || len(dg) == 0 || dg[0] == "" || len(dt) == 0 || dt[0] == "" {
-		h, err := computeSHA256(fh)
-		if err != nil {
-			return nil, fmt.Errorf("error computing SHA256: %w", err)
-		}
-		digest = fmt.Sprintf("%x", h)
-		digestType = "sha256"
-	} else {
-		digest, digestType = f.Value["digest"][0], f.Value["digest_type"][0]
+	err = checkDigests(us.Values, us.Digests)
+	if err != nil {
+		up.Discard()
+		return nil, err
+	}
+
+	// Extract core metadata from the saved distribution
+	meta, err := readFileMetadata(us.TmpPath, us.Filename, name, version)
+	if err != nil {
+		up.Discard()
+		return nil, err
 	}
 
-	// Save file to disk
-	fp := filepath.Join(p.DataPath, name[0])
+	// The file is moved to a directory named after the normalized project name
+	fp := filepath.Join(dataPath, repository.NormalizeName(name))
 	if _, err := os.Stat(fp); os.IsNotExist(err) {
 		err := os.MkdirAll(fp, 0755)
 		if err != nil {
+			up.Discard()
 			return nil, fmt.Errorf("error creating project directory: %w", err)
 		}
 	}
-	fp = filepath.Join(fp, fileData[0].Filename)
-	out, err := os.Create(fp)
-	if err != nil {
-		return nil, fmt.Errorf("error creating file on disk: %w", err)
-	}
-	defer out.Close()
+	fp = filepath.Join(fp, us.Filename)
+	up.files[0].finalPath = fp
 
-	_, err = io.Copy(out, fh)
-	if err != nil {
-		return nil, fmt.Errorf("error saving file to disk: %w", err)
+	var metaDigest string
+	if isWheel(fp) {
+		mf, err := up.stageFile(fp + MetadataFileSuffix)
+		if err != nil {
+			up.Discard()
+			return nil, fmt.Errorf("error creating metadata file: %w", err)
+		}
+		mf.Close()
+		metaDigest, err = writeMetadataFile(us.TmpPath, us.Filename, mf.Name())
+		if err != nil {
+			up.Discard()
+			return nil, err
+		}
 	}
 
-	vf := &repository.ProjectVersionInsert{
-		ProjectName: name[0],
-		Version:     version[0],
-		Digest:      digest,
-		DigestType:  digestType,
-		FilePath:    fp,
-		FileType:    fType[0],
-	}
-	return vf, nil
-}
-
-// computeSHA256 computes the SHA256 checksum of a multipart file.
-func computeSHA256(file multipart.File) ([]byte, error) {
-	h := sha256.New()
-	_, err := file.Seek(0, 0)
-	if err != nil {
-		return []byte{}, err
+	merged := mergeFormMetadata(meta, us.Values)
+	pyVersion := formValue(us.Values, "pyversion")
+	if pyVersion == "" && fType == "sdist" {
+		pyVersion = "source"
 	}
-	_, err = io.Copy(h, file)
-	if err != nil {
-		return []byte{}, err
-	}
-	_, err = file.Seek(0, 0)
-	if err != nil {
-		return []byte{}, err
+
+	up.Insert = &repository.ProjectVersionInsert{
+		ProjectName:      name,
+		Version:          version,
+		Filename:         us.Filename,
+		FilePath:         fp,
+		FileType:         fType,
+		PythonTag:        pyVersion,
+		RequiresPython:   metadataValue(merged, "requires-python"),
+		Size:             us.Size,
+		SHA256Digest:     us.Digests.SHA256,
+		MD5Digest:        us.Digests.MD5,
+		Blake2b256Digest: us.Digests.Blake2b256,
+		MetadataDigest:   metaDigest,
+		Metadata:         merged,
+		BeforeCommit:     up.Commit,
 	}
-	return h.Sum(nil), nil
+	return up, nil
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"go-pip-server/repository"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HandlePackageFile serves an uploaded distribution file. Range requests and
+// conditional requests are handled by http.ServeContent, using the stored digest
+// as the ETag and the upload time as the modification time.
+func (p *PipServer) HandlePackageFile(w http.ResponseWriter, r *http.Request) {
+	if !p.projectServed(w, r) {
+		return
+	}
+	filename := r.PathValue("filename")
+	if strings.HasSuffix(filename, MetadataFileSuffix) {
+		p.serveMetadataFile(w, r, strings.TrimSuffix(filename, MetadataFileSuffix))
+		return
+	}
+
+	f, err := p.Repo.GetProjectFile(r.PathValue("project"), filename, r.Context())
+	if errors.Is(err, repository.ErrFileNotFound) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("Error fetching project file", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	fh, err := os.Open(f.FilePath)
+	if err != nil {
+		slog.Error("Error opening distribution file", "file", f.FilePath, "error", err)
+		if os.IsNotExist(err) {
+			http.Error(w, "Not Found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer fh.Close()
+
+	w.Header().Set("Content-Type", distContentType(f.Filename))
+	w.Header().Set("ETag", `"`+f.SHA256Digest+`"`)
+	w.Header().Set("Cache-Control", p.fileCacheControl(r))
+	http.ServeContent(w, r, f.Filename, f.UploadTime, fh)
+}
+
+// projectServed checks that the files of the requested project can be downloaded, which
+// is not the case for quarantined and deleted projects, nor for projects the user cannot
+// read on private servers. Otherwise the error is written.
+func (p *PipServer) projectServed(w http.ResponseWriter, r *http.Request) bool {
+	readable, err := p.canRead(r, r.PathValue("project"))
+	if err != nil {
+		slog.Error("Error checking read permission", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	} else if !readable {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return false
+	}
+	proj, err := p.Repo.GetProject(r.PathValue("project"), r.Context())
+	if errors.Is(err, repository.ErrProjectNotFound) || (err == nil && !proj.Status.Listed()) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return false
+	} else if err != nil {
+		slog.Error("Error fetching project", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// serveMetadataFile serves the core metadata file of a wheel (PEP 658), which is only
+// announced for wheels whose metadata file was extracted.
+func (p *PipServer) serveMetadataFile(w http.ResponseWriter, r *http.Request, filename string) {
+	f, err := p.Repo.GetProjectFile(r.PathValue("project"), filename, r.Context())
+	if errors.Is(err, repository.ErrFileNotFound) || (err == nil && (!isWheel(f.Filename) || f.MetadataDigest == "")) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("Error fetching project file", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	fp := f.FilePath + MetadataFileSuffix
+	fh, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		slog.Error("Metadata file is missing", "file", fp)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("Error opening metadata file", "file", fp, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer fh.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", `"`+f.MetadataDigest+`"`)
+	w.Header().Set("Cache-Control", p.fileCacheControl(r))
+	http.ServeContent(w, r, filepath.Base(fp), f.UploadTime, fh)
+}
+
+// distContentType returns the Content-Type for a distribution file based on its extension
+func distContentType(filename string) string {
+	switch {
+	case strings.HasSuffix(filename, ".whl"), strings.HasSuffix(filename, ".zip"):
+		return "application/zip"
+	case strings.HasSuffix(filename, ".tar.gz"):
+		return "application/gzip"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// fileCacheControl returns the Cache-Control header for distribution files, which shared
+// caches must not keep on private indexes
+func (p *PipServer) fileCacheControl(r *http.Request) string {
+	if requestIndex(r.Context()).Private {
+		return PrivateFileCacheControl
+	}
+	return FileCacheControl
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-pip-server/repository"
+	"go-pip-server/upstream"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// servedIndexKey is the context key of the index a request is served from
+type servedIndexKey struct{}
+
+// servedIndex is an index with the settings it is served with, which follow the server
+// settings unless the index overrides them
+type servedIndex struct {
+	*repository.Index
+	// Prefix is the URL path prefix of the index, empty for the default index at the root
+	Prefix       string
+	Private      bool
+	UploadPolicy repository.UploadPolicy
+	// Upstream serves the projects missing from the index, if set
+	Upstream *upstream.Cache
+	// DataPath is the directory where the files of the index are stored
+	DataPath string
+	// Layers are the indexes layered by a virtual index, and UploadTarget the index which
+	// uploads to it go to, if any
+	Layers       []*servedIndex
+	UploadTarget *servedIndex
+}
+
+// requestIndex returns the index a request is served from
+func requestIndex(c context.Context) *servedIndex {
+	idx, _ := c.Value(servedIndexKey{}).(*servedIndex)
+	return idx
+}
+
+// withServedIndex returns a context in which requests are served from an index
+func withServedIndex(c context.Context, idx *servedIndex) context.Context {
+	return context.WithValue(repository.WithIndex(c, idx.ID), servedIndexKey{}, idx)
+}
+
+// serveIndex resolves the index of a request from the first segment of its path, which
+// is stripped before the request is passed on to the routes of the index. Requests whose
+// path does not start with an index name are served from the default index, unless the
+// rest of their path is a route of an index: /prdo/simple/ is reported as an unknown index
+// rather than as a missing route of the default index.
+func (p *PipServer) serveIndex(routes *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if !ok || slices.Contains(repository.ReservedIndexNames, name) {
+			name = ""
+		}
+
+		var si *servedIndex
+		var err error
+		if name != "" {
+			si, err = p.lookupIndex(name, r.Context())
+		}
+		if errors.Is(err, repository.ErrIndexNotFound) {
+			if _, pattern := routes.Handler(withPath(r, "/"+rest)); pattern != "" {
+				http.Error(w, fmt.Sprintf("Index '%s' does not exist.", name), http.StatusNotFound)
+				return
+			}
+		}
+		if name == "" || errors.Is(err, repository.ErrIndexNotFound) {
+			name = ""
+			si, err = p.lookupIndex(repository.DefaultIndexName, r.Context())
+		}
+		if err != nil {
+			slog.Error("Error fetching index", "path", r.URL.Path, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if name != "" {
+			r = withPath(r, "/"+rest)
+		}
+		routes.ServeHTTP(w, r.WithContext(withServedIndex(r.Context(), si)))
+	})
+}
+
+// withPath returns a copy of a request for another path
+func withPath(r *http.Request, path string) *http.Request {
+	r = r.Clone(r.Context())
+	r.URL.Path = path
+	r.URL.RawPath = ""
+	return r
+}
+
+// cachedIndex is a served index with the time until which it is cached
+type cachedIndex struct {
+	index   *servedIndex
+	expires time.Time
+}
+
+// lookupIndex returns the served index of an index name. Served indexes are cached for
+// IndexCacheTTL, as a virtual index takes queries for each of its layers, and the cache is
+// cleared when indexes are changed over HTTP; changes made with commands are seen once the
+// cached index expires.
+func (p *PipServer) lookupIndex(name string, c context.Context) (*servedIndex, error) {
+	p.indexesMu.Lock()
+	cached, ok := p.indexes[name]
+	generation := p.indexesGeneration
+	p.indexesMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.index, nil
+	}
+
+	idx, err := p.Repo.GetIndex(name, c)
+	if err != nil {
+		return nil, err
+	}
+	si, err := p.servedIndex(idx, c)
+	if err != nil {
+		return nil, err
+	}
+	p.indexesMu.Lock()
+	defer p.indexesMu.Unlock()
+	// Indexes resolved while the cache was cleared may be outdated already
+	if generation == p.indexesGeneration {
+		p.indexes[name] = &cachedIndex{index: si, expires: time.Now().Add(IndexCacheTTL)}
+	}
+	return si, nil
+}
+
+// clearIndexCache clears the served indexes after an index changed, including the virtual
+// indexes which layer it
+func (p *PipServer) clearIndexCache() {
+	p.indexesMu.Lock()
+	defer p.indexesMu.Unlock()
+	clear(p.indexes)
+	p.indexesGeneration++
+}
+
+// servedIndex applies the server settings to an index, and to the layers and upload
+// target of virtual indexes
+func (p *PipServer) servedIndex(idx *repository.Index, c context.Context) (*servedIndex, error) {
+	si := &servedIndex{
+		Index:        idx,
+		Private:      p.Private,
+		UploadPolicy: repository.UploadsAuthenticated,
+		DataPath:     p.DataPath,
+	}
+	if idx.Private != nil {
+		si.Private = *idx.Private
+	}
+	if idx.UploadPolicy != "" {
+		si.UploadPolicy = idx.UploadPolicy
+	} else if p.AllowAnonymousUploads {
+		si.UploadPolicy = repository.UploadsAnonymous
+	}
+	if idx.ID != repository.DefaultIndexID {
+		si.Prefix = "/" + idx.Name
+		si.DataPath = indexDataPath(p.DataPath, idx)
+	}
+	if idx.UpstreamURL != "" {
+		si.Upstream = p.upstreamCache(idx.UpstreamURL, filepath.Join(si.DataPath, UpstreamCacheDir))
+	} else if idx.ID == repository.DefaultIndexID {
+		si.Upstream = p.Upstream
+	}
+	if !idx.Virtual() {
+		return si, nil
+	}
+
+	// Virtual indexes are private if one of their layers is, so that users authenticate
+	// to read the layer
+	for _, name := range idx.Layers {
+		sl, err := p.lookupIndex(name, c)
+		if err != nil {
+			return nil, err
+		}
+		si.Layers = append(si.Layers, sl)
+		si.Private = si.Private || sl.Private
+	}
+	if idx.UploadTarget != "" {
+		var err error
+		if si.UploadTarget, err = p.lookupIndex(idx.UploadTarget, c); err != nil {
+			return nil, err
+		}
+	}
+	return si, nil
+}
+
+// indexDataPath returns the directory of the files of an index other than the default one
+func indexDataPath(dataPath string, idx *repository.Index) string {
+	return filepath.Join(dataPath, IndexesDir, filepath.FromSlash(idx.StoragePrefix))
+}
+
+// removeIndexFiles removes the files and upstream cache of a deleted index, so that an
+// index created later with the same storage prefix starts empty
+func (p *PipServer) removeIndexFiles(idx *repository.Index) error {
+	dir := indexDataPath(p.DataPath, idx)
+	p.upstreamsMu.Lock()
+	for key := range p.upstreams {
+		if strings.HasSuffix(key, "\x00"+filepath.Join(dir, UpstreamCacheDir)) {
+			delete(p.upstreams, key)
+		}
+	}
+	p.upstreamsMu.Unlock()
+	return os.RemoveAll(dir)
+}
+
+// upstreamCache returns the cache of an upstream index in a directory, which is shared
+// by the requests of an index until its upstream index changes
+func (p *PipServer) upstreamCache(baseURL, dir string) *upstream.Cache {
+	p.upstreamsMu.Lock()
+	defer p.upstreamsMu.Unlock()
+	key := baseURL + "\x00" + dir
+	if u, ok := p.upstreams[key]; ok {
+		return u
+	}
+	u := upstream.New(baseURL, dir, p.upstreamClient)
+	u.PageTTL = p.UpstreamTTL
+	u.Offline = p.Offline
+	p.upstreams[key] = u
+	return u
+}
+
+// indexRequest is the body of requests creating or updating an index
+type indexRequest struct {
+	Name          string   `json:"name"`
+	StoragePrefix string   `json:"storage_prefix"`
+	Private       *bool    `json:"private"`
+	UploadPolicy  string   `json:"upload_policy"`
+	UpstreamURL   string   `json:"upstream_url"`
+	Layers        []string `json:"layers"`
+	Resolution    string   `json:"resolution"`
+	UploadTarget  string   `json:"upload_target"`
+}
+
+// HandleListIndexes returns all indexes
+func (p *PipServer) HandleListIndexes(w http.ResponseWriter, r *http.Request) {
+	indexes, err := p.Repo.GetIndexes(r.Context())
+	if err != nil {
+		slog.Error("Error fetching indexes", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, indexes)
+}
+
+// HandleCreateIndex creates an index, which is served at once
+func (p *PipServer) HandleCreateIndex(w http.ResponseWriter, r *http.Request) {
+	var req indexRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	idx, err := p.Repo.CreateIndex(&repository.Index{
+		Name:          req.Name,
+		StoragePrefix: req.StoragePrefix,
+		Private:       req.Private,
+		UploadPolicy:  repository.UploadPolicy(req.UploadPolicy),
+		UpstreamURL:   req.UpstreamURL,
+		Layers:        req.Layers,
+		Resolution:    repository.Resolution(req.Resolution),
+		UploadTarget:  req.UploadTarget,
+	}, r.Context())
+	switch {
+	case errors.Is(err, repository.ErrInvalidIndex):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrIndexExists):
+		http.Error(w, "An index with this name already exists.", http.StatusConflict)
+	case err != nil:
+		slog.Error("Error creating index", "index", req.Name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Created index", "index", idx.Name, "storage_prefix", idx.StoragePrefix)
+		p.clearIndexCache()
+		writeJSON(w, http.StatusCreated, idx)
+	}
+}
+
+// HandleIndex updates the settings of an index or deletes it, depending on the method
+func (p *PipServer) HandleIndex(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("index")
+	if r.Method == http.MethodDelete {
+		idx, err := p.Repo.DeleteIndex(name, r.Context())
+		switch {
+		case errors.Is(err, repository.ErrIndexNotFound):
+			http.Error(w, "Not Found", http.StatusNotFound)
+		case errors.Is(err, repository.ErrInvalidIndex):
+			http.Error(w, "The default index cannot be deleted.", http.StatusBadRequest)
+		case errors.Is(err, repository.ErrIndexNotEmpty):
+			http.Error(w, "The index still has projects, purge them first.", http.StatusConflict)
+		case errors.Is(err, repository.ErrIndexInUse):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			slog.Error("Error deleting index", "index", name, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		default:
+			slog.Info("Deleted index", "index", name)
+			p.clearIndexCache()
+			if err := p.removeIndexFiles(idx); err != nil {
+				slog.Error("Error removing the files of a deleted index", "index", name, "error", err)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	var req indexRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	idx, err := p.Repo.UpdateIndex(name, &repository.Index{
+		Private:      req.Private,
+		UploadPolicy: repository.UploadPolicy(req.UploadPolicy),
+		UpstreamURL:  req.UpstreamURL,
+		Layers:       req.Layers,
+		Resolution:   repository.Resolution(req.Resolution),
+		UploadTarget: req.UploadTarget,
+	}, r.Context())
+	switch {
+	case errors.Is(err, repository.ErrIndexNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidIndex):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		slog.Error("Error updating index", "index", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Updated index", "index", idx.Name)
+		p.clearIndexCache()
+		writeJSON(w, http.StatusOK, idx)
+	}
+}
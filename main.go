@@ -3,8 +3,14 @@ package main
 import (
 	"database/sql"
 	"flag"
+	"fmt"
+	"go-pip-server/repository"
+	"go-pip-server/upstream"
 	"log"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -15,12 +21,33 @@ type Config struct {
 	Port          int
 	HostAddr      string
 	QueriesSource string
+	TemplatesDir  string
 	DataPath      string
+	ExternalURL   string
+
+	AllowIdenticalReupload bool
+	MaxUploadSize          int64
+	AllowAnonymousUploads  bool
+	AdminToken             string
+	Private                bool
+	DeletionRetention      time.Duration
+	OIDCAudience           string
+	OIDCIssuers            []string
+	OIDCAllowLoopbackHTTP  bool
+	UpstreamURL            string
+	UpstreamTTL            time.Duration
+	Offline                bool
+	Index                  string
 }
 
 // SetUp Parses command-line flags and returns the application configuration
 func SetUp() *Config {
 	var cfg Config
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n%s", CommandsUsage)
+	}
 	flag.StringVar(
 		&cfg.SQLiteFile,
 		"sqlite-file",
@@ -35,24 +62,130 @@ func SetUp() *Config {
 		filepath.Join("assets", "queries"),
 		"Directory containing SQL query files",
 	)
+	flag.StringVar(
+		&cfg.TemplatesDir,
+		"templates-dir",
+		filepath.Join("assets", "templates"),
+		"Directory containing HTML templates for the Simple API",
+	)
 	flag.StringVar(
 		&cfg.DataPath,
 		"data-path",
 		filepath.Join("_data", "packages"),
 		"Path to the directory containing package files",
 	)
+	flag.StringVar(
+		&cfg.ExternalURL,
+		"external-url",
+		"",
+		"URL which clients reach the server at, such as https://pypi.example.com, used in PEP 708 alternate locations",
+	)
+	flag.BoolVar(
+		&cfg.AllowIdenticalReupload,
+		"allow-identical-reupload",
+		false,
+		"Accept re-uploads of an existing file with the same SHA256 digest instead of rejecting them",
+	)
+	flag.Int64Var(
+		&cfg.MaxUploadSize,
+		"max-upload-size",
+		1<<30,
+		"Maximum size of upload requests in bytes, larger uploads are rejected with 413",
+	)
+	flag.BoolVar(
+		&cfg.AllowAnonymousUploads,
+		"allow-anonymous-uploads",
+		false,
+		"Accept uploads without credentials, by default uploads require a user created with the user command",
+	)
+	flag.StringVar(
+		&cfg.AdminToken,
+		"admin-token",
+		"",
+		"Bearer token for the admin endpoints, which are disabled if empty (default from PIP_SERVER_ADMIN_TOKEN)",
+	)
+	flag.BoolVar(
+		&cfg.Private,
+		"private",
+		false,
+		"Require authentication to read the index and download files, users only see the projects they have a role on",
+	)
+	flag.DurationVar(
+		&cfg.DeletionRetention,
+		"deletion-retention",
+		30*24*time.Hour,
+		"How long deleted projects can be restored, 0 keeps them forever",
+	)
+	flag.StringVar(
+		&cfg.OIDCAudience,
+		"oidc-audience",
+		"go-pip-server",
+		"Audience which trusted publishers request their OIDC tokens for, unless they were registered with another",
+	)
+	flag.Func(
+		"oidc-issuers",
+		"Comma-separated OIDC issuers which project owners can register trusted publishers of, admins can register any (default "+strings.Join(DefaultOIDCIssuers, ",")+")",
+		func(v string) error {
+			cfg.OIDCIssuers = strings.FieldsFunc(v, func(r rune) bool { return r == ',' })
+			return nil
+		},
+	)
+	flag.BoolVar(
+		&cfg.OIDCAllowLoopbackHTTP,
+		"oidc-allow-loopback-http",
+		false,
+		"Accept http:// OIDC issuers on loopback addresses for trusted publishers, for testing",
+	)
+	flag.StringVar(
+		&cfg.UpstreamURL,
+		"upstream-url",
+		"",
+		"Simple API of an upstream index such as https://pypi.org/simple/, which serves the projects missing locally",
+	)
+	flag.DurationVar(
+		&cfg.UpstreamTTL,
+		"upstream-ttl",
+		upstream.DefaultPageTTL,
+		"How long project pages of the upstream index are cached, files are cached forever",
+	)
+	flag.BoolVar(
+		&cfg.Offline,
+		"offline",
+		false,
+		"Only serve the projects and files of the upstream index which are cached",
+	)
+	flag.StringVar(
+		&cfg.Index,
+		"index",
+		repository.DefaultIndexName,
+		"Index which commands on projects apply to",
+	)
+	cfg.OIDCIssuers = DefaultOIDCIssuers
 	flag.Parse()
+	if cfg.AdminToken == "" {
+		// Read from the environment so that the token does not show in the process list
+		cfg.AdminToken = os.Getenv("PIP_SERVER_ADMIN_TOKEN")
+	}
 	return &cfg
 }
 
 func main() {
 	cfg := SetUp()
-	sqlDb, err := sql.Open("sqlite", cfg.SQLiteFile)
+	sqlDb, err := sql.Open("sqlite", repository.DataSourceName(cfg.SQLiteFile))
 	if err != nil {
 		panic(err)
 	}
 	defer sqlDb.Close()
 
+	// Run a command instead of the server if one is given
+	if flag.NArg() > 0 {
+		err = runCommand(cfg, sqlDb, flag.Args())
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
 	// Start server and listen for requests
 	server, err := NewPipServer(sqlDb, cfg)
 	if err != nil {
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// simpleFormats lists the content types the Simple API can be rendered as, in order
+// of server preference when the client has no preference between them. The PEP 503
+// format comes first so that legacy clients sending wildcards keep working.
+var simpleFormats = []string{LegacyHTMLHeader, HTMLHeader, JSONHeader}
+
+// simpleAliases maps the "latest" content types from PEP 691 to the concrete versions.
+var simpleAliases = map[string]string{
+	"application/vnd.pypi.simple.latest+json": JSONHeader,
+	"application/vnd.pypi.simple.latest+html": HTMLHeader,
+}
+
+// acceptRange is a single media range from an Accept header
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiateFormat selects the content type of a Simple API response. The "format"
+// query parameter takes precedence over the Accept header. Returns false if none
+// of the supported formats is acceptable to the client.
+func negotiateFormat(r *http.Request) (string, bool) {
+	if f := r.URL.Query().Get(FormatQueryParam); f != "" {
+		return matchFormat(f)
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		// Clients that do not state a preference get the PEP 503 format
+		return LegacyHTMLHeader, true
+	}
+
+	ranges := parseAccept(accept)
+	best, bestQ := "", 0.0
+	for _, f := range simpleFormats {
+		q := formatQuality(f, ranges)
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best, best != ""
+}
+
+// matchFormat resolves an explicitly requested format to one of the supported formats
+func matchFormat(f string) (string, bool) {
+	// An unescaped "+" in the query string is decoded as a space
+	f = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(f)), " ", "+")
+	if alias, ok := simpleAliases[f]; ok {
+		return alias, true
+	}
+	for _, sf := range simpleFormats {
+		if sf == f {
+			return sf, true
+		}
+	}
+	return "", false
+}
+
+// parseAccept parses the media ranges of an Accept header along with their q-values.
+// Malformed ranges are ignored.
+func parseAccept(header string) []acceptRange {
+	out := make([]acceptRange, 0, 4)
+	for _, part := range strings.Split(header, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(qs, 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if alias, ok := simpleAliases[mt]; ok {
+			mt = alias
+		}
+		out = append(out, acceptRange{mediaType: mt, q: q})
+	}
+	return out
+}
+
+// formatQuality returns the q-value the client assigns to a content type, taken from
+// the most specific matching media range. Returns 0 if no range matches.
+func formatQuality(format string, ranges []acceptRange) float64 {
+	major, _, _ := strings.Cut(format, "/")
+	q, specificity := 0.0, -1
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.mediaType == format:
+			s = 2
+		case ar.mediaType == major+"/*":
+			s = 1
+		case ar.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = ar.q, s
+		}
+	}
+	return q
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestNegotiateFormat tests content negotiation for the Simple API
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		accept   string
+		query    string
+		expected string
+		ok       bool
+	}{
+		{"", "", LegacyHTMLHeader, true},
+		{JSONHeader, "", JSONHeader, true},
+		{"text/html", "", LegacyHTMLHeader, true},
+		{"application/vnd.pypi.simple.latest+html", "", HTMLHeader, true},
+		// pip's default Accept header
+		{
+			"application/vnd.pypi.simple.v1+json, application/vnd.pypi.simple.v1+html; q=0.1, text/html; q=0.01",
+			"",
+			JSONHeader,
+			true,
+		},
+		{"application/vnd.pypi.simple.v1+json; q=0.2, text/html", "", LegacyHTMLHeader, true},
+		{"*/*", "", LegacyHTMLHeader, true},
+		{"application/*", "", HTMLHeader, true},
+		{"text/*", "", LegacyHTMLHeader, true},
+		{"*/*, text/html; q=0", "", HTMLHeader, true},
+		{"application/xml", "", "", false},
+		{"text/html", "?format=" + url.QueryEscape(JSONHeader), JSONHeader, true},
+		{"text/html", "?format=" + JSONHeader, JSONHeader, true},
+		{JSONHeader, "?format=text/plain", "", false},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/simple/"+tc.query, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		got, ok := negotiateFormat(req)
+		if ok != tc.ok || got != tc.expected {
+			t.Errorf("Accept %q, query %q: expected (%q, %v), got (%q, %v)",
+				tc.accept, tc.query, tc.expected, tc.ok, got, ok)
+		}
+	}
+}
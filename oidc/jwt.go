@@ -0,0 +1,192 @@
+// Package oidc verifies OpenID Connect ID tokens, such as the tokens which CI providers
+// issue to their jobs, against the signing keys published by their issuer.
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned when a token is malformed, badly signed, expired, or not
+// meant for the audience
+var ErrInvalidToken = errors.New("invalid token")
+
+// Leeway is the allowed clock skew when checking the validity period of tokens
+const Leeway = time.Minute
+
+// Claims are the claims of a verified token
+type Claims map[string]any
+
+// String returns a string claim, or false if it is missing or not a string
+func (c Claims) String(name string) (string, bool) {
+	s, ok := c[name].(string)
+	return s, ok
+}
+
+// Issuer returns the iss claim
+func (c Claims) Issuer() string {
+	s, _ := c.String("iss")
+	return s
+}
+
+// Audience returns the aud claim, which may be a single string or a list
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		out := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Time returns a NumericDate claim such as exp, or false if it is missing
+func (c Claims) Time(name string) (time.Time, bool) {
+	n, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(n), 0), true
+}
+
+// header is the JOSE header of a token
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// token is a parsed but not yet verified JWT
+type token struct {
+	Header    header
+	Claims    Claims
+	Signed    string
+	Signature []byte
+}
+
+// parseToken decodes a compact serialized JWT without verifying it
+func parseToken(raw string) (*token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	var t token
+	if err := decodeSegment(parts[0], &t.Header); err != nil {
+		return nil, fmt.Errorf("%w: bad header: %v", ErrInvalidToken, err)
+	}
+	if err := decodeSegment(parts[1], &t.Claims); err != nil {
+		return nil, fmt.Errorf("%w: bad claims: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+	t.Signed = parts[0] + "." + parts[1]
+	t.Signature = sig
+	return &t, nil
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// UnverifiedIssuer returns the issuer of a token without verifying it, to find out which
+// issuer's keys verify it
+func UnverifiedIssuer(raw string) (string, error) {
+	t, err := parseToken(raw)
+	if err != nil {
+		return "", err
+	}
+	if iss := t.Claims.Issuer(); iss != "" {
+		return iss, nil
+	}
+	return "", fmt.Errorf("%w: no issuer", ErrInvalidToken)
+}
+
+// verifySignature checks the signature of a token with a public key. Only the RSA and
+// ECDSA algorithms are accepted, in particular not "none" nor HMAC.
+func (t *token) verifySignature(key crypto.PublicKey) error {
+	var h hash.Hash
+	var ch crypto.Hash
+	switch t.Header.Alg {
+	case "RS256", "ES256":
+		h, ch = sha256.New(), crypto.SHA256
+	case "RS384", "ES384":
+		h, ch = sha512.New384(), crypto.SHA384
+	case "RS512":
+		h, ch = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, t.Header.Alg)
+	}
+	h.Write([]byte(t.Signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(t.Header.Alg, "RS") {
+			return fmt.Errorf("%w: algorithm %q does not match the RSA key", ErrInvalidToken, t.Header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, ch, digest, t.Signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(t.Header.Alg, "ES") || len(t.Signature) != 2*size {
+			return fmt.Errorf("%w: algorithm %q does not match the EC key", ErrInvalidToken, t.Header.Alg)
+		}
+		r := new(big.Int).SetBytes(t.Signature[:size])
+		s := new(big.Int).SetBytes(t.Signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key type", ErrInvalidToken)
+	}
+	return nil
+}
+
+// checkClaims checks the issuer, audience, validity period and issue time of a token
+func (t *token) checkClaims(issuer, audience string, now time.Time) error {
+	if t.Claims.Issuer() != issuer {
+		return fmt.Errorf("%w: issuer %q is not %q", ErrInvalidToken, t.Claims.Issuer(), issuer)
+	}
+	if !slices.Contains(t.Claims.Audience(), audience) {
+		return fmt.Errorf("%w: audience is not %q", ErrInvalidToken, audience)
+	}
+	exp, ok := t.Claims.Time("exp")
+	if !ok {
+		return fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if now.After(exp.Add(Leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := t.Claims.Time("nbf"); ok && now.Add(Leeway).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if iat, ok := t.Claims.Time("iat"); ok && now.Add(Leeway).Before(iat) {
+		return fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeysTTL is how long the signing keys of an issuer are cached
+const DefaultKeysTTL = time.Hour
+
+// minRefresh is how long to wait before fetching the keys of an issuer again when a token
+// is signed with an unknown key, which happens after the issuer rotates its keys
+const minRefresh = time.Minute
+
+// maxDocumentSize limits the size of the discovery and key set documents
+const maxDocumentSize = 1 << 20
+
+// ErrFetchKeys is returned when the signing keys of an issuer cannot be fetched
+var ErrFetchKeys = errors.New("unable to fetch the issuer's signing keys")
+
+// Verifier verifies tokens of any issuer, whose signing keys are discovered from the
+// issuer URL and cached
+type Verifier struct {
+	Client *http.Client
+	// KeysTTL is how long the keys of an issuer are cached
+	KeysTTL time.Duration
+	// AllowLoopbackHTTP lets issuers on loopback addresses serve their keys over http, for
+	// testing. Other issuers must use https, so that their keys cannot be replaced in transit.
+	AllowLoopbackHTTP bool
+	// Now returns the current time, it can be replaced in tests
+	Now func() time.Time
+
+	mu       sync.Mutex
+	sets     map[string]*keySet
+	fetching map[string]*keyFetch
+}
+
+// keySet is the cached set of signing keys of an issuer, by key ID
+type keySet struct {
+	Keys    map[string]crypto.PublicKey
+	Fetched time.Time
+}
+
+// keyFetch is a fetch of the keys of an issuer in progress, which concurrent requests for
+// the same issuer wait for. done is closed once set or err is filled.
+type keyFetch struct {
+	done chan struct{}
+	set  *keySet
+	err  error
+}
+
+// NewVerifier creates a Verifier which fetches keys with the client
+func NewVerifier(client *http.Client) *Verifier {
+	return &Verifier{
+		Client:   client,
+		KeysTTL:  DefaultKeysTTL,
+		Now:      time.Now,
+		sets:     make(map[string]*keySet),
+		fetching: make(map[string]*keyFetch),
+	}
+}
+
+// Verify verifies a token issued by the issuer for the audience, and returns its claims
+func (v *Verifier) Verify(c context.Context, raw, issuer, audience string) (Claims, error) {
+	t, err := parseToken(raw)
+	if err != nil {
+		return nil, err
+	}
+	now := v.Now()
+	if err := t.checkClaims(issuer, audience, now); err != nil {
+		return nil, err
+	}
+	key, err := v.key(c, issuer, t.Header.Kid, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.verifySignature(key); err != nil {
+		return nil, err
+	}
+	return t.Claims, nil
+}
+
+// key returns the signing key of an issuer with the given ID. The keys are fetched if they
+// are not cached or too old, or if the key is unknown and they were not fetched recently.
+func (v *Verifier) key(c context.Context, issuer, kid string, now time.Time) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	set := v.sets[issuer]
+	stale := set == nil || now.Sub(set.Fetched) > v.KeysTTL
+	if !stale && set.Keys[kid] == nil && now.Sub(set.Fetched) > minRefresh {
+		stale = true
+	}
+	v.mu.Unlock()
+	if stale {
+		var err error
+		set, err = v.refresh(c, issuer, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if key := set.Keys[kid]; key != nil {
+		return key, nil
+	}
+	// Tokens without a key ID can be verified if the issuer has a single key
+	if kid == "" && len(set.Keys) == 1 {
+		for _, key := range set.Keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+}
+
+// refresh fetches the keys of an issuer and caches them. The lock is not held while
+// fetching, so that a slow issuer does not hold up the others, and concurrent requests
+// for the same issuer wait for a single fetch.
+func (v *Verifier) refresh(c context.Context, issuer string, now time.Time) (*keySet, error) {
+	v.mu.Lock()
+	f := v.fetching[issuer]
+	if f != nil {
+		v.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.set, f.err
+		case <-c.Done():
+			return nil, fmt.Errorf("%w: %v", ErrFetchKeys, c.Err())
+		}
+	}
+	f = &keyFetch{done: make(chan struct{})}
+	v.fetching[issuer] = f
+	v.mu.Unlock()
+
+	keys, err := v.fetchKeys(c, issuer)
+	v.mu.Lock()
+	if err != nil {
+		f.err = err
+	} else {
+		f.set = &keySet{Keys: keys, Fetched: now}
+		v.sets[issuer] = f.set
+	}
+	delete(v.fetching, issuer)
+	v.mu.Unlock()
+	close(f.done)
+	return f.set, f.err
+}
+
+// discovery is the part of an issuer's OpenID configuration which locates its keys
+type discovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// jwk is a JSON Web Key, of which only RSA and EC signing keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys fetches the signing keys of an issuer, located through its OpenID configuration
+func (v *Verifier) fetchKeys(c context.Context, issuer string) (map[string]crypto.PublicKey, error) {
+	if err := v.checkURL(issuer); err != nil {
+		return nil, err
+	}
+	var d discovery
+	err := v.getJSON(c, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return nil, err
+	}
+	if d.Issuer != issuer || d.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: the configuration of %s does not match", ErrFetchKeys, issuer)
+	}
+	if err := v.checkURL(d.JWKSURI); err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(c, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// checkURL checks that keys are fetched over https, or over http from a loopback address
+// if AllowLoopbackHTTP is set
+func (v *Verifier) checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: invalid URL %q", ErrFetchKeys, raw)
+	}
+	loopback := u.Hostname() == "localhost" || net.ParseIP(u.Hostname()).IsLoopback()
+	if u.Scheme != "https" && !(u.Scheme == "http" && v.AllowLoopbackHTTP && loopback) {
+		return fmt.Errorf("%w: %s does not use https", ErrFetchKeys, raw)
+	}
+	return nil
+}
+
+// getJSON fetches and decodes a JSON document
+func (v *Verifier) getJSON(c context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(c, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFetchKeys, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	rsp, err := v.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFetchKeys, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %s", ErrFetchKeys, url, rsp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(rsp.Body, maxDocumentSize)).Decode(out); err != nil {
+		return fmt.Errorf("%w: invalid document at %s: %v", ErrFetchKeys, url, err)
+	}
+	return nil
+}
+
+// publicKey decodes an RSA or EC public key
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC point encoding")
+		}
+		// The point is checked to be on the curve when parsed
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid integer encoding")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
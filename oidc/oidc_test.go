@@ -0,0 +1,292 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeIssuer is a local OpenID provider which publishes its keys and signs tokens
+type fakeIssuer struct {
+	*httptest.Server
+	Keys    map[string]crypto.Signer
+	Fetches atomic.Int32
+	// Block holds up key requests until it is closed, if set
+	Block chan struct{}
+}
+
+// newFakeIssuer starts a fake issuer with an RSA and an EC key, served over https
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	return startFakeIssuer(t, true)
+}
+
+// startFakeIssuer starts a fake issuer served over https or plain http
+func startFakeIssuer(t *testing.T, secure bool) *fakeIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating EC key: %v", err)
+	}
+	fi := &fakeIssuer{Keys: map[string]crypto.Signer{"rsa-1": rsaKey, "ec-1": ecKey}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": fi.URL, "jwks_uri": fi.URL + "/keys"})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		fi.Fetches.Add(1)
+		if fi.Block != nil {
+			<-fi.Block
+		}
+		keys := make([]map[string]string, 0, len(fi.Keys))
+		for kid, k := range fi.Keys {
+			switch pub := k.Public().(type) {
+			case *rsa.PublicKey:
+				keys = append(keys, map[string]string{
+					"kty": "RSA", "kid": kid, "use": "sig",
+					"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes()),
+				})
+			case *ecdsa.PublicKey:
+				keys = append(keys, map[string]string{
+					"kty": "EC", "kid": kid, "crv": "P-256",
+					"x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32))),
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	fi.Server = httptest.NewUnstartedServer(mux)
+	if secure {
+		fi.StartTLS()
+	} else {
+		fi.Start()
+	}
+	t.Cleanup(fi.Close)
+	return fi
+}
+
+// sign issues a token signed with the key of the given ID
+func (fi *fakeIssuer) sign(t *testing.T, kid string, claims map[string]any) string {
+	alg := "RS256"
+	if kid == "ec-1" {
+		alg = "ES256"
+	}
+	hdr, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := b64(hdr) + "." + b64(body)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch k := fi.Keys[kid].(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	return signed + "." + b64(sig)
+}
+
+// claims returns valid claims of the fake issuer for the audience
+func (fi *fakeIssuer) claims(audience string) map[string]any {
+	return map[string]any{
+		"iss":        fi.URL,
+		"aud":        audience,
+		"exp":        time.Now().Add(5 * time.Minute).Unix(),
+		"iat":        time.Now().Unix(),
+		"repository": "org/project",
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// TestVerify tests verifying tokens signed with RSA and EC keys
+func TestVerify(t *testing.T) {
+	fi := newFakeIssuer(t)
+	v := NewVerifier(fi.Client())
+	ctx := context.Background()
+
+	for _, kid := range []string{"rsa-1", "ec-1"} {
+		raw := fi.sign(t, kid, fi.claims("pip-server"))
+		claims, err := v.Verify(ctx, raw, fi.URL, "pip-server")
+		if err != nil {
+			t.Fatalf("Verify with %s failed: %v", kid, err)
+		}
+		if repo, _ := claims.String("repository"); repo != "org/project" {
+			t.Errorf("Unexpected claims %v", claims)
+		}
+		if iss, _ := UnverifiedIssuer(raw); iss != fi.URL {
+			t.Errorf("Expected issuer %s, got %s", fi.URL, iss)
+		}
+	}
+	if n := fi.Fetches.Load(); n != 1 {
+		t.Errorf("Expected the keys to be fetched once, got %d", n)
+	}
+}
+
+// TestVerifyRejects tests that invalid tokens are rejected
+func TestVerifyRejects(t *testing.T) {
+	fi := newFakeIssuer(t)
+	v := NewVerifier(fi.Client())
+	ctx := context.Background()
+
+	expired := fi.claims("pip-server")
+	expired["exp"] = time.Now().Add(-2 * Leeway).Unix()
+	future := fi.claims("pip-server")
+	future["iat"] = time.Now().Add(2 * Leeway).Unix()
+	listAudience := fi.claims("")
+	listAudience["aud"] = []string{"other", "another"}
+	valid := fi.sign(t, "rsa-1", fi.claims("pip-server"))
+	cases := map[string]string{
+		"wrong audience": fi.sign(t, "rsa-1", fi.claims("other")),
+		"audience list":  fi.sign(t, "rsa-1", listAudience),
+		"expired":        fi.sign(t, "rsa-1", expired),
+		"issued later":   fi.sign(t, "rsa-1", future),
+		"unknown key":    withKid(valid, "rsa-2"),
+		"bad signature":  valid[:len(valid)-4] + "AAAA",
+		"alg none":       b64([]byte(`{"alg":"none","kid":"rsa-1"}`)) + "." + b64(mustJSON(fi.claims("pip-server"))) + ".",
+		"not a JWT":      "abc.def",
+	}
+	for name, raw := range cases {
+		if _, err := v.Verify(ctx, raw, fi.URL, "pip-server"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+	if _, err := v.Verify(ctx, valid, "https://other.example.com", "pip-server"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for another issuer, got %v", err)
+	}
+}
+
+// TestKeyRotation tests that the keys are fetched again when a token is signed with a new
+// key, but not more than once a minute
+func TestKeyRotation(t *testing.T) {
+	fi := newFakeIssuer(t)
+	v := NewVerifier(fi.Client())
+	now := time.Now()
+	v.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := v.Verify(ctx, fi.sign(t, "rsa-1", fi.claims("aud")), fi.URL, "aud"); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+	fi.Keys["rsa-2"] = newKey
+	rotated := fi.sign(t, "rsa-2", fi.claims("aud"))
+
+	if _, err := v.Verify(ctx, rotated, fi.URL, "aud"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the new key to be unknown right after fetching, got %v", err)
+	}
+	now = now.Add(2 * minRefresh)
+	if _, err := v.Verify(ctx, rotated, fi.URL, "aud"); err != nil {
+		t.Errorf("Expected the new key to be fetched, got %v", err)
+	}
+	if n := fi.Fetches.Load(); n != 2 {
+		t.Errorf("Expected the keys to be fetched twice, got %d", n)
+	}
+}
+
+// TestSlowIssuer tests that fetching the keys of a slow issuer does not hold up the
+// tokens of other issuers, and that concurrent tokens of the slow issuer share a fetch
+func TestSlowIssuer(t *testing.T) {
+	slow := newFakeIssuer(t)
+	slow.Block = make(chan struct{})
+	fast := newFakeIssuer(t)
+	v := NewVerifier(slow.Client())
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.Verify(ctx, slow.sign(t, "rsa-1", slow.claims("aud")), slow.URL, "aud"); err != nil {
+				t.Errorf("Verify failed: %v", err)
+			}
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); slow.Fetches.Load() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("The keys of the slow issuer were not fetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(ctx, fast.sign(t, "ec-1", fast.claims("aud")), fast.URL, "aud")
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("Verify failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the fast issuer not to wait for the slow one")
+	}
+
+	close(slow.Block)
+	wg.Wait()
+	if n := slow.Fetches.Load(); n != 1 {
+		t.Errorf("Expected a single fetch of the slow issuer's keys, got %d", n)
+	}
+}
+
+// TestInsecureIssuer tests that the keys of http issuers are only fetched from loopback
+// addresses when allowed
+func TestInsecureIssuer(t *testing.T) {
+	fi := startFakeIssuer(t, false)
+	v := NewVerifier(fi.Client())
+	ctx := context.Background()
+	raw := fi.sign(t, "rsa-1", fi.claims("aud"))
+
+	if _, err := v.Verify(ctx, raw, fi.URL, "aud"); !errors.Is(err, ErrFetchKeys) {
+		t.Errorf("Expected ErrFetchKeys for an http issuer, got %v", err)
+	}
+	if n := fi.Fetches.Load(); n != 0 {
+		t.Errorf("Expected no keys to be fetched, got %d fetches", n)
+	}
+	v.AllowLoopbackHTTP = true
+	if _, err := v.Verify(ctx, raw, fi.URL, "aud"); err != nil {
+		t.Errorf("Expected loopback http issuers to be allowed, got %v", err)
+	}
+}
+
+// withKid replaces the header of a token with one naming another key ID
+func withKid(raw, kid string) string {
+	_, rest, _ := strings.Cut(raw, ".")
+	return b64(mustJSON(map[string]string{"alg": "RS256", "kid": kid})) + "." + rest
+}
+
+func mustJSON(v any) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
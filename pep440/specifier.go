@@ -0,0 +1,244 @@
+package pep440
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidSpecifier is returned when a version specifier does not follow PEP 440
+var ErrInvalidSpecifier = errors.New("invalid version specifier")
+
+// operators lists the comparison operators, longest first so that prefixes match correctly
+var operators = []string{"===", "~=", "==", "!=", "<=", ">=", "<", ">"}
+
+// Specifier is a single version clause, such as >=3.8 or ==1.2.*
+type Specifier struct {
+	Operator string
+	Version  string
+	// Wildcard is true for prefix matching clauses, such as ==1.2.*
+	Wildcard bool
+	parsed   *Version
+}
+
+// SpecifierSet is a comma-separated list of version clauses, all of which must match
+type SpecifierSet []*Specifier
+
+// ParseSpecifier parses a single version clause
+func ParseSpecifier(s string) (*Specifier, error) {
+	s = strings.TrimSpace(s)
+	sp := &Specifier{}
+	for _, op := range operators {
+		if rest, ok := strings.CutPrefix(s, op); ok {
+			sp.Operator = op
+			sp.Version = strings.TrimSpace(rest)
+			break
+		}
+	}
+	if sp.Operator == "" || sp.Version == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSpecifier, s)
+	}
+	if sp.Operator == "===" {
+		// Arbitrary equality compares strings, the version does not have to be valid
+		return sp, nil
+	}
+
+	version := sp.Version
+	if prefix, ok := strings.CutSuffix(version, ".*"); ok {
+		if sp.Operator != "==" && sp.Operator != "!=" {
+			return nil, fmt.Errorf("%w: %q, wildcards are only allowed with == and !=", ErrInvalidSpecifier, s)
+		}
+		sp.Wildcard = true
+		version = prefix
+	}
+	v, err := Parse(version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSpecifier, s)
+	}
+	if len(v.Local) > 0 && sp.Operator != "==" && sp.Operator != "!=" {
+		return nil, fmt.Errorf("%w: %q, local versions are only allowed with == and !=", ErrInvalidSpecifier, s)
+	}
+	if sp.Wildcard && (len(v.Local) > 0 || v.Dev != nil) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSpecifier, s)
+	}
+	if sp.Operator == "~=" && len(v.Release) < 2 {
+		return nil, fmt.Errorf("%w: %q, ~= requires at least two release segments", ErrInvalidSpecifier, s)
+	}
+	sp.parsed = v
+	return sp, nil
+}
+
+// ParseSpecifierSet parses a comma-separated list of version clauses, such as the value of
+// Requires-Python. An empty string is a valid set that matches every version.
+func ParseSpecifierSet(s string) (SpecifierSet, error) {
+	set := SpecifierSet{}
+	if strings.TrimSpace(s) == "" {
+		return set, nil
+	}
+	for _, clause := range strings.Split(s, ",") {
+		sp, err := ParseSpecifier(clause)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, sp)
+	}
+	return set, nil
+}
+
+// String returns the specifier as written, without spaces
+func (sp *Specifier) String() string {
+	return sp.Operator + sp.Version
+}
+
+// String returns the specifiers joined by commas
+func (set SpecifierSet) String() string {
+	clauses := make([]string, len(set))
+	for i, sp := range set {
+		clauses[i] = sp.String()
+	}
+	return strings.Join(clauses, ",")
+}
+
+// AllowsPreReleases reports whether the specifier explicitly mentions a pre-release,
+// in which case pre-releases are matched by default.
+func (sp *Specifier) AllowsPreReleases() bool {
+	switch sp.Operator {
+	case "==", ">=", "<=", "~=":
+		return sp.parsed.IsPreRelease()
+	case "===":
+		v, err := Parse(sp.Version)
+		return err == nil && v.IsPreRelease()
+	default:
+		return false
+	}
+}
+
+// Matches checks if a version satisfies the specifier. Pre-releases are not excluded
+// here, see SpecifierSet.Contains.
+func (sp *Specifier) Matches(v *Version) bool {
+	switch sp.Operator {
+	case "===":
+		return strings.EqualFold(sp.Version, v.String())
+	case "==":
+		return sp.matchesEqual(v)
+	case "!=":
+		return !sp.matchesEqual(v)
+	case "~=":
+		prefix := &Version{Epoch: sp.parsed.Epoch, Release: sp.parsed.Release[:len(sp.parsed.Release)-1]}
+		return v.withoutLocal().Compare(sp.parsed) >= 0 && matchesPrefix(v, prefix)
+	case "<=":
+		return v.withoutLocal().Compare(sp.parsed) <= 0
+	case ">=":
+		return v.withoutLocal().Compare(sp.parsed) >= 0
+	case "<":
+		if v.withoutLocal().Compare(sp.parsed) >= 0 {
+			return false
+		}
+		// <3.0 does not match pre-releases of 3.0, unless the specifier is a pre-release
+		return sp.parsed.IsPreRelease() || !v.IsPreRelease() || !sameBase(v, sp.parsed)
+	case ">":
+		if v.withoutLocal().Compare(sp.parsed) <= 0 {
+			return false
+		}
+		// >1.7 does not match post-releases or local versions of 1.7
+		if !sp.parsed.IsPostRelease() && v.IsPostRelease() && sameBase(v, sp.parsed) {
+			return false
+		}
+		return len(v.Local) == 0 || !sameBase(v, sp.parsed)
+	}
+	return false
+}
+
+// matchesEqual implements the == operator, with prefix matching for wildcards. The local
+// segment of the candidate is ignored unless the specifier has one.
+func (sp *Specifier) matchesEqual(v *Version) bool {
+	if sp.Wildcard {
+		return matchesPrefix(v, sp.parsed)
+	}
+	if len(sp.parsed.Local) == 0 {
+		v = v.withoutLocal()
+	}
+	return v.Compare(sp.parsed) == 0
+}
+
+// Contains checks if a version satisfies all the specifiers. Pre-releases only match if
+// one of the specifiers mentions a pre-release.
+func (set SpecifierSet) Contains(v *Version) bool {
+	return set.ContainsPreReleases(v, set.AllowsPreReleases())
+}
+
+// ContainsPreReleases checks if a version satisfies all the specifiers, matching
+// pre-releases only if preReleases is true.
+func (set SpecifierSet) ContainsPreReleases(v *Version, preReleases bool) bool {
+	if v.IsPreRelease() && !preReleases {
+		return false
+	}
+	for _, sp := range set {
+		if !sp.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsPreReleases reports whether any of the specifiers mentions a pre-release
+func (set SpecifierSet) AllowsPreReleases() bool {
+	for _, sp := range set {
+		if sp.AllowsPreReleases() {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutLocal returns the version without its local segment
+func (v *Version) withoutLocal() *Version {
+	if len(v.Local) == 0 {
+		return v
+	}
+	public := *v
+	public.Local = nil
+	return &public
+}
+
+// sameBase checks if two versions have the same epoch and release segment
+func sameBase(a, b *Version) bool {
+	return a.Epoch == b.Epoch && compareRelease(a.Release, b.Release) == 0
+}
+
+// matchesPrefix checks if a version starts with the given prefix, as in ==1.2.*. The
+// release of the candidate is padded with zeros, so 1.2 matches ==1.2.0.*.
+func matchesPrefix(v, prefix *Version) bool {
+	if v.Epoch != prefix.Epoch {
+		return false
+	}
+	for i, n := range prefix.Release {
+		var m int
+		if i < len(v.Release) {
+			m = v.Release[i]
+		}
+		if m != n {
+			return false
+		}
+	}
+	// A prefix with a pre-release or post-release only matches that exact segment
+	if prefix.Pre != nil {
+		if v.Pre == nil || *v.Pre != *prefix.Pre || len(v.Release) > len(prefix.Release) && !zeros(v.Release[len(prefix.Release):]) {
+			return false
+		}
+	}
+	if prefix.Post != nil && (v.Post == nil || *v.Post != *prefix.Post) {
+		return false
+	}
+	return true
+}
+
+// zeros checks if all numbers of a release segment are zero
+func zeros(release []int) bool {
+	for _, n := range release {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
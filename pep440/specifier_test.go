@@ -0,0 +1,69 @@
+package pep440
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestSpecifierSetContains tests matching of versions against specifiers
+func TestSpecifierSetContains(t *testing.T) {
+	cases := []struct {
+		spec    string
+		version string
+		match   bool
+	}{
+		{"", "1.0", true},
+		{"", "1.0rc1", false},
+		{">=3.8", "3.12", true},
+		{">=3.8", "3.7.9", false},
+		{">=3.8, <4", "3.10.1", true},
+		{">=3.8,<4", "4.0", false},
+		{"==1.2.*", "1.2.5", true},
+		{"==1.2.*", "1.3", false},
+		{"==1.2.0.*", "1.2", true},
+		{"!=1.2.*", "1.2.5", false},
+		{"==1.0", "1.0.0", true},
+		{"==1.0", "1.0+local", true},
+		{"==1.0+local", "1.0", false},
+		{"!=1.0", "1.0.1", true},
+		{"~=2.2", "2.3", true},
+		{"~=2.2", "3.0", false},
+		{"~=1.4.5", "1.4.9", true},
+		{"~=1.4.5", "1.5.0", false},
+		{"<3.0", "3.0rc1", false},
+		{"<3.0", "2.9", true},
+		{">=2.0rc1,<3.0rc2", "3.0rc1", true},
+		{">1.7", "1.7.post1", false},
+		{">1.7", "1.7.1", true},
+		{">1.7", "1.7+local", false},
+		{">1.7.post2", "1.7.post3", true},
+		{">=1.0rc1", "1.0rc2", true},
+		{">=1.0", "2.0rc1", false},
+		{"===1.0+Local", "1.0+local", true},
+		{"<=2.0", "2.0+local", true},
+	}
+	for _, tc := range cases {
+		set, err := ParseSpecifierSet(tc.spec)
+		if err != nil {
+			t.Errorf("ParseSpecifierSet(%q) failed: %v", tc.spec, err)
+			continue
+		}
+		if got := set.Contains(MustParse(tc.version)); got != tc.match {
+			t.Errorf("Expected %q contains %s to be %t", tc.spec, tc.version, tc.match)
+		}
+	}
+
+	set, _ := ParseSpecifierSet(">=1.0")
+	if !set.ContainsPreReleases(MustParse("2.0rc1"), true) {
+		t.Errorf("Expected pre-release to match when pre-releases are allowed")
+	}
+}
+
+// TestParseSpecifierSetInvalid tests that malformed specifiers are rejected
+func TestParseSpecifierSetInvalid(t *testing.T) {
+	for _, spec := range []string{"3.8", ">=", ">=3.8,", ">=3.*", "~=1", "<=1.0+local", "==1.0.dev1.*", "=>3.8", ">=foo"} {
+		if _, err := ParseSpecifierSet(spec); !errors.Is(err, ErrInvalidSpecifier) {
+			t.Errorf("Expected %q to be invalid, got %v", spec, err)
+		}
+	}
+}
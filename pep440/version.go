@@ -0,0 +1,330 @@
+// Package pep440 parses, normalizes and compares Python package versions and version
+// specifiers, as defined by PEP 440 (https://packaging.python.org/en/latest/specifications/version-specifiers/).
+package pep440
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidVersion is returned when a version string does not follow PEP 440
+var ErrInvalidVersion = errors.New("invalid version")
+
+// versionPattern is the permissive version pattern from PEP 440, which accepts the
+// alternative spellings that are normalized away
+var versionPattern = regexp.MustCompile(`(?i)^\s*v?` +
+	`(?:(?P<epoch>[0-9]+)!)?` +
+	`(?P<release>[0-9]+(?:\.[0-9]+)*)` +
+	`(?P<pre>[-_.]?(?P<pre_l>alpha|a|beta|b|preview|pre|c|rc)[-_.]?(?P<pre_n>[0-9]+)?)?` +
+	`(?P<post>(?:-(?P<post_n1>[0-9]+))|(?:[-_.]?(?P<post_l>post|rev|r)[-_.]?(?P<post_n2>[0-9]+)?))?` +
+	`(?P<dev>[-_.]?(?P<dev_l>dev)[-_.]?(?P<dev_n>[0-9]+)?)?` +
+	`(?:\+(?P<local>[a-z0-9]+(?:[-_.][a-z0-9]+)*))?` +
+	`\s*$`)
+
+// preReleaseKinds maps the spellings of pre-release kinds to their normal form
+var preReleaseKinds = map[string]string{
+	"a": "a", "alpha": "a",
+	"b": "b", "beta": "b",
+	"c": "rc", "rc": "rc", "pre": "rc", "preview": "rc",
+}
+
+// preReleaseOrder ranks the normal pre-release kinds
+var preReleaseOrder = map[string]int{"a": 0, "b": 1, "rc": 2}
+
+// PreRelease is the pre-release segment of a version, such as rc1
+type PreRelease struct {
+	Kind   string
+	Number int
+}
+
+// Version is a parsed PEP 440 version
+type Version struct {
+	Epoch   int
+	Release []int
+	Pre     *PreRelease
+	Post    *int
+	Dev     *int
+	Local   []string
+}
+
+// Parse parses a version string. Alternative spellings such as "1.0-RC.1" are accepted,
+// and String returns the normalized form.
+func Parse(s string) (*Version, error) {
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+	}
+	group := func(name string) string {
+		return m[versionPattern.SubexpIndex(name)]
+	}
+
+	v := &Version{}
+	var err error
+	if e := group("epoch"); e != "" {
+		if v.Epoch, err = strconv.Atoi(e); err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+		}
+	}
+	for _, part := range strings.Split(group("release"), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+		}
+		v.Release = append(v.Release, n)
+	}
+	if group("pre") != "" {
+		n, err := optionalNumber(group("pre_n"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+		}
+		v.Pre = &PreRelease{Kind: preReleaseKinds[strings.ToLower(group("pre_l"))], Number: n}
+	}
+	if group("post") != "" {
+		n, err := optionalNumber(group("post_n1") + group("post_n2"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+		}
+		v.Post = &n
+	}
+	if group("dev") != "" {
+		n, err := optionalNumber(group("dev_n"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+		}
+		v.Dev = &n
+	}
+	if l := group("local"); l != "" {
+		v.Local = strings.FieldsFunc(strings.ToLower(l), func(r rune) bool {
+			return r == '-' || r == '_' || r == '.'
+		})
+	}
+	return v, nil
+}
+
+// MustParse parses a version string and panics if it is invalid
+func MustParse(s string) *Version {
+	v, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Normalize returns the normalized form of a version string
+func Normalize(s string) (string, error) {
+	v, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
+
+// optionalNumber parses the number of a version segment, which defaults to 0
+func optionalNumber(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// String returns the normalized form of the version
+func (v *Version) String() string {
+	var sb strings.Builder
+	sb.WriteString(v.Public())
+	if len(v.Local) > 0 {
+		sb.WriteString("+")
+		sb.WriteString(strings.Join(v.Local, "."))
+	}
+	return sb.String()
+}
+
+// Public returns the normalized form of the version without its local segment
+func (v *Version) Public() string {
+	var sb strings.Builder
+	if v.Epoch != 0 {
+		fmt.Fprintf(&sb, "%d!", v.Epoch)
+	}
+	sb.WriteString(v.BaseVersion())
+	if v.Pre != nil {
+		fmt.Fprintf(&sb, "%s%d", v.Pre.Kind, v.Pre.Number)
+	}
+	if v.Post != nil {
+		fmt.Fprintf(&sb, ".post%d", *v.Post)
+	}
+	if v.Dev != nil {
+		fmt.Fprintf(&sb, ".dev%d", *v.Dev)
+	}
+	return sb.String()
+}
+
+// BaseVersion returns the release segment of the version, such as 1.2.3
+func (v *Version) BaseVersion() string {
+	parts := make([]string, len(v.Release))
+	for i, n := range v.Release {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// IsPreRelease reports whether the version is a pre-release or a development release
+func (v *Version) IsPreRelease() bool {
+	return v.Pre != nil || v.Dev != nil
+}
+
+// IsPostRelease reports whether the version is a post-release
+func (v *Version) IsPostRelease() bool {
+	return v.Post != nil
+}
+
+// Compare compares two versions following PEP 440 ordering, returning -1, 0 or 1.
+// Trailing zeros of the release segment are ignored, so 1.0 and 1.0.0 are equal.
+func (v *Version) Compare(o *Version) int {
+	if c := cmp.Compare(v.Epoch, o.Epoch); c != 0 {
+		return c
+	}
+	if c := compareRelease(v.Release, o.Release); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.preKey(), o.preKey()); c != 0 {
+		return c
+	}
+	if c := comparePreNumber(v, o); c != 0 {
+		return c
+	}
+	if c := compareOptional(v.Post, o.Post, -1); c != 0 {
+		return c
+	}
+	if c := compareOptional(v.Dev, o.Dev, 1); c != 0 {
+		return c
+	}
+	return compareLocal(v.Local, o.Local)
+}
+
+// Equal reports whether two versions compare as equal
+func (v *Version) Equal(o *Version) bool {
+	return v.Compare(o) == 0
+}
+
+// preKey ranks the pre-release segment. Development releases without a pre-release or
+// post-release sort before all pre-releases, and final releases after them.
+func (v *Version) preKey() int {
+	switch {
+	case v.Pre != nil:
+		return preReleaseOrder[v.Pre.Kind]
+	case v.Dev != nil && v.Post == nil:
+		return -1
+	default:
+		return len(preReleaseOrder)
+	}
+}
+
+// comparePreNumber compares the numbers of pre-releases of the same kind
+func comparePreNumber(v, o *Version) int {
+	if v.Pre == nil || o.Pre == nil {
+		return 0
+	}
+	return cmp.Compare(v.Pre.Number, o.Pre.Number)
+}
+
+// compareRelease compares release segments, ignoring trailing zeros
+func compareRelease(a, b []int) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if c := cmp.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareOptional compares optional segment numbers. A missing segment sorts before
+// present ones if missing is -1, and after them if it is 1.
+func compareOptional(a, b *int, missing int) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return missing
+	case b == nil:
+		return -missing
+	default:
+		return cmp.Compare(*a, *b)
+	}
+}
+
+// compareLocal compares local version labels. Numeric segments sort after alphanumeric
+// ones, and a label that extends another sorts after it.
+func compareLocal(a, b []string) int {
+	for i := 0; i < min(len(a), len(b)); i++ {
+		x, xErr := strconv.Atoi(a[i])
+		y, yErr := strconv.Atoi(b[i])
+		var c int
+		switch {
+		case xErr == nil && yErr == nil:
+			c = cmp.Compare(x, y)
+		case xErr == nil:
+			c = 1
+		case yErr == nil:
+			c = -1
+		default:
+			c = strings.Compare(a[i], b[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// Compare compares two version strings. Invalid versions sort before all valid ones,
+// and by their text among themselves, so that listings of legacy data stay stable.
+func Compare(a, b string) int {
+	va, errA := Parse(a)
+	vb, errB := Parse(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	if c := va.Compare(vb); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// Sort sorts version strings in ascending PEP 440 order
+func Sort(versions []string) {
+	slices.SortStableFunc(versions, Compare)
+}
+
+// Latest returns the index of the latest version in a list, preferring final releases
+// over pre-releases as pip does. Returns -1 if the list is empty.
+func Latest(versions []string) int {
+	latest := -1
+	latestPre := true
+	for i, s := range versions {
+		v, err := Parse(s)
+		pre := err != nil || v.IsPreRelease()
+		switch {
+		case latest < 0,
+			latestPre && !pre,
+			latestPre == pre && Compare(s, versions[latest]) > 0:
+			latest, latestPre = i, pre
+		}
+	}
+	return latest
+}
@@ -0,0 +1,110 @@
+package pep440
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestNormalize tests normalization of alternative version spellings
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"1.0":              "1.0",
+		"v1.0":             "1.0",
+		" 1.0.0 ":          "1.0.0",
+		"1.0-RC.1":         "1.0rc1",
+		"1.0alpha2":        "1.0a2",
+		"1.0.beta":         "1.0b0",
+		"1.0c3":            "1.0rc3",
+		"1.0pre1":          "1.0rc1",
+		"1.0-1":            "1.0.post1",
+		"1.0.rev":          "1.0.post0",
+		"1.0_r2":           "1.0.post2",
+		"1.0-dev":          "1.0.dev0",
+		"1.0a1.post2.dev3": "1.0a1.post2.dev3",
+		"0!1.0":            "1.0",
+		"2!1.0":            "2!1.0",
+		"1.0+Ubuntu-1_2":   "1.0+ubuntu.1.2",
+		"01.002":           "1.2",
+	}
+	for in, expected := range cases {
+		got, err := Normalize(in)
+		if err != nil {
+			t.Errorf("Normalize(%q) failed: %v", in, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("Normalize(%q) = %q, expected %q", in, got, expected)
+		}
+	}
+
+	for _, in := range []string{"", "1.0.", "1..0", "a1.0", "1.0+", "1.0-foo", "1.0 1", "latest"} {
+		if _, err := Parse(in); !errors.Is(err, ErrInvalidVersion) {
+			t.Errorf("Expected %q to be invalid, got %v", in, err)
+		}
+	}
+}
+
+// TestCompare tests that versions are ordered as specified by PEP 440
+func TestCompare(t *testing.T) {
+	ordered := []string{
+		"1.0.dev456",
+		"1.0a1",
+		"1.0a2.dev456",
+		"1.0a12.dev456",
+		"1.0a12",
+		"1.0b1.dev456",
+		"1.0b2",
+		"1.0b2.post345.dev456",
+		"1.0b2.post345",
+		"1.0rc1.dev456",
+		"1.0rc1",
+		"1.0",
+		"1.0+abc.5",
+		"1.0+abc.7",
+		"1.0+5",
+		"1.0.post456.dev34",
+		"1.0.post456",
+		"1.0.15",
+		"1.1.dev1",
+		"2.0.0",
+		"10.0",
+		"1!0.1",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, b := MustParse(ordered[i]), MustParse(ordered[i+1])
+		if a.Compare(b) >= 0 || b.Compare(a) <= 0 {
+			t.Errorf("Expected %s < %s", ordered[i], ordered[i+1])
+		}
+	}
+
+	if !MustParse("1.0").Equal(MustParse("1.0.0")) {
+		t.Errorf("Expected trailing zeros to be ignored")
+	}
+
+	shuffled := []string{"2.0.0", "not-a-version", "1.0.1", "10.0", "1.0rc1", "1.0"}
+	Sort(shuffled)
+	expected := []string{"not-a-version", "1.0rc1", "1.0", "1.0.1", "2.0.0", "10.0"}
+	if !slices.Equal(shuffled, expected) {
+		t.Errorf("Expected %v, got %v", expected, shuffled)
+	}
+}
+
+// TestLatest tests that final releases are preferred over pre-releases
+func TestLatest(t *testing.T) {
+	cases := []struct {
+		versions []string
+		latest   int
+	}{
+		{nil, -1},
+		{[]string{"2.0.0", "1.0.1"}, 0},
+		{[]string{"1.0", "2.0rc1", "1.1"}, 2},
+		{[]string{"1.0a1", "1.0b1"}, 1},
+		{[]string{"invalid", "0.1.dev1"}, 1},
+	}
+	for _, tc := range cases {
+		if got := Latest(tc.versions); got != tc.latest {
+			t.Errorf("Latest(%v) = %d, expected %d", tc.versions, got, tc.latest)
+		}
+	}
+}
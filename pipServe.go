@@ -1,34 +1,96 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"go-pip-server/oidc"
 	"go-pip-server/repository"
+	"go-pip-server/upstream"
+	"html/template"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 type PipServer struct {
-	Server   *http.Server
-	DBConn   *sql.DB
-	Repo     *repository.Repository
-	isSetUp  bool
-	DataPath string
+	Server    *http.Server
+	DBConn    *sql.DB
+	Repo      *repository.Repository
+	Templates *template.Template
+	isSetUp   bool
+	DataPath  string
+	// AllowIdenticalReupload accepts re-uploads of an existing file with the same content
+	AllowIdenticalReupload bool
+	// MaxUploadSize is the maximum size of upload requests in bytes
+	MaxUploadSize int64
+	// AllowAnonymousUploads accepts uploads without credentials, from anyone who can reach the server
+	AllowAnonymousUploads bool
+	// Private requires authentication to read the index and download files, users only
+	// see the projects they have a role on
+	Private bool
+	// AdminToken is the bearer token for the admin endpoints, which are disabled if empty
+	AdminToken string
+	// OIDC verifies the tokens which trusted publishers exchange for upload tokens
+	OIDC *oidc.Verifier
+	// OIDCAudience is the default audience of the OIDC tokens of trusted publishers
+	OIDCAudience string
+	// OIDCIssuers are the issuers which project owners can register trusted publishers
+	// of, admins can register publishers of any issuer
+	OIDCIssuers []string
+	// Upstream serves the projects which do not exist locally from an upstream index, if set
+	Upstream *upstream.Cache
+	// ExternalURL is the URL which clients reach the server at, without a trailing slash.
+	// Absolute URLs of the server's pages are only given if it is set.
+	ExternalURL string
+	// UpstreamTTL and Offline apply to the upstream indexes of all indexes
+	UpstreamTTL time.Duration
+	Offline     bool
+
+	// upstreams are the caches of the upstream indexes set on indexes
+	upstreams      map[string]*upstream.Cache
+	upstreamsMu    sync.Mutex
+	upstreamClient *http.Client
+
+	// indexes caches the served indexes by name, and indexesGeneration counts how many
+	// times the cache was cleared
+	indexes           map[string]*cachedIndex
+	indexesGeneration int
+	indexesMu         sync.Mutex
 }
 
 // NewPipServer Instantiates and sets up a new Pip Server
 func NewPipServer(db *sql.DB, cfg *Config) (*PipServer, error) {
+	if cfg.ExternalURL != "" {
+		u, err := url.Parse(cfg.ExternalURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("invalid -external-url %q, expected an http(s) URL", cfg.ExternalURL)
+		}
+	}
 	repo, err := repository.NewRepository(db, cfg.QueriesSource)
 	if err != nil {
 		return nil, err
 	}
 
+	repo.DeletionRetention = cfg.DeletionRetention
+	repo.AllowLoopbackHTTPIssuers = cfg.OIDCAllowLoopbackHTTP
 	err = repo.SetUpDB()
 	if err != nil {
 		return nil, err
 	}
+	purged, err := repo.PurgeExpiredProjects(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error purging expired projects: %w", err)
+	}
+	if len(purged) > 0 {
+		slog.Info("Purged deleted projects past their retention", "projects", purged)
+	}
 	if _, err := os.Stat(cfg.DataPath); os.IsNotExist(err) {
 		err := os.MkdirAll(cfg.DataPath, 0755)
 		if err != nil {
@@ -37,13 +99,43 @@ func NewPipServer(db *sql.DB, cfg *Config) (*PipServer, error) {
 		}
 	}
 
+	tmpl, err := template.ParseGlob(filepath.Join(cfg.TemplatesDir, "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("error loading HTML templates: %w", err)
+	}
+
 	srv := http.Server{Addr: fmt.Sprintf("%s:%d", cfg.HostAddr, cfg.Port)}
 	pip := &PipServer{
-		Server:   &srv,
-		DBConn:   db,
-		isSetUp:  false,
-		Repo:     repo,
-		DataPath: cfg.DataPath,
+		Server:    &srv,
+		DBConn:    db,
+		isSetUp:   false,
+		Repo:      repo,
+		Templates: tmpl,
+		DataPath:  cfg.DataPath,
+
+		AllowIdenticalReupload: cfg.AllowIdenticalReupload,
+		MaxUploadSize:          cfg.MaxUploadSize,
+		AllowAnonymousUploads:  cfg.AllowAnonymousUploads,
+		AdminToken:             cfg.AdminToken,
+		Private:                cfg.Private,
+		OIDC:                   oidc.NewVerifier(&http.Client{Timeout: 10 * time.Second}),
+		OIDCAudience:           cfg.OIDCAudience,
+		OIDCIssuers:            cfg.OIDCIssuers,
+		ExternalURL:            strings.TrimSuffix(cfg.ExternalURL, "/"),
+		UpstreamTTL:            cfg.UpstreamTTL,
+		Offline:                cfg.Offline,
+		upstreams:              make(map[string]*upstream.Cache),
+		indexes:                make(map[string]*cachedIndex),
+	}
+	// Downloads of large files may take long, only waiting for responses is limited
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	pip.OIDC.AllowLoopbackHTTP = cfg.OIDCAllowLoopbackHTTP
+	pip.upstreamClient = &http.Client{Transport: transport}
+	if cfg.UpstreamURL != "" {
+		pip.Upstream = upstream.New(cfg.UpstreamURL, filepath.Join(cfg.DataPath, UpstreamCacheDir), pip.upstreamClient)
+		pip.Upstream.PageTTL = cfg.UpstreamTTL
+		pip.Upstream.Offline = cfg.Offline
 	}
 	err = pip.SetUpRoutes()
 	if err != nil {
@@ -59,9 +151,80 @@ func (p *PipServer) SetUpRoutes() error {
 	if p.isSetUp {
 		return errors.New("the server routes have already been set up")
 	}
+	// Routes of an index are served at the root for the default index, and under
+	// /<index>/ for the others
+	idxMux := http.NewServeMux()
+	idxMux.HandleFunc("GET /simple/{$}", p.requireReader(p.HandleSimpleIndex))
+	idxMux.HandleFunc("GET /simple/{project}/{$}", p.requireReader(p.HandleSimpleProject))
+	idxMux.HandleFunc("GET /packages/{project}/{filename}", p.requireReader(p.HandlePackageFile))
+	idxMux.HandleFunc("GET /upstream/{project}/{filename}", p.requireReader(p.HandleUpstreamFile))
+	idxMux.HandleFunc("POST /upload/", p.requireUploader(p.HandleUpload))
+	idxMux.HandleFunc("GET /_/oidc/audience", p.HandleOIDCAudience)
+	idxMux.HandleFunc("POST /_/oidc/mint-token", p.HandleMintToken)
+
+	// Project management by collaborators, according to their role
+	idxMux.HandleFunc("GET /projects/{project}/collaborators/{$}", p.requireRole(repository.PermissionRead, p.HandleListCollaborators))
+	idxMux.HandleFunc("PUT /projects/{project}/collaborators/{kind}/{name}", p.requireRole(repository.PermissionManage, p.HandleSetCollaborator))
+	idxMux.HandleFunc("DELETE /projects/{project}/collaborators/{kind}/{name}", p.requireRole(repository.PermissionManage, p.HandleSetCollaborator))
+	idxMux.HandleFunc("GET /projects/{project}/publishers/{$}", p.requireRole(repository.PermissionManage, p.HandleListPublishers))
+	idxMux.HandleFunc("POST /projects/{project}/publishers/{$}", p.requireRole(repository.PermissionManage, p.HandleOwnerAddPublisher))
+	idxMux.HandleFunc("DELETE /projects/{project}/publishers/{id}", p.requireRole(repository.PermissionManage, p.HandleRemovePublisher))
+	idxMux.HandleFunc("GET /projects/{project}/source", p.requireRole(repository.PermissionRead, p.HandleGetSource))
+	idxMux.HandleFunc("PUT /projects/{project}/source", p.requireRole(repository.PermissionManage, p.HandleSetSource))
+	idxMux.HandleFunc("DELETE /projects/{project}/source", p.requireRole(repository.PermissionManage, p.HandleSetSource))
+	idxMux.HandleFunc("PUT /projects/{project}/status", p.requireRole(repository.PermissionManage, p.HandleOwnerProjectStatus))
+	idxMux.HandleFunc("DELETE /projects/{project}", p.requireRole(repository.PermissionManage, p.HandleOwnerDeleteProject))
+	idxMux.HandleFunc("PUT /projects/{project}/releases/{version}/yank", p.requireRole(repository.PermissionYank, p.HandleYankRelease))
+	idxMux.HandleFunc("DELETE /projects/{project}/releases/{version}/yank", p.requireRole(repository.PermissionYank, p.HandleYankRelease))
+	idxMux.HandleFunc("PUT /projects/{project}/files/{filename}/yank", p.requireRole(repository.PermissionYank, p.HandleYankFile))
+	idxMux.HandleFunc("DELETE /projects/{project}/files/{filename}/yank", p.requireRole(repository.PermissionYank, p.HandleYankFile))
+	idxMux.HandleFunc("DELETE /projects/{project}/releases/{version}", p.requireRole(repository.PermissionDelete, p.HandleDeleteRelease))
+	idxMux.HandleFunc("DELETE /projects/{project}/files/{filename}", p.requireRole(repository.PermissionDelete, p.HandleDeleteFile))
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/simple/", p.HandleSimpleIndex)
-	mux.HandleFunc("/upload/", p.HandleUpload)
+	mux.Handle("/", p.serveIndex(idxMux))
+	mux.HandleFunc("GET /tokens/{$}", p.requireUser(p.HandleListTokens))
+	mux.HandleFunc("POST /tokens/{$}", p.requireUser(p.HandleCreateToken))
+	mux.HandleFunc("DELETE /tokens/{id}", p.requireUser(p.HandleRevokeToken))
+
+	if p.Upstream != nil {
+		slog.Info("Serving missing projects from the upstream index", "url", p.Upstream.BaseURL, "offline", p.Upstream.Offline)
+	}
+	if p.AllowAnonymousUploads {
+		slog.Warn("Anonymous uploads are allowed, anyone who can reach the server can upload")
+	}
+	if p.AdminToken != "" {
+		idxMux.HandleFunc("PUT /admin/projects/{project}/status", p.requireAdmin(p.HandleProjectStatus))
+		idxMux.HandleFunc("GET /admin/projects/{project}/collaborators/{$}", p.requireAdmin(p.HandleListCollaborators))
+		idxMux.HandleFunc("PUT /admin/projects/{project}/collaborators/{kind}/{name}", p.requireAdmin(p.HandleSetCollaborator))
+		idxMux.HandleFunc("DELETE /admin/projects/{project}/collaborators/{kind}/{name}", p.requireAdmin(p.HandleSetCollaborator))
+		idxMux.HandleFunc("GET /admin/projects/{project}/publishers/{$}", p.requireAdmin(p.HandleListPublishers))
+		idxMux.HandleFunc("POST /admin/projects/{project}/publishers/{$}", p.requireAdmin(p.HandleAddPublisher))
+		idxMux.HandleFunc("DELETE /admin/projects/{project}/publishers/{id}", p.requireAdmin(p.HandleRemovePublisher))
+		idxMux.HandleFunc("GET /admin/projects/{project}/source", p.requireAdmin(p.HandleGetSource))
+		idxMux.HandleFunc("PUT /admin/projects/{project}/source", p.requireAdmin(p.HandleSetSource))
+		idxMux.HandleFunc("DELETE /admin/projects/{project}/source", p.requireAdmin(p.HandleSetSource))
+		idxMux.HandleFunc("DELETE /admin/projects/{project}", p.requireAdmin(p.HandleDeleteProject))
+		idxMux.HandleFunc("DELETE /admin/projects/{project}/releases/{version}", p.requireAdmin(p.HandleDeleteRelease))
+		idxMux.HandleFunc("DELETE /admin/projects/{project}/files/{filename}", p.requireAdmin(p.HandleDeleteFile))
+		idxMux.HandleFunc("PUT /admin/projects/{project}/releases/{version}/yank", p.requireAdmin(p.HandleYankRelease))
+		idxMux.HandleFunc("DELETE /admin/projects/{project}/releases/{version}/yank", p.requireAdmin(p.HandleYankRelease))
+		idxMux.HandleFunc("PUT /admin/projects/{project}/files/{filename}/yank", p.requireAdmin(p.HandleYankFile))
+		idxMux.HandleFunc("DELETE /admin/projects/{project}/files/{filename}/yank", p.requireAdmin(p.HandleYankFile))
+		mux.HandleFunc("GET /admin/indexes/{$}", p.requireAdmin(p.HandleListIndexes))
+		mux.HandleFunc("POST /admin/indexes/{$}", p.requireAdmin(p.HandleCreateIndex))
+		mux.HandleFunc("PUT /admin/indexes/{index}", p.requireAdmin(p.HandleIndex))
+		mux.HandleFunc("DELETE /admin/indexes/{index}", p.requireAdmin(p.HandleIndex))
+		mux.HandleFunc("GET /admin/groups/{$}", p.requireAdmin(p.HandleListGroups))
+		mux.HandleFunc("PUT /admin/groups/{group}", p.requireAdmin(p.HandleGroup))
+		mux.HandleFunc("DELETE /admin/groups/{group}", p.requireAdmin(p.HandleGroup))
+		mux.HandleFunc("PUT /admin/groups/{group}/members/{username}", p.requireAdmin(p.HandleGroupMember))
+		mux.HandleFunc("DELETE /admin/groups/{group}/members/{username}", p.requireAdmin(p.HandleGroupMember))
+		mux.HandleFunc("GET /admin/tokens/{$}", p.requireAdmin(p.HandleAdminListTokens))
+		mux.HandleFunc("DELETE /admin/tokens/{id}", p.requireAdmin(p.HandleAdminRevokeToken))
+	} else {
+		slog.Info("Admin endpoints are disabled, set an admin token to enable them")
+	}
 	p.isSetUp = true
 	p.Server.Handler = mux
 
@@ -74,5 +237,12 @@ func (p *PipServer) Serve() error {
 	if !p.isSetUp {
 		return errors.New("the server routes have not been set up")
 	}
+	// Wheels uploaded before metadata files were stored get them once, rather than
+	// when their page is rendered
+	go func() {
+		if err := p.extractMissingMetadata(context.Background()); err != nil {
+			slog.Error("Error extracting metadata files", "error", err)
+		}
+	}()
 	return p.Server.ListenAndServe()
 }
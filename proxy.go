@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-pip-server/repository"
+	"go-pip-server/upstream"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// errUpstreamFailed is wrapped by the errors of upstream indexes other than missing projects
+var errUpstreamFailed = errors.New("upstream index failed")
+
+// upstreamProjectPage builds the page of a project from the upstream index, with its
+// file URLs rewritten to go through the server. Returns ErrProjectNotFound if the project
+// does not exist upstream.
+func (p *PipServer) upstreamProjectPage(r *http.Request, name string) (*SimpleProjectResponse, error) {
+	idx := requestIndex(r.Context())
+	page, err := idx.Upstream.Project(r.Context(), name)
+	if errors.Is(err, upstream.ErrNotFound) {
+		return nil, repository.ErrProjectNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUpstreamFailed, err)
+	}
+
+	rsp := SimpleProjectResponse{
+		Name:     name,
+		Files:    upstreamFiles(idx.Prefix, name, page),
+		Versions: page.Versions,
+		Metadata: APIMeta{
+			Version: APIVersion,
+			MaxId:   page.Meta.LastSerial,
+		},
+		// The page mirrors the upstream project (PEP 708)
+		Tracks: []string{idx.Upstream.ProjectURL(name)},
+	}
+	if rsp.Versions == nil {
+		rsp.Versions = []string{}
+	}
+	if page.ProjectStatus != nil {
+		rsp.ProjectStatus = projectStatusInfo(page.ProjectStatus.Status, page.ProjectStatus.Reason)
+	}
+	return &rsp, nil
+}
+
+// mergeUpstreamFiles adds the files of the upstream project to the page of a local
+// project, except those with the name of a local file. The local page is served alone if
+// the upstream index fails.
+func (p *PipServer) mergeUpstreamFiles(r *http.Request, rsp *SimpleProjectResponse) {
+	idx := requestIndex(r.Context())
+	page, err := idx.Upstream.Project(r.Context(), rsp.Name)
+	if errors.Is(err, upstream.ErrNotFound) {
+		return
+	} else if err != nil {
+		slog.Warn("Serving local files only, the upstream index failed", "project", rsp.Name, "error", err)
+		return
+	}
+
+	local := make(map[string]bool, len(rsp.Files))
+	for _, f := range rsp.Files {
+		local[f.Filename] = true
+	}
+	for _, f := range upstreamFiles(idx.Prefix, rsp.Name, page) {
+		if !local[f.Filename] {
+			rsp.Files = append(rsp.Files, f)
+		}
+	}
+	for _, v := range page.Versions {
+		if !slices.Contains(rsp.Versions, v) {
+			rsp.Versions = append(rsp.Versions, v)
+		}
+	}
+	// Both indexes serve the same project, so installers may merge them (PEP 708)
+	rsp.AlternateLocations = []string{idx.Upstream.ProjectURL(rsp.Name)}
+	if u := p.pageURL(r); u != "" {
+		rsp.AlternateLocations = []string{u, idx.Upstream.ProjectURL(rsp.Name)}
+	}
+}
+
+// upstreamFiles converts the files of an upstream page to the files of a project page of
+// the index with the given URL path prefix
+func upstreamFiles(prefix, name string, page *upstream.Page) []*SimpleFile {
+	files := make([]*SimpleFile, 0, len(page.Files))
+	for _, f := range page.Files {
+		// Files without a sha256 hash cannot be downloaded through the cache
+		if f.Hashes["sha256"] == "" {
+			continue
+		}
+		sf := &SimpleFile{
+			Filename:       f.Filename,
+			URL:            upstreamFileURL(prefix, name, f),
+			Hashes:         f.Hashes,
+			RequiresPython: f.RequiresPython,
+			UploadTime:     f.UploadTime,
+			Size:           f.Size,
+		}
+		if yanked, reason := f.YankedReason(); yanked {
+			sf.Yanked, sf.YankedReason = true, reason
+			if reason != "" {
+				sf.Yanked = reason
+			}
+		}
+		// The HTML page can only announce metadata files with their hash
+		if hashes := f.MetadataHashes(); hashes["sha256"] != "" {
+			sf.CoreMetadata = map[string]string{"sha256": hashes["sha256"]}
+			sf.DistInfoMetadata = sf.CoreMetadata
+		}
+		files = append(files, sf)
+	}
+	return files
+}
+
+// pageURL returns the absolute URL of the requested page under the external URL of the
+// server, or an empty string if none is set. Request headers such as Host are not used,
+// as clients could set them to any URL.
+func (p *PipServer) pageURL(r *http.Request) string {
+	if p.ExternalURL == "" {
+		return ""
+	}
+	return p.ExternalURL + requestIndex(r.Context()).Prefix + r.URL.Path
+}
+
+// upstreamFileURL builds the URL through which a file of the upstream index is
+// downloaded, with its hash as a fragment like local files
+func upstreamFileURL(prefix, project string, f *upstream.File) string {
+	u := prefix + "/upstream/" + url.PathEscape(project) + "/" + url.PathEscape(f.Filename)
+	if h := f.Hashes["sha256"]; h != "" {
+		u += "#sha256=" + h
+	}
+	return u
+}
+
+// HandleUpstreamFile serves a distribution file or core metadata file of a project of
+// the upstream index, from the cache or downloaded on first use, unless the project is
+// local according to its source policy.
+func (p *PipServer) HandleUpstreamFile(w http.ResponseWriter, r *http.Request) {
+	project, filename := r.PathValue("project"), r.PathValue("filename")
+	idx := requestIndex(r.Context())
+	if idx.Upstream == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	readable, err := p.canRead(r, project)
+	if err != nil {
+		slog.Error("Error checking read permission", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	} else if !readable || project != repository.NormalizeName(project) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	// Local projects are never served from upstream, unless their policy says so
+	policy, err := p.Repo.GetSourcePolicy(project, r.Context())
+	if err != nil {
+		slog.Error("Error fetching source policy", "project", project, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	} else if policy == repository.SourceLocal {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	fp, err := idx.Upstream.File(r.Context(), project, filename)
+	if errors.Is(err, upstream.ErrNotFound) || errors.Is(err, upstream.ErrNoHash) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("Error fetching upstream file", "project", project, "file", filename, "error", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	fh, err := os.Open(fp)
+	if err != nil {
+		slog.Error("Error opening cached upstream file", "file", fp, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer fh.Close()
+	info, err := fh.Stat()
+	if err != nil {
+		slog.Error("Error opening cached upstream file", "file", fp, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if strings.HasSuffix(filename, MetadataFileSuffix) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", distContentType(filename))
+	}
+	w.Header().Set("Cache-Control", p.fileCacheControl(r))
+	http.ServeContent(w, r, filename, info.ModTime(), fh)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"go-pip-server/oidc"
+	"go-pip-server/repository"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// PublisherTokenTTL is the lifetime of the upload tokens minted for trusted publishers
+const PublisherTokenTTL = 15 * time.Minute
+
+// DefaultOIDCIssuers are the issuers of the hosted CI services, which project owners can
+// register trusted publishers of unless -oidc-issuers is set
+var DefaultOIDCIssuers = []string{"https://token.actions.githubusercontent.com", "https://gitlab.com"}
+
+// mintRequest is the body of requests exchanging an OIDC token for an upload token
+type mintRequest struct {
+	Token string `json:"token"`
+}
+
+// mintResponse is returned with the minted upload token, like PyPI does
+type mintResponse struct {
+	Success bool      `json:"success"`
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// mintErrorDetail describes why a token exchange failed
+type mintErrorDetail struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// mintErrorResponse is returned when a token exchange fails, like PyPI does
+type mintErrorResponse struct {
+	Message string            `json:"message"`
+	Errors  []mintErrorDetail `json:"errors"`
+}
+
+// mintError rejects a token exchange
+func mintError(w http.ResponseWriter, status int, code, description string) {
+	slog.Warn("Rejected token exchange", "code", code, "error", description)
+	writeJSON(w, status, &mintErrorResponse{
+		Message: "Token request failed",
+		Errors:  []mintErrorDetail{{Code: code, Description: description}},
+	})
+}
+
+// HandleOIDCAudience returns the audience which CI jobs request their OIDC tokens for
+func (p *PipServer) HandleOIDCAudience(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"audience": p.OIDCAudience})
+}
+
+// HandleMintToken exchanges an OIDC token of a CI job for a short-lived upload token,
+// covering the projects of the trusted publishers whose constraints the token matches
+func (p *PipServer) HandleMintToken(w http.ResponseWriter, r *http.Request) {
+	var req mintRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil || req.Token == "" {
+		mintError(w, http.StatusUnprocessableEntity, "invalid-payload", "The request body must be a JSON object with a token.")
+		return
+	}
+	issuer, err := oidc.UnverifiedIssuer(req.Token)
+	if err != nil {
+		mintError(w, http.StatusUnprocessableEntity, "invalid-token", err.Error())
+		return
+	}
+	publishers, err := p.Repo.GetPublishersByIssuer(issuer, r.Context())
+	if err != nil {
+		slog.Error("Error fetching trusted publishers", "issuer", issuer, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if len(publishers) == 0 {
+		mintError(w, http.StatusUnprocessableEntity, "invalid-publisher", "No trusted publisher is registered for the issuer "+issuer+".")
+		return
+	}
+
+	// The token is verified once for each audience of the issuer's publishers
+	verified := make(map[string]oidc.Claims)
+	var verifyErr error
+	var matched []*repository.TrustedPublisher
+	var claims oidc.Claims
+	for _, tp := range publishers {
+		cl, ok := verified[tp.Audience]
+		if !ok {
+			cl, err = p.OIDC.Verify(r.Context(), req.Token, issuer, tp.Audience)
+			if errors.Is(err, oidc.ErrFetchKeys) {
+				slog.Error("Error fetching the signing keys of an issuer", "issuer", issuer, "error", err)
+				http.Error(w, "Unable to verify the token, try again later.", http.StatusServiceUnavailable)
+				return
+			} else if err != nil {
+				verifyErr = err
+			}
+			verified[tp.Audience] = cl
+		}
+		if cl != nil && tp.Matches(cl) {
+			matched = append(matched, tp)
+			claims = cl
+		}
+	}
+	if len(matched) == 0 {
+		for _, cl := range verified {
+			if cl != nil {
+				mintError(w, http.StatusUnprocessableEntity, "invalid-publisher", "The token does not match any trusted publisher.")
+				return
+			}
+		}
+		mintError(w, http.StatusUnprocessableEntity, "invalid-token", verifyErr.Error())
+		return
+	}
+
+	// Tokens are only exchanged once, which requires their ID
+	jti, _ := claims.String("jti")
+	if jti == "" {
+		mintError(w, http.StatusUnprocessableEntity, "invalid-token", "The token has no jti claim.")
+		return
+	}
+	secret, expires, err := p.Repo.MintPublisherToken(matched, jti, PublisherTokenTTL, r.Context())
+	if errors.Is(err, repository.ErrTokenReused) {
+		mintError(w, http.StatusUnprocessableEntity, "invalid-reuse-token", "The token was already used, request a new one.")
+		return
+	} else if err != nil {
+		slog.Error("Error minting upload token", "issuer", issuer, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	sub, _ := claims.String("sub")
+	projects := make([]string, 0, len(matched))
+	for _, tp := range matched {
+		projects = append(projects, tp.Project)
+	}
+	slog.Info("Minted upload token", "issuer", issuer, "subject", sub, "projects", projects, "token", secret[:12])
+	writeJSON(w, http.StatusOK, &mintResponse{Success: true, Token: secret, Expires: expires})
+}
+
+// HandleListPublishers returns the trusted publishers of a project
+func (p *PipServer) HandleListPublishers(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	if _, err := p.Repo.GetProject(project, r.Context()); errors.Is(err, repository.ErrProjectNotFound) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	publishers, err := p.Repo.GetTrustedPublishers(project, r.Context())
+	if err != nil {
+		slog.Error("Error fetching trusted publishers", "project", project, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, publishers)
+}
+
+// HandleAddPublisher registers a trusted publisher of any issuer for a project. The
+// audience defaults to the one of the server.
+func (p *PipServer) HandleAddPublisher(w http.ResponseWriter, r *http.Request) {
+	p.addPublisher(w, r, true)
+}
+
+// HandleOwnerAddPublisher registers a trusted publisher for a project on behalf of its
+// owners, who are limited to the issuers of OIDCIssuers. Other issuers could make the
+// server send requests to any address.
+func (p *PipServer) HandleOwnerAddPublisher(w http.ResponseWriter, r *http.Request) {
+	p.addPublisher(w, r, false)
+}
+
+// addPublisher registers the trusted publisher of a request, of any issuer or of the
+// issuers of OIDCIssuers only
+func (p *PipServer) addPublisher(w http.ResponseWriter, r *http.Request, anyIssuer bool) {
+	project := r.PathValue("project")
+	var ins repository.TrustedPublisherInsert
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&ins); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	if !anyIssuer && !slices.Contains(p.OIDCIssuers, ins.Issuer) {
+		http.Error(w, "Only admins can register trusted publishers of this issuer.", http.StatusForbidden)
+		return
+	}
+	if ins.Audience == "" {
+		ins.Audience = p.OIDCAudience
+	}
+
+	tp, err := p.Repo.AddTrustedPublisher(project, &ins, r.Context())
+	switch {
+	case errors.Is(err, repository.ErrProjectNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrInvalidPublisher):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		slog.Error("Error adding trusted publisher", "project", project, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Added trusted publisher", "project", project, "id", tp.ID, "issuer", tp.Issuer, "claims", tp.Claims)
+		writeJSON(w, http.StatusCreated, tp)
+	}
+}
+
+// HandleRemovePublisher removes a trusted publisher of a project
+func (p *PipServer) HandleRemovePublisher(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	err = p.Repo.RemoveTrustedPublisher(project, id, r.Context())
+	switch {
+	case errors.Is(err, repository.ErrPublisherNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case err != nil:
+		slog.Error("Error removing trusted publisher", "project", project, "id", id, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Removed trusted publisher", "project", project, "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
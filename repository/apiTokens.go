@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TokenUsername is the user name with which API tokens are sent in HTTP Basic
+// credentials, as on PyPI
+const TokenUsername = "__token__"
+
+// tokenPrefix starts every API token, so that leaked tokens are easy to recognize
+const tokenPrefix = "gps-"
+
+// tokenDisplayLen is the number of leading characters of a token which are stored in clear
+// to tell tokens apart
+const tokenDisplayLen = 12
+
+// Allows reports whether the token can upload to a project of an index
+func (t *APIToken) Allows(index int64, project string) bool {
+	return t.AllowsIndex(index) && (len(t.Projects) == 0 || slices.Contains(t.Projects, NormalizeName(project)))
+}
+
+// AllowsIndex reports whether the token can upload to an index
+func (t *APIToken) AllowsIndex(id int64) bool {
+	return t.IndexID == 0 || t.IndexID == id
+}
+
+// CreateAPIToken issues a new API token for a user. Tokens limited to projects are
+// limited to their index too. The secret token is only returned here, as only its hash is
+// stored. Returns ErrUserNotFound if there is no such user.
+func (r *Repository) CreateAPIToken(username string, ins *APITokenInsert, c context.Context) (*APIToken, string, error) {
+	u, err := r.GetUser(username, c)
+	if err != nil {
+		return nil, "", err
+	}
+	name := strings.TrimSpace(ins.Name)
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: a name is required", ErrInvalidToken)
+	}
+	if ins.ExpiresAt != nil && !ins.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: the expiry is in the past", ErrInvalidToken)
+	}
+
+	secret, err := newTokenSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &APIToken{
+		Username:  u.Username,
+		Name:      name,
+		Prefix:    secret[:tokenDisplayLen],
+		Projects:  make([]string, 0, len(ins.Projects)),
+		ExpiresAt: ins.ExpiresAt,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	for _, p := range ins.Projects {
+		if p = NormalizeName(p); p != "" && !slices.Contains(token.Projects, p) {
+			token.Projects = append(token.Projects, p)
+		}
+	}
+	slices.Sort(token.Projects)
+	var indexId any
+	if len(token.Projects) > 0 {
+		token.IndexID = IndexID(c)
+		if ins.Index != "" {
+			idx, err := r.GetIndex(ins.Index, c)
+			if errors.Is(err, ErrIndexNotFound) {
+				return nil, "", fmt.Errorf("%w: no index named %s", ErrInvalidToken, ins.Index)
+			} else if err != nil {
+				return nil, "", err
+			}
+			token.IndexID = idx.ID
+		}
+		indexId = token.IndexID
+	}
+
+	var expiresAt any
+	if token.ExpiresAt != nil {
+		expiresAt = token.ExpiresAt.UTC().Format(SQLiteTimeFormat)
+	}
+	err = r.withTx(c, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(
+			c,
+			`insert into api_tokens (user_id, index_id, name, token_prefix, token_hash, expires_at, created_at)
+             values (?, ?, ?, ?, ?, ?, ?)`,
+			u.ID,
+			indexId,
+			token.Name,
+			token.Prefix,
+			hashToken(secret),
+			expiresAt,
+			token.CreatedAt.Format(SQLiteTimeFormat),
+		)
+		if err != nil {
+			return err
+		}
+		if token.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		if indexId != nil {
+			err := tx.QueryRowContext(c, "select name from indexes where id = ?", indexId).Scan(&token.Index)
+			if err != nil {
+				return err
+			}
+		}
+		for _, p := range token.Projects {
+			_, err := tx.ExecContext(
+				c,
+				"insert into api_token_projects (token_id, project_name) values (?, ?)",
+				token.ID,
+				p,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+// GetAPITokens retrieves the API tokens of a user, or of all users if the name is empty
+func (r *Repository) GetAPITokens(username string, c context.Context) ([]*APIToken, error) {
+	rows, err := r.DB.QueryContext(
+		c,
+		"select "+tokenColumns+" from api_tokens as t join users as u on t.user_id = u.id "+
+			"where ? = '' or u.username = ? order by u.username, t.id",
+		username,
+		username,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*APIToken, 0, 8)
+	for rows.Next() {
+		t, _, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken deletes an API token of a user, or of any user if the name is empty.
+// Returns ErrTokenNotFound if there is no such token.
+func (r *Repository) RevokeAPIToken(username string, id int64, c context.Context) error {
+	res, err := r.DB.ExecContext(
+		c,
+		"delete from api_tokens where id = ? and (? = '' or user_id in (select id from users where username = ?))",
+		id,
+		username,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %d", ErrTokenNotFound, id)
+	}
+	return nil
+}
+
+// AuthenticateToken checks an API token and records its use, at most once every
+// TokenUseInterval. Returns ErrInvalidCredentials
+// if the token is unknown, ErrTokenExpired if it has expired, and ErrUserDisabled if its
+// user is disabled. Tokens minted for trusted publishers are returned without a user.
+func (r *Repository) AuthenticateToken(secret string, c context.Context) (*User, *APIToken, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, nil, ErrInvalidCredentials
+	}
+	t, u, err := scanToken(r.DB.QueryRowContext(
+		c,
+		"select "+tokenColumns+" from api_tokens as t join users as u on t.user_id = u.id where t.token_hash = ?",
+		hashToken(secret),
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		// Tokens minted for trusted publishers have no user
+		pt, err := r.authenticatePublisherToken(secret, c)
+		return nil, pt, err
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+		return nil, nil, ErrTokenExpired
+	}
+	if u.Disabled {
+		return nil, nil, ErrUserDisabled
+	}
+
+	// Recording every use would make each authenticated download a write
+	now := time.Now().UTC().Truncate(time.Second)
+	if t.LastUsedAt != nil && now.Sub(*t.LastUsedAt) < TokenUseInterval {
+		return u, t, nil
+	}
+	_, err = r.DB.ExecContext(
+		c,
+		"update api_tokens set last_used_at = ? where id = ?",
+		now.Format(SQLiteTimeFormat),
+		t.ID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.LastUsedAt = &now
+	return u, t, nil
+}
+
+// tokenColumns lists the columns scanned by scanToken, from api_tokens as t joined with users as u
+const tokenColumns = `t.id, t.name, t.token_prefix, t.expires_at, t.last_used_at, t.created_at,
+    (select coalesce(group_concat(project_name, ' '), '') from api_token_projects where token_id = t.id),
+    coalesce(t.index_id, 0), coalesce((select name from indexes where id = t.index_id), ''),
+    u.id, u.username, u.disabled, u.created_at`
+
+// scanToken scans a row selected with tokenColumns into a token and its user
+func scanToken(row scanner) (*APIToken, *User, error) {
+	var t APIToken
+	var u User
+	var expiresAt, lastUsedAt sql.NullTime
+	var projects string
+	err := row.Scan(
+		&t.ID, &t.Name, &t.Prefix, &expiresAt, &lastUsedAt, &t.CreatedAt, &projects, &t.IndexID, &t.Index,
+		&u.ID, &u.Username, &u.Disabled, &u.CreatedAt,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.Username = u.Username
+	t.Projects = strings.Fields(projects)
+	slices.Sort(t.Projects)
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, &u, nil
+}
+
+// newTokenSecret generates a random API token
+func newTokenSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashToken returns the hex encoded SHA256 hash under which a token is stored. Tokens are
+// random enough that a fast hash is safe, and it allows looking them up by hash.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
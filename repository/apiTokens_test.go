@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAPITokens tests issuing, authenticating with and revoking API tokens
+func TestAPITokens(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := repo.CreateUser("ci-bot", "correct horse", ctx); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	token, secret, err := repo.CreateAPIToken("ci-bot", &APITokenInsert{
+		Name:     "deploy",
+		Projects: []string{"My_Package", "other", "my-package"},
+	}, ctx)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, tokenPrefix) || !strings.HasPrefix(secret, token.Prefix) {
+		t.Errorf("Unexpected token %q with prefix %q", secret, token.Prefix)
+	}
+	if strings.Join(token.Projects, ",") != "my-package,other" {
+		t.Errorf("Expected normalized unique projects, got %v", token.Projects)
+	}
+
+	u, authed, err := repo.AuthenticateToken(secret, ctx)
+	if err != nil {
+		t.Fatalf("AuthenticateToken failed: %v", err)
+	}
+	if u.Username != "ci-bot" || authed.ID != token.ID || authed.LastUsedAt == nil {
+		t.Errorf("Unexpected user %+v and token %+v", u, authed)
+	}
+	if !authed.Allows(DefaultIndexID, "my.package") || authed.Allows(DefaultIndexID, "third") {
+		t.Errorf("Unexpected scopes of token %+v", authed)
+	}
+	if authed.Index != DefaultIndexName || authed.Allows(DefaultIndexID+1, "my-package") {
+		t.Errorf("Expected the token to be limited to the default index, got %+v", authed)
+	}
+	if _, _, err := repo.AuthenticateToken(secret+"x", ctx); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for a wrong token, got %v", err)
+	}
+
+	tokens, err := repo.GetAPITokens("ci-bot", ctx)
+	if err != nil {
+		t.Fatalf("GetAPITokens failed: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil || len(tokens[0].Projects) != 2 {
+		t.Errorf("Unexpected tokens %+v", tokens)
+	}
+
+	if err := repo.RevokeAPIToken("someone-else", token.ID, ctx); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Expected ErrTokenNotFound for the token of another user, got %v", err)
+	}
+	if err := repo.RevokeAPIToken("ci-bot", token.ID, ctx); err != nil {
+		t.Fatalf("RevokeAPIToken failed: %v", err)
+	}
+	if _, _, err := repo.AuthenticateToken(secret, ctx); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for a revoked token, got %v", err)
+	}
+}
+
+// TestAPITokenExpiry tests that expired tokens and tokens of disabled users are refused
+func TestAPITokenExpiry(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := repo.CreateUser("ci-bot", "correct horse", ctx); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	_, _, err := repo.CreateAPIToken("ci-bot", &APITokenInsert{Name: "old", ExpiresAt: &past}, ctx)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for an expiry in the past, got %v", err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	token, secret, err := repo.CreateAPIToken("ci-bot", &APITokenInsert{Name: "ci", ExpiresAt: &future}, ctx)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if _, _, err := repo.AuthenticateToken(secret, ctx); err != nil {
+		t.Errorf("Expected the token to be valid, got %v", err)
+	}
+
+	if err := repo.SetUserDisabled("ci-bot", true, ctx); err != nil {
+		t.Fatalf("SetUserDisabled failed: %v", err)
+	}
+	if _, _, err := repo.AuthenticateToken(secret, ctx); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("Expected ErrUserDisabled, got %v", err)
+	}
+	if err := repo.SetUserDisabled("ci-bot", false, ctx); err != nil {
+		t.Fatalf("SetUserDisabled failed: %v", err)
+	}
+
+	_, err = repo.DB.Exec(
+		"update api_tokens set expires_at = ? where id = ?",
+		time.Now().Add(-time.Minute).UTC().Format(SQLiteTimeFormat),
+		token.ID,
+	)
+	if err != nil {
+		t.Fatalf("Error expiring token: %v", err)
+	}
+	if _, _, err := repo.AuthenticateToken(secret, ctx); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
+
+// TestAPITokenIndexes tests that tokens limited to projects only cover the projects of
+// their index, while other tokens cover all indexes
+func TestAPITokenIndexes(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := repo.CreateUser("ci-bot", "correct horse", ctx); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	dev, err := repo.CreateIndex(&Index{Name: "dev"}, ctx)
+	if err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+
+	scoped, _, err := repo.CreateAPIToken("ci-bot", &APITokenInsert{Name: "dev", Projects: []string{"foo"}, Index: "dev"}, ctx)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if scoped.Index != "dev" || !scoped.Allows(dev.ID, "foo") || scoped.Allows(DefaultIndexID, "foo") {
+		t.Errorf("Expected the token to only cover foo in dev, got %+v", scoped)
+	}
+	fromContext, _, err := repo.CreateAPIToken("ci-bot", &APITokenInsert{Name: "ctx", Projects: []string{"foo"}}, WithIndex(ctx, dev.ID))
+	if err != nil || fromContext.IndexID != dev.ID {
+		t.Errorf("Expected the index of the context, got %+v (err: %v)", fromContext, err)
+	}
+	if _, _, err := repo.CreateAPIToken("ci-bot", &APITokenInsert{Name: "x", Projects: []string{"foo"}, Index: "missing"}, ctx); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for an unknown index, got %v", err)
+	}
+
+	unscoped, _, err := repo.CreateAPIToken("ci-bot", &APITokenInsert{Name: "all", Index: "dev"}, ctx)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if unscoped.Index != "" || !unscoped.Allows(DefaultIndexID, "foo") || !unscoped.Allows(dev.ID, "bar") {
+		t.Errorf("Expected the token to cover all indexes, got %+v", unscoped)
+	}
+
+	tokens, err := repo.GetAPITokens("ci-bot", ctx)
+	if err != nil || len(tokens) != 3 || tokens[0].Index != "dev" || tokens[2].IndexID != 0 {
+		t.Errorf("Unexpected tokens %+v (err: %v)", tokens, err)
+	}
+}
+
+// TestAPITokenLastUse tests that the use of a token is only recorded once per
+// TokenUseInterval
+func TestAPITokenLastUse(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := repo.CreateUser("ci-bot", "correct horse", ctx); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	token, secret, err := repo.CreateAPIToken("ci-bot", &APITokenInsert{Name: "ci"}, ctx)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	for _, stmt := range []string{
+		"create table token_writes (token_id integer not null)",
+		`create trigger count_token_writes after update of last_used_at on api_tokens
+         begin insert into token_writes values (new.id); end`,
+	} {
+		if _, err := repo.DB.Exec(stmt); err != nil {
+			t.Fatalf("Error counting writes: %v", err)
+		}
+	}
+	writes := func() int {
+		var n int
+		if err := repo.DB.QueryRow("select count(*) from token_writes").Scan(&n); err != nil {
+			t.Fatalf("Error counting writes: %v", err)
+		}
+		return n
+	}
+
+	for range 2 {
+		if _, authed, err := repo.AuthenticateToken(secret, ctx); err != nil || authed.LastUsedAt == nil {
+			t.Fatalf("AuthenticateToken failed: %+v (err: %v)", authed, err)
+		}
+	}
+	if n := writes(); n != 1 {
+		t.Errorf("Expected a single write for back-to-back authentications, got %d", n)
+	}
+
+	_, err = repo.DB.Exec(
+		"update api_tokens set last_used_at = ? where id = ?",
+		time.Now().Add(-TokenUseInterval).UTC().Format(SQLiteTimeFormat),
+		token.ID,
+	)
+	if err != nil {
+		t.Fatalf("Error updating last use: %v", err)
+	}
+	if _, _, err := repo.AuthenticateToken(secret, ctx); err != nil {
+		t.Fatalf("AuthenticateToken failed: %v", err)
+	}
+	if n := writes(); n != 3 {
+		t.Errorf("Expected the use to be recorded once the interval passed, got %d writes", n)
+	}
+}
@@ -1,9 +1,18 @@
 package repository
 
+import "time"
+
 // RequiredQueryFiles lists the SQL files required in the queries directory
 // The file names are semicolon-separated.
-const RequiredQueryFiles = "table-creation.sql"
+const RequiredQueryFiles = "0001-initial-schema.sql"
 
 const TableCreationTimeoutSeconds = 60
 
-const StatementSeparator = "--[SEP]--"
+// StatementSeparator separates the statements of a migration file, which are executed one by one
+const StatementSeparator = "-- [SEP] --"
+
+// SQLiteTimeFormat is the format of timestamps stored by SQLite's current_timestamp
+const SQLiteTimeFormat = "2006-01-02 15:04:05"
+
+// TokenUseInterval is how often the last use of an API token is recorded
+const TokenUseInterval = 5 * time.Minute
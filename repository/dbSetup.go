@@ -4,27 +4,20 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
 	"time"
 )
 
-// SetUpDB reads the table creation SQL file and executes it against the database
-// to set up the necessary tables. It uses a timeout to prevent long-running operations.
+// SetUpDB applies all pending migrations from the queries directory to set up the
+// necessary tables. It refuses to proceed if the database schema is ahead of the known
+// migrations, and uses a timeout to prevent long-running operations.
 func (r *Repository) SetUpDB() error {
-	schemaPath := filepath.Join(r.QueriesPath, "table-creation.sql")
-	schemaSQL, err := os.ReadFile(schemaPath)
-	if err != nil {
-		return fmt.Errorf("error reading schema file: %w", err)
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), TableCreationTimeoutSeconds*time.Second)
 	defer cancel()
 
-	_, err = r.DB.ExecContext(ctx, string(schemaSQL))
+	applied, err := r.MigrateUp(ctx)
 	if err != nil {
-		return fmt.Errorf("error executing schema SQL: %w", err)
+		return fmt.Errorf("error migrating database: %w", err)
 	}
-	slog.Info("Database tables have been set up successfully")
+	slog.Info("Database tables have been set up successfully", "migrations_applied", applied)
 	return nil
 }
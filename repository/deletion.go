@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// deletingSuffix is appended to stored files while their deletion is in progress
+const deletingSuffix = ".deleting"
+
+// metadataSuffix is appended to the path of a wheel to get its core metadata file
+const metadataSuffix = ".metadata"
+
+// PurgeProject permanently deletes a project with all its releases and files, from the
+// database and from disk. Its journal entries are kept, and its name can be used again.
+func (r *Repository) PurgeProject(n string, c context.Context) error {
+	pf, err := r.GetProjectFiles(n, c)
+	if err != nil {
+		return err
+	}
+	err = r.deleteWithFiles(pf.Files, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(c, "delete from projects where id = ?", pf.Project.ID)
+		if err != nil {
+			return err
+		}
+		return addJournalEntry(tx, pf.Project.NormalizedName, "", "", "remove project", c)
+	}, c)
+	if err != nil {
+		return err
+	}
+	// Files may be stored in several directories, such as the legacy paths of older
+	// releases. Each is only removed if nothing else was stored in it.
+	removed := make(map[string]bool)
+	for _, f := range pf.Files {
+		dir := filepath.Dir(f.FilePath)
+		if !removed[dir] {
+			removed[dir] = true
+			os.Remove(dir)
+		}
+	}
+	return nil
+}
+
+// DeleteRelease permanently deletes a release with all its files, from the database and
+// from disk. Returns ErrReleaseNotFound if the project has no such version.
+func (r *Repository) DeleteRelease(n, version string, c context.Context) error {
+	rl, err := r.GetRelease(n, version, c)
+	if err != nil {
+		return err
+	}
+	return r.deleteWithFiles(rl.Files, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(c, "delete from releases where id = ?", rl.ID)
+		if err != nil {
+			return err
+		}
+		return addJournalEntry(tx, n, rl.Version, "", "remove release", c)
+	}, c)
+}
+
+// DeleteFile permanently deletes a single distribution file, from the database and from
+// disk. Returns ErrFileNotFound if the project has no such file.
+func (r *Repository) DeleteFile(n, filename string, c context.Context) error {
+	f, err := r.GetProjectFile(n, filename, c)
+	if err != nil {
+		return err
+	}
+	return r.deleteWithFiles([]*ProjectFile{f}, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(c, "delete from release_files where id = ?", f.ID)
+		if err != nil {
+			return err
+		}
+		return addJournalEntry(tx, n, f.Version, f.Filename, "remove "+f.FileType+" file", c)
+	}, c)
+}
+
+// PurgeExpiredProjects permanently deletes the projects which were soft-deleted longer
+// than DeletionRetention ago, in every index. Returns the names of the purged projects.
+func (r *Repository) PurgeExpiredProjects(c context.Context) ([]string, error) {
+	if r.DeletionRetention == 0 {
+		return nil, nil
+	}
+	rows, err := r.DB.QueryContext(
+		c,
+		"select index_id, normalized_name from projects where status = ? and status_changed_at < ?",
+		ProjectDeleted,
+		time.Now().Add(-r.DeletionRetention).UTC().Format(SQLiteTimeFormat),
+	)
+	if err != nil {
+		return nil, err
+	}
+	type expired struct {
+		indexId int64
+		name    string
+	}
+	projects := make([]expired, 0, 4)
+	for rows.Next() {
+		var p expired
+		if err := rows.Scan(&p.indexId, &p.name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if actorFrom(c) == "" {
+		c = WithActor(c, "retention")
+	}
+	purged := make([]string, 0, len(projects))
+	for _, p := range projects {
+		if err := r.PurgeProject(p.name, WithIndex(c, p.indexId)); err != nil {
+			return purged, fmt.Errorf("error purging project %s: %w", p.name, err)
+		}
+		purged = append(purged, p.name)
+	}
+	return purged, nil
+}
+
+// FileNameUsed checks if a file name was ever uploaded, including files which were
+// deleted since. File names cannot be reused, as installers may have cached the files.
+func (r *Repository) FileNameUsed(filename string, c context.Context) (bool, error) {
+	var n int
+	err := r.DB.QueryRowContext(
+		c,
+		"select count(*) from journal_entries where index_id = ? and filename = ? and action like 'add %'",
+		IndexID(c),
+		filename,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// deleteWithFiles runs a deletion in a transaction and removes the stored files it
+// covers. The files are moved aside before the transaction commits, and moved back if it
+// fails, so that the database and the disk stay consistent.
+func (r *Repository) deleteWithFiles(files []*ProjectFile, fn func(tx *sql.Tx) error, c context.Context) error {
+	moved := make([]string, 0, 2*len(files))
+	restore := func() {
+		for _, fp := range moved {
+			if err := os.Rename(fp+deletingSuffix, fp); err != nil {
+				slog.Error("Unable to restore file after failed deletion", "file", fp, "error", err)
+			}
+		}
+	}
+	for _, f := range files {
+		for _, fp := range []string{f.FilePath, f.FilePath + metadataSuffix} {
+			err := os.Rename(fp, fp+deletingSuffix)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				restore()
+				return fmt.Errorf("error moving file for deletion: %w", err)
+			}
+			moved = append(moved, fp)
+		}
+	}
+
+	err := r.withTx(c, func(tx *sql.Tx) error {
+		// Deletions rely on cascading foreign keys to remove dependent rows
+		var enabled bool
+		if err := tx.QueryRowContext(c, "pragma foreign_keys").Scan(&enabled); err != nil {
+			return err
+		}
+		if !enabled {
+			return ErrForeignKeysDisabled
+		}
+		return fn(tx)
+	})
+	if err != nil {
+		restore()
+		return err
+	}
+
+	for _, fp := range moved {
+		if err := os.Remove(fp + deletingSuffix); err != nil {
+			slog.Warn("Unable to remove deleted file", "file", fp, "error", err)
+		}
+	}
+	return nil
+}
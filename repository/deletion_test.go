@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// addTestFile uploads a file with content on disk and a metadata file for wheels
+func addTestFile(t *testing.T, repo *Repository, dir, project, version, filename, fileType string) string {
+	fp := filepath.Join(dir, project, filename)
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		t.Fatalf("Error creating project directory: %v", err)
+	}
+	if err := os.WriteFile(fp, []byte(filename), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	if fileType == "bdist_wheel" {
+		if err := os.WriteFile(fp+metadataSuffix, []byte("Name: "+project), 0644); err != nil {
+			t.Fatalf("Error writing metadata file: %v", err)
+		}
+	}
+	err := repo.CreateProjectVersion(&ProjectVersionInsert{
+		ProjectName:  project,
+		Version:      version,
+		Filename:     filename,
+		SHA256Digest: filename,
+		FilePath:     fp,
+		FileType:     fileType,
+		Metadata:     []*KeyVal{{Key: "summary", Val: "A test"}},
+	}, context.Background())
+	if err != nil {
+		t.Fatalf("CreateProjectVersion failed: %v", err)
+	}
+	return fp
+}
+
+// TestDeleteFileAndRelease tests that deleted files and releases are removed from the
+// database and from disk, and that deletions are journaled
+func TestDeleteFileAndRelease(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	dir := t.TempDir()
+	ctx := WithActor(context.Background(), "tester")
+	whl := addTestFile(t, repo, dir, "gone", "1.0", "gone-1.0-py3-none-any.whl", "bdist_wheel")
+	sdist := addTestFile(t, repo, dir, "gone", "1.0", "gone-1.0.tar.gz", "sdist")
+	kept := addTestFile(t, repo, dir, "gone", "2.0", "gone-2.0.tar.gz", "sdist")
+
+	before, _ := repo.lastSerial(ctx)
+	if err := repo.DeleteFile("gone", "gone-1.0-py3-none-any.whl", ctx); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	for _, fp := range []string{whl, whl + metadataSuffix, whl + deletingSuffix} {
+		if _, err := os.Stat(fp); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected %s to be removed, got %v", fp, err)
+		}
+	}
+	if _, err := repo.GetProjectFile("gone", "gone-1.0-py3-none-any.whl", ctx); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+	if used, _ := repo.FileNameUsed("gone-1.0-py3-none-any.whl", ctx); !used {
+		t.Errorf("Expected the name of the deleted file to stay used")
+	}
+	if err := repo.DeleteFile("gone", "gone-1.0-py3-none-any.whl", ctx); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound for a deleted file, got %v", err)
+	}
+
+	if err := repo.DeleteRelease("gone", "1.0", ctx); err != nil {
+		t.Fatalf("DeleteRelease failed: %v", err)
+	}
+	if _, err := os.Stat(sdist); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected %s to be removed, got %v", sdist, err)
+	}
+	if _, err := repo.GetRelease("gone", "1.0", ctx); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("Expected ErrReleaseNotFound, got %v", err)
+	}
+	var fields int
+	if err := repo.DB.QueryRow("select count(*) from release_metadata_fields").Scan(&fields); err != nil {
+		t.Fatalf("Error counting metadata fields: %v", err)
+	}
+	if fields != 1 {
+		t.Errorf("Expected the metadata of the deleted release to cascade, got %d fields", fields)
+	}
+
+	pf, err := repo.GetProjectFiles("gone", ctx)
+	if err != nil {
+		t.Fatalf("GetProjectFiles failed: %v", err)
+	}
+	if len(pf.Files) != 1 || pf.Files[0].FilePath != kept {
+		t.Errorf("Expected only %s to be left, got %+v", kept, pf.Files)
+	}
+	if pf.LastSerial != before+2 {
+		t.Errorf("Expected the serial to increase to %d, got %d", before+2, pf.LastSerial)
+	}
+
+	entries, err := repo.GetJournal("gone", 2, ctx)
+	if err != nil {
+		t.Fatalf("GetJournal failed: %v", err)
+	}
+	if entries[0].Action != "remove release" || entries[0].Version != "1.0" || entries[0].Actor != "tester" {
+		t.Errorf("Unexpected journal entry %+v", entries[0])
+	}
+	if entries[1].Action != "remove bdist_wheel file" || entries[1].Filename != "gone-1.0-py3-none-any.whl" {
+		t.Errorf("Unexpected journal entry %+v", entries[1])
+	}
+}
+
+// TestPurgeProject tests that purged projects are removed entirely, from every directory
+// their files were stored in, and that only projects deleted longer than the retention
+// ago are purged when expired
+func TestPurgeProject(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	repo.DeletionRetention = 24 * time.Hour
+	dir := t.TempDir()
+	ctx := context.Background()
+	fp := addTestFile(t, repo, dir, "old", "1.0", "old-1.0.tar.gz", "sdist")
+	// Older releases may be stored elsewhere, like the legacy paths of migrated files
+	legacy := addTestFile(t, repo, filepath.Join(dir, "legacy"), "old", "0.9", "old-0.9-py3-none-any.whl", "bdist_wheel")
+	addTestFile(t, repo, dir, "recent", "1.0", "recent-1.0.tar.gz", "sdist")
+
+	if err := repo.DeleteProject("old", "", ctx); err != nil {
+		t.Fatalf("DeleteProject failed: %v", err)
+	}
+	if err := repo.DeleteProject("recent", "", ctx); err != nil {
+		t.Fatalf("DeleteProject failed: %v", err)
+	}
+	_, err := repo.DB.Exec(
+		"update projects set status_changed_at = ? where normalized_name = 'old'",
+		time.Now().Add(-48*time.Hour).UTC().Format(SQLiteTimeFormat),
+	)
+	if err != nil {
+		t.Fatalf("Error backdating deletion: %v", err)
+	}
+
+	purged, err := repo.PurgeExpiredProjects(ctx)
+	if err != nil {
+		t.Fatalf("PurgeExpiredProjects failed: %v", err)
+	}
+	if len(purged) != 1 || purged[0] != "old" {
+		t.Errorf("Expected only old to be purged, got %v", purged)
+	}
+	if _, err := repo.GetProject("old", ctx); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("Expected ErrProjectNotFound, got %v", err)
+	}
+	for _, dir := range []string{filepath.Dir(fp), filepath.Dir(legacy)} {
+		if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the project directory %s to be removed, got %v", dir, err)
+		}
+	}
+	if _, err := repo.GetProject("recent", ctx); err != nil {
+		t.Errorf("Expected recent to be kept, got %v", err)
+	}
+
+	entries, err := repo.GetJournal("old", 1, ctx)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("GetJournal failed: %v", err)
+	}
+	if entries[0].Action != "remove project" || entries[0].Actor != "retention" {
+		t.Errorf("Unexpected journal entry %+v", entries[0])
+	}
+}
+
+// TestDeleteRequiresForeignKeys tests that deletions are refused when foreign keys are
+// not enforced, as dependent rows would be left behind
+func TestDeleteRequiresForeignKeys(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	repo.DB.SetMaxOpenConns(1)
+	dir := t.TempDir()
+	ctx := context.Background()
+	fp := addTestFile(t, repo, dir, "kept", "1.0", "kept-1.0.tar.gz", "sdist")
+
+	if _, err := repo.DB.Exec("pragma foreign_keys = off"); err != nil {
+		t.Fatalf("Error disabling foreign keys: %v", err)
+	}
+	if err := repo.DeleteRelease("kept", "1.0", ctx); !errors.Is(err, ErrForeignKeysDisabled) {
+		t.Errorf("Expected ErrForeignKeysDisabled, got %v", err)
+	}
+	if _, err := os.Stat(fp); err != nil {
+		t.Errorf("Expected the file to be restored, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go-pip-server/pep440"
+	"slices"
+)
+
+// fileColumns are the columns selected to build a ProjectFile, from release_files
+// aliased as f joined with releases aliased as rl.
+const fileColumns = `f.id, f.release_id, rl.version, f.filename, f.filepath, f.file_type, f.python_tag,
+    f.requires_python, f.size, f.sha256_digest, coalesce(f.md5_digest, ''),
+    coalesce(f.blake2_256_digest, ''), coalesce(f.metadata_digest, ''), f.upload_time,
+    (f.yanked or rl.yanked),
+    case when f.yanked and f.yanked_reason != '' then f.yanked_reason when rl.yanked then rl.yanked_reason else '' end`
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanFile scans a row selected with fileColumns
+func scanFile(row scanner) (*ProjectFile, error) {
+	var f ProjectFile
+	err := row.Scan(
+		&f.ID,
+		&f.ReleaseID,
+		&f.Version,
+		&f.Filename,
+		&f.FilePath,
+		&f.FileType,
+		&f.PythonTag,
+		&f.RequiresPython,
+		&f.Size,
+		&f.SHA256Digest,
+		&f.MD5Digest,
+		&f.Blake2b256Digest,
+		&f.MetadataDigest,
+		&f.UploadTime,
+		&f.Yanked,
+		&f.YankedReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// GetProjectFiles retrieves a project along with all of its uploaded distribution files,
+// ordered by version following PEP 440, then by upload.
+func (r *Repository) GetProjectFiles(n string, c context.Context) (*ProjectFiles, error) {
+	proj, err := r.GetProject(n, c)
+	if err != nil {
+		return nil, err
+	}
+
+	qry := `select ` + fileColumns + `
+            from release_files as f
+            join releases as rl on f.release_id = rl.id
+            where rl.project_id = ?
+            order by f.id`
+	rows, err := r.DB.QueryContext(c, qry, proj.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make([]*ProjectFile, 0, 16)
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(files, func(a, b *ProjectFile) int {
+		return pep440.Compare(a.Version, b.Version)
+	})
+	return &ProjectFiles{
+		Project:    proj,
+		LastSerial: proj.LastSerial,
+		Files:      files,
+	}, nil
+}
+
+// GetProjectFile retrieves a single distribution file of a project by its file name.
+// Returns ErrFileNotFound if the project has no such file.
+func (r *Repository) GetProjectFile(n, filename string, c context.Context) (*ProjectFile, error) {
+	qry := `select ` + fileColumns + `
+            from release_files as f
+            join releases as rl on f.release_id = rl.id
+            join projects as p on rl.project_id = p.id
+            where p.index_id = ? and p.normalized_name = ? and f.filename = ?`
+	f, err := scanFile(r.DB.QueryRowContext(c, qry, IndexID(c), NormalizeName(n), filename))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFileNotFound
+	}
+	return f, err
+}
+
+// GetWheelsWithoutMetadata retrieves the wheels of all indexes whose core metadata file
+// was never extracted, which were uploaded before metadata files were stored. Wheels
+// recorded with an empty metadata digest are left out.
+func (r *Repository) GetWheelsWithoutMetadata(c context.Context) ([]*ProjectFile, error) {
+	rows, err := r.DB.QueryContext(
+		c,
+		`select `+fileColumns+`
+         from release_files as f
+         join releases as rl on f.release_id = rl.id
+         where f.metadata_digest is null and f.filename like '%.whl'
+         order by f.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make([]*ProjectFile, 0, 16)
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// SetFileMetadataDigest records the digest of the core metadata file extracted for a
+// distribution file, used when backfilling metadata of files uploaded before extraction.
+// An empty digest records that the file has no metadata file.
+func (r *Repository) SetFileMetadataDigest(fileId int64, digest string, c context.Context) error {
+	_, err := r.DB.ExecContext(
+		c,
+		"update release_files set metadata_digest = ?, updated_at = current_timestamp where id = ?",
+		digest,
+		fileId,
+	)
+	return err
+}
+
+// FindFile retrieves a distribution file by its name, in any project of the index. File
+// names are unique within an index. Returns ErrFileNotFound if there is no such file.
+func (r *Repository) FindFile(filename string, c context.Context) (*ProjectFile, error) {
+	qry := `select ` + fileColumns + `
+            from release_files as f
+            join releases as rl on f.release_id = rl.id
+            where f.index_id = ? and f.filename = ?`
+	f, err := scanFile(r.DB.QueryRowContext(c, qry, IndexID(c), filename))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFileNotFound
+	}
+	return f, err
+}
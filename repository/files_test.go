@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestGetProjectFiles tests that the files of a project are listed with their metadata
+func TestGetProjectFiles(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	ctx := context.Background()
+	inserts := []*ProjectVersionInsert{
+		{
+			ProjectName:  "files-project",
+			Version:      "1.0.0",
+			Filename:     "files_project-1.0.0.tar.gz",
+			SHA256Digest: "abc123",
+			FilePath:     "/data/files-project/files_project-1.0.0.tar.gz",
+			FileType:     "sdist",
+		},
+		{
+			ProjectName:    "files-project",
+			Version:        "1.0.0",
+			Filename:       "files_project-1.0.0-py3-none-any.whl",
+			SHA256Digest:   "def456",
+			FilePath:       "/data/files-project/files_project-1.0.0-py3-none-any.whl",
+			FileType:       "bdist_wheel",
+			RequiresPython: ">=3.9",
+		},
+	}
+	for _, pvi := range inserts {
+		if err := repo.CreateProjectVersion(pvi, ctx); err != nil {
+			t.Fatalf("CreateProjectVersion failed: %v", err)
+		}
+	}
+
+	pf, err := repo.GetProjectFiles("files-project", ctx)
+	if err != nil {
+		t.Fatalf("GetProjectFiles failed: %v", err)
+	}
+	if len(pf.Files) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(pf.Files))
+	}
+	if pf.Files[0].Filename != "files_project-1.0.0.tar.gz" {
+		t.Errorf("Unexpected filename %s", pf.Files[0].Filename)
+	}
+	if pf.Files[0].RequiresPython != "" {
+		t.Errorf("Expected empty requires-python, got %s", pf.Files[0].RequiresPython)
+	}
+	if pf.Files[1].RequiresPython != ">=3.9" {
+		t.Errorf("Expected requires-python >=3.9, got %s", pf.Files[1].RequiresPython)
+	}
+	if pf.Files[1].UploadTime.IsZero() {
+		t.Errorf("Expected upload time to be set")
+	}
+	// The project was created, then its release, then its two files
+	journal, err := repo.GetJournal("files-project", 10, ctx)
+	if err != nil {
+		t.Fatalf("GetJournal failed: %v", err)
+	}
+	if len(journal) != 4 || pf.LastSerial != journal[0].ID {
+		t.Errorf("Expected last serial to be the latest of 4 journal entries, got %d (%d entries)", pf.LastSerial, len(journal))
+	}
+
+	// Unknown projects are reported as such
+	_, err = repo.GetProjectFiles("missing-project", ctx)
+	if !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("Expected ErrProjectNotFound, got %v", err)
+	}
+}
+
+// TestGetProjectFile tests looking up a single file by project and file name
+func TestGetProjectFile(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	ctx := context.Background()
+	pvi := &ProjectVersionInsert{
+		ProjectName:  "single-file",
+		Version:      "0.1.0",
+		Filename:     "single_file-0.1.0-py3-none-any.whl",
+		SHA256Digest: "abc123",
+		FilePath:     "/data/single-file/single_file-0.1.0-py3-none-any.whl",
+		FileType:     "bdist_wheel",
+	}
+	if err := repo.CreateProjectVersion(pvi, ctx); err != nil {
+		t.Fatalf("CreateProjectVersion failed: %v", err)
+	}
+
+	f, err := repo.GetProjectFile("single-file", "single_file-0.1.0-py3-none-any.whl", ctx)
+	if err != nil {
+		t.Fatalf("GetProjectFile failed: %v", err)
+	}
+	if f.FilePath != pvi.FilePath || f.SHA256Digest != pvi.SHA256Digest {
+		t.Errorf("Unexpected file returned: %+v", f)
+	}
+
+	_, err = repo.GetProjectFile("single-file", "single_file-0.2.0-py3-none-any.whl", ctx)
+	if !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound for unknown file, got %v", err)
+	}
+	_, err = repo.GetProjectFile("other-project", "single_file-0.1.0-py3-none-any.whl", ctx)
+	if !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound for unknown project, got %v", err)
+	}
+}
+
+// TestSetFileMetadataDigest tests recording the metadata digest of a file after upload
+func TestSetFileMetadataDigest(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	ctx := context.Background()
+	pvi := &ProjectVersionInsert{
+		ProjectName:  "meta-project",
+		Version:      "1.0",
+		Filename:     "meta_project-1.0-py3-none-any.whl",
+		SHA256Digest: "abc123",
+		FilePath:     "/data/meta-project/meta_project-1.0-py3-none-any.whl",
+		FileType:     "bdist_wheel",
+	}
+	if err := repo.CreateProjectVersion(pvi, ctx); err != nil {
+		t.Fatalf("CreateProjectVersion failed: %v", err)
+	}
+
+	f, err := repo.GetProjectFile("meta-project", "meta_project-1.0-py3-none-any.whl", ctx)
+	if err != nil {
+		t.Fatalf("GetProjectFile failed: %v", err)
+	}
+	if f.MetadataDigest != "" {
+		t.Errorf("Expected no metadata digest, got %s", f.MetadataDigest)
+	}
+	wheels, err := repo.GetWheelsWithoutMetadata(ctx)
+	if err != nil || len(wheels) != 1 || wheels[0].ID != f.ID {
+		t.Errorf("Expected the wheel without metadata, got %v (err: %v)", wheels, err)
+	}
+
+	// Wheels whose metadata could not be extracted are not retried
+	if err := repo.SetFileMetadataDigest(f.ID, "", ctx); err != nil {
+		t.Fatalf("SetFileMetadataDigest failed: %v", err)
+	}
+	if wheels, err := repo.GetWheelsWithoutMetadata(ctx); err != nil || len(wheels) != 0 {
+		t.Errorf("Expected the wheel to be recorded without metadata, got %v (err: %v)", wheels, err)
+	}
+
+	err = repo.SetFileMetadataDigest(f.ID, "fed987", ctx)
+	if err != nil {
+		t.Fatalf("SetFileMetadataDigest failed: %v", err)
+	}
+	f, err = repo.GetProjectFile("meta-project", "meta_project-1.0-py3-none-any.whl", ctx)
+	if err != nil {
+		t.Fatalf("GetProjectFile failed: %v", err)
+	}
+	if f.MetadataDigest != "fed987" {
+		t.Errorf("Expected metadata digest fed987, got %s", f.MetadataDigest)
+	}
+	if wheels, err := repo.GetWheelsWithoutMetadata(ctx); err != nil || len(wheels) != 0 {
+		t.Errorf("Expected no wheels without metadata, got %v (err: %v)", wheels, err)
+	}
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CreateGroup creates an empty group of users. Returns ErrGroupExists if the name is
+// taken, ignoring case.
+func (r *Repository) CreateGroup(name string, c context.Context) (*Group, error) {
+	if !usernamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidUsername, name)
+	}
+	_, err := r.DB.ExecContext(c, "insert into groups (name) values (?)", name)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%w: %s", ErrGroupExists, name)
+	} else if err != nil {
+		return nil, err
+	}
+	return r.GetGroup(name, c)
+}
+
+// GetGroup retrieves a group with its members by name, ignoring case. Returns
+// ErrGroupNotFound if there is no such group.
+func (r *Repository) GetGroup(name string, c context.Context) (*Group, error) {
+	g, err := scanGroup(r.DB.QueryRowContext(c, "select "+groupColumns+" from groups as g where g.name = ?", name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+	}
+	return g, err
+}
+
+// GetAllGroups retrieves all groups with their members, ordered by name
+func (r *Repository) GetAllGroups(c context.Context) ([]*Group, error) {
+	rows, err := r.DB.QueryContext(c, "select "+groupColumns+" from groups as g order by g.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]*Group, 0, 4)
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// DeleteGroup deletes a group, along with its roles on projects. Returns ErrGroupNotFound
+// if there is no such group.
+func (r *Repository) DeleteGroup(name string, c context.Context) error {
+	g, err := r.GetGroup(name, c)
+	if err != nil {
+		return err
+	}
+	// Groups which are the last owner of a project cannot be deleted
+	var owned int
+	err = r.DB.QueryRowContext(
+		c,
+		`select count(*) from project_roles as pr
+         where pr.group_id = ? and pr.role = ?
+           and not exists (
+               select 1 from project_roles as o
+               where o.project_id = pr.project_id and o.role = ? and o.id != pr.id
+           )`,
+		g.ID,
+		RoleOwner,
+		RoleOwner,
+	).Scan(&owned)
+	if err != nil {
+		return err
+	}
+	if owned > 0 {
+		return fmt.Errorf("%w: group %s owns %d project(s)", ErrLastOwner, g.Name, owned)
+	}
+	_, err = r.DB.ExecContext(c, "delete from groups where id = ?", g.ID)
+	return err
+}
+
+// AddGroupMember adds a user to a group, if not already a member
+func (r *Repository) AddGroupMember(group, username string, c context.Context) error {
+	g, u, err := r.groupAndUser(group, username, c)
+	if err != nil {
+		return err
+	}
+	_, err = r.DB.ExecContext(
+		c,
+		"insert into group_members (group_id, user_id) values (?, ?) on conflict do nothing",
+		g.ID,
+		u.ID,
+	)
+	return err
+}
+
+// RemoveGroupMember removes a user from a group. Returns ErrUserNotFound if the user is
+// not a member.
+func (r *Repository) RemoveGroupMember(group, username string, c context.Context) error {
+	g, u, err := r.groupAndUser(group, username, c)
+	if err != nil {
+		return err
+	}
+	res, err := r.DB.ExecContext(c, "delete from group_members where group_id = ? and user_id = ?", g.ID, u.ID)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("%w: %s is not a member of %s", ErrUserNotFound, u.Username, g.Name)
+	}
+	return nil
+}
+
+// groupAndUser looks up a group and a user
+func (r *Repository) groupAndUser(group, username string, c context.Context) (*Group, *User, error) {
+	g, err := r.GetGroup(group, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	u, err := r.GetUser(username, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, u, nil
+}
+
+// groupColumns lists the columns scanned by scanGroup, from groups as g
+const groupColumns = `g.id, g.name, g.created_at,
+    (select coalesce(group_concat(u.username, ' '), '')
+     from group_members as m join users as u on m.user_id = u.id where m.group_id = g.id)`
+
+// scanGroup scans a row selected with groupColumns
+func scanGroup(row scanner) (*Group, error) {
+	var g Group
+	var members string
+	if err := row.Scan(&g.ID, &g.Name, &g.CreatedAt, &members); err != nil {
+		return nil, err
+	}
+	g.Members = strings.Fields(members)
+	return &g, nil
+}
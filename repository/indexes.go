@@ -0,0 +1,430 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultIndexID is the ID of the default index, which existed before indexes did and is
+// served at the root of the server
+const DefaultIndexID = 1
+
+// DefaultIndexName is the name of the default index
+const DefaultIndexName = "default"
+
+// indexNamePattern restricts index names to what can be used as a URL path segment
+var indexNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ReservedIndexNames cannot be used as index names, as they are the first path segments
+// of the server's own routes
+var ReservedIndexNames = []string{"admin", "packages", "projects", "simple", "tokens", "upload", "upstream"}
+
+// UploadPolicy decides who can upload to an index
+type UploadPolicy string
+
+const (
+	// UploadsAuthenticated requires users or API tokens to upload
+	UploadsAuthenticated UploadPolicy = "authenticated"
+	// UploadsAnonymous accepts uploads without credentials
+	UploadsAnonymous UploadPolicy = "anonymous"
+	// UploadsDisabled rejects all uploads, the index is read-only
+	UploadsDisabled UploadPolicy = "disabled"
+)
+
+// ParseUploadPolicy checks that a string is a known upload policy
+func ParseUploadPolicy(s string) (UploadPolicy, error) {
+	switch p := UploadPolicy(s); p {
+	case UploadsAuthenticated, UploadsAnonymous, UploadsDisabled:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: unknown upload policy %q", ErrInvalidIndex, s)
+}
+
+// Resolution decides which layers of a virtual index serve a project
+type Resolution string
+
+const (
+	// ResolveFirstMatch serves a project from the first layer which has it
+	ResolveFirstMatch Resolution = "first-match"
+	// ResolveUnion serves the files of all layers which have a project, the files of
+	// earlier layers taking precedence
+	ResolveUnion Resolution = "union"
+)
+
+// ParseResolution checks that a string is a known resolution of virtual indexes
+func ParseResolution(s string) (Resolution, error) {
+	switch res := Resolution(s); res {
+	case ResolveFirstMatch, ResolveUnion:
+		return res, nil
+	}
+	return "", fmt.Errorf("%w: unknown resolution %q", ErrInvalidIndex, s)
+}
+
+// indexKey is the context key of the index which projects are looked up in
+type indexKey struct{}
+
+// WithIndex returns a context in which projects, files and the journal are those of an
+// index. Contexts without an index use the default index.
+func WithIndex(c context.Context, id int64) context.Context {
+	return context.WithValue(c, indexKey{}, id)
+}
+
+// IndexID returns the ID of the index of a context
+func IndexID(c context.Context) int64 {
+	if id, ok := c.Value(indexKey{}).(int64); ok {
+		return id
+	}
+	return DefaultIndexID
+}
+
+// indexColumns are the columns selected to build an Index, from the indexes table
+const indexColumns = `id, name, storage_prefix, private, coalesce(upload_policy, ''),
+    coalesce(upstream_url, ''), coalesce(resolution, ''),
+    coalesce((select t.name from indexes as t where t.id = indexes.upload_target_id), ''), created_at`
+
+// scanIndex scans a row selected with indexColumns
+func scanIndex(row scanner) (*Index, error) {
+	var idx Index
+	var private sql.NullBool
+	err := row.Scan(
+		&idx.ID,
+		&idx.Name,
+		&idx.StoragePrefix,
+		&private,
+		&idx.UploadPolicy,
+		&idx.UpstreamURL,
+		&idx.Resolution,
+		&idx.UploadTarget,
+		&idx.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if private.Valid {
+		idx.Private = &private.Bool
+	}
+	return &idx, nil
+}
+
+// GetIndex retrieves an index by name. Returns ErrIndexNotFound if there is no such index.
+func (r *Repository) GetIndex(name string, c context.Context) (*Index, error) {
+	idx, err := scanIndex(r.DB.QueryRowContext(c, "select "+indexColumns+" from indexes where name = ?", name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	} else if err != nil {
+		return nil, err
+	}
+	return idx, r.loadLayers(idx, c)
+}
+
+// GetIndexes retrieves all indexes, the default one first
+func (r *Repository) GetIndexes(c context.Context) ([]*Index, error) {
+	rows, err := r.DB.QueryContext(c, "select "+indexColumns+" from indexes order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make([]*Index, 0, 4)
+	for rows.Next() {
+		idx, err := scanIndex(rows)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, idx)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for _, idx := range indexes {
+		if err := r.loadLayers(idx, c); err != nil {
+			return nil, err
+		}
+	}
+	return indexes, nil
+}
+
+// loadLayers sets the names of the layers of a virtual index, in priority order
+func (r *Repository) loadLayers(idx *Index, c context.Context) error {
+	if !idx.Virtual() {
+		return nil
+	}
+	rows, err := r.DB.QueryContext(
+		c,
+		`select i.name from index_layers as l join indexes as i on l.layer_id = i.id
+         where l.virtual_id = ? order by l.position`,
+		idx.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	idx.Layers = make([]string, 0, 4)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		idx.Layers = append(idx.Layers, name)
+	}
+	return rows.Err()
+}
+
+// Virtual reports whether the index is a virtual index, which layers other indexes
+func (idx *Index) Virtual() bool {
+	return idx.Resolution != ""
+}
+
+// CreateIndex creates an index. Its files are stored under its storage prefix, which
+// defaults to its name. Indexes created with layers are virtual indexes, which resolve
+// projects with first-match unless another resolution is given. Returns ErrIndexExists if
+// the name is taken.
+func (r *Repository) CreateIndex(idx *Index, c context.Context) (*Index, error) {
+	if !indexNamePattern.MatchString(idx.Name) || slices.Contains(ReservedIndexNames, idx.Name) {
+		return nil, fmt.Errorf("%w: %q cannot be used as an index name", ErrInvalidIndex, idx.Name)
+	}
+	prefix := idx.StoragePrefix
+	if prefix == "" {
+		prefix = idx.Name
+	}
+	if prefix != path.Clean(prefix) || path.IsAbs(prefix) || strings.HasPrefix(prefix, "..") || strings.HasPrefix(prefix, "_") {
+		return nil, fmt.Errorf("%w: invalid storage prefix %q", ErrInvalidIndex, prefix)
+	}
+	if len(idx.Layers) > 0 && idx.Resolution == "" {
+		idx.Resolution = ResolveFirstMatch
+	}
+	if err := idx.validateSettings(); err != nil {
+		return nil, err
+	}
+
+	err := r.withTx(c, func(tx *sql.Tx) error {
+		// Indexes own the directory of their storage prefix, which is removed with them
+		rows, err := tx.QueryContext(c, "select name, storage_prefix from indexes where id != ?", DefaultIndexID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var other, otherPrefix string
+			if err := rows.Scan(&other, &otherPrefix); err != nil {
+				return err
+			}
+			if other != idx.Name && prefixesOverlap(prefix, otherPrefix) {
+				return fmt.Errorf("%w: storage prefix %q overlaps the one of %s", ErrInvalidIndex, prefix, other)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(
+			c,
+			`insert into indexes (name, storage_prefix, private, upload_policy, upstream_url, resolution)
+             values (?, ?, ?, nullif(?, ''), nullif(?, ''), nullif(?, ''))`,
+			idx.Name,
+			prefix,
+			idx.Private,
+			idx.UploadPolicy,
+			idx.UpstreamURL,
+			idx.Resolution,
+		)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: %s", ErrIndexExists, idx.Name)
+		} else if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		return setLayers(tx, id, idx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.GetIndex(idx.Name, c)
+}
+
+// UpdateIndex replaces the settings of an index: whether it is private, its upload policy
+// and its upstream index, or the layers, resolution and upload target of virtual indexes.
+// Its name and storage prefix cannot change, nor whether it is virtual.
+func (r *Repository) UpdateIndex(name string, settings *Index, c context.Context) (*Index, error) {
+	idx, err := r.GetIndex(name, c)
+	if err != nil {
+		return nil, err
+	}
+	if len(settings.Layers) > 0 && settings.Resolution == "" {
+		settings.Resolution = ResolveFirstMatch
+	}
+	if settings.Virtual() != idx.Virtual() {
+		return nil, fmt.Errorf("%w: %s cannot become or stop being a virtual index", ErrInvalidIndex, name)
+	}
+	if err := settings.validateSettings(); err != nil {
+		return nil, err
+	}
+
+	err = r.withTx(c, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(
+			c,
+			`update indexes set private = ?, upload_policy = nullif(?, ''), upstream_url = nullif(?, ''),
+                 resolution = nullif(?, ''), upload_target_id = null
+             where id = ?`,
+			settings.Private,
+			settings.UploadPolicy,
+			settings.UpstreamURL,
+			settings.Resolution,
+			idx.ID,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(c, "delete from index_layers where virtual_id = ?", idx.ID); err != nil {
+			return err
+		}
+		return setLayers(tx, idx.ID, settings, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.GetIndex(name, c)
+}
+
+// setLayers stores the layers and upload target of a virtual index, which must be
+// existing indexes other than virtual ones
+func setLayers(tx *sql.Tx, id int64, idx *Index, c context.Context) error {
+	layerId := func(name string) (int64, error) {
+		var layer int64
+		var resolution sql.NullString
+		err := tx.QueryRowContext(c, "select id, resolution from indexes where name = ?", name).Scan(&layer, &resolution)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: no index named %s", ErrInvalidIndex, name)
+		} else if err != nil {
+			return 0, err
+		}
+		// Virtual indexes include the index itself
+		if resolution.Valid {
+			return 0, fmt.Errorf("%w: %s is a virtual index", ErrInvalidIndex, name)
+		}
+		return layer, nil
+	}
+
+	for position, name := range idx.Layers {
+		layer, err := layerId(name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(
+			c,
+			"insert into index_layers (virtual_id, layer_id, position) values (?, ?, ?)",
+			id,
+			layer,
+			position,
+		)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: %s is layered twice", ErrInvalidIndex, name)
+		} else if err != nil {
+			return err
+		}
+	}
+	if idx.UploadTarget != "" {
+		// Uploads must be served back by the virtual index
+		if !slices.Contains(idx.Layers, idx.UploadTarget) {
+			return fmt.Errorf("%w: the upload target %s is not a layer", ErrInvalidIndex, idx.UploadTarget)
+		}
+		target, err := layerId(idx.UploadTarget)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(c, "update indexes set upload_target_id = ? where id = ?", target, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteIndex deletes an index which has no projects left, and returns it so that its
+// files can be removed. The default index cannot be deleted. Returns ErrIndexNotEmpty if
+// the index still has projects, and ErrIndexInUse if it is a layer or the upload target
+// of a virtual index.
+func (r *Repository) DeleteIndex(name string, c context.Context) (*Index, error) {
+	idx, err := r.GetIndex(name, c)
+	if err != nil {
+		return nil, err
+	}
+	if idx.ID == DefaultIndexID {
+		return nil, fmt.Errorf("%w: the default index cannot be deleted", ErrInvalidIndex)
+	}
+	err = r.withTx(c, func(tx *sql.Tx) error {
+		var projects int
+		err := tx.QueryRowContext(c, "select count(*) from projects where index_id = ?", idx.ID).Scan(&projects)
+		if err != nil {
+			return err
+		}
+		if projects > 0 {
+			return fmt.Errorf("%w: %s has %d projects", ErrIndexNotEmpty, name, projects)
+		}
+		var virtual string
+		err = tx.QueryRowContext(
+			c,
+			`select coalesce(min(name), '') from indexes
+             where upload_target_id = ?1 or id in (select virtual_id from index_layers where layer_id = ?1)`,
+			idx.ID,
+		).Scan(&virtual)
+		if err != nil {
+			return err
+		}
+		if virtual != "" {
+			return fmt.Errorf("%w: %s is used by %s", ErrIndexInUse, name, virtual)
+		}
+		_, err = tx.ExecContext(c, "delete from indexes where id = ?", idx.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// prefixesOverlap returns whether one storage prefix is the same as or contains another
+func prefixesOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// validateSettings checks the settings of an index which can be updated
+func (idx *Index) validateSettings() error {
+	if idx.Virtual() {
+		if _, err := ParseResolution(string(idx.Resolution)); err != nil {
+			return err
+		}
+		if len(idx.Layers) == 0 {
+			return fmt.Errorf("%w: virtual indexes need at least one layer", ErrInvalidIndex)
+		}
+		// Uploads go to the upload target, and upstream indexes are layered with an index
+		// of their own
+		if idx.UploadPolicy != "" || idx.UpstreamURL != "" {
+			return fmt.Errorf("%w: virtual indexes cannot have an upload policy or upstream URL", ErrInvalidIndex)
+		}
+	} else if idx.UploadTarget != "" {
+		return fmt.Errorf("%w: only virtual indexes have an upload target", ErrInvalidIndex)
+	}
+	if idx.UploadPolicy != "" {
+		if _, err := ParseUploadPolicy(string(idx.UploadPolicy)); err != nil {
+			return err
+		}
+	}
+	if idx.UpstreamURL != "" {
+		u, err := url.Parse(idx.UpstreamURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%w: the upstream URL must be an http(s) URL", ErrInvalidIndex)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestIndexes tests creating, updating and deleting indexes
+func TestIndexes(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+
+	private := true
+	idx, err := repo.CreateIndex(&Index{Name: "staging", Private: &private, UploadPolicy: UploadsDisabled}, ctx)
+	if err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	if idx.ID == DefaultIndexID || idx.StoragePrefix != "staging" || idx.Private == nil || !*idx.Private || idx.UploadPolicy != UploadsDisabled {
+		t.Errorf("Unexpected index %+v", idx)
+	}
+	if _, err := repo.CreateIndex(&Index{Name: "staging"}, ctx); !errors.Is(err, ErrIndexExists) {
+		t.Errorf("Expected ErrIndexExists, got %v", err)
+	}
+	for _, invalid := range []*Index{
+		{Name: "simple"},
+		{Name: "Dev"},
+		{Name: "-dev"},
+		{Name: "dev", StoragePrefix: "../dev"},
+		{Name: "dev", StoragePrefix: "_upstream"},
+		{Name: "dev", StoragePrefix: "staging"},
+		{Name: "dev", StoragePrefix: "staging/dev"},
+		{Name: "dev", UploadPolicy: "everyone"},
+		{Name: "dev", UpstreamURL: "file:///simple"},
+	} {
+		if _, err := repo.CreateIndex(invalid, ctx); !errors.Is(err, ErrInvalidIndex) {
+			t.Errorf("Expected ErrInvalidIndex for %+v, got %v", invalid, err)
+		}
+	}
+
+	idx, err = repo.UpdateIndex("staging", &Index{UpstreamURL: "https://pypi.org/simple/"}, ctx)
+	if err != nil {
+		t.Fatalf("UpdateIndex failed: %v", err)
+	}
+	if idx.Private != nil || idx.UploadPolicy != "" || idx.UpstreamURL != "https://pypi.org/simple/" {
+		t.Errorf("Expected the settings to be replaced, got %+v", idx)
+	}
+	if _, err := repo.UpdateIndex("missing", &Index{}, ctx); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("Expected ErrIndexNotFound, got %v", err)
+	}
+	indexes, err := repo.GetIndexes(ctx)
+	if err != nil || len(indexes) != 2 || indexes[0].Name != DefaultIndexName {
+		t.Errorf("Unexpected indexes %v (err: %v)", indexes, err)
+	}
+
+	// Indexes can only be deleted once empty
+	if _, err := repo.GetOrCreateProject("demo", WithIndex(ctx, idx.ID)); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+	if _, err := repo.DeleteIndex("staging", ctx); !errors.Is(err, ErrIndexNotEmpty) {
+		t.Errorf("Expected ErrIndexNotEmpty, got %v", err)
+	}
+	if err := repo.PurgeProject("demo", WithIndex(ctx, idx.ID)); err != nil {
+		t.Fatalf("PurgeProject failed: %v", err)
+	}
+	if _, err := repo.DeleteIndex("staging", ctx); err != nil {
+		t.Errorf("DeleteIndex failed: %v", err)
+	}
+	if _, err := repo.GetIndex("staging", ctx); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("Expected ErrIndexNotFound, got %v", err)
+	}
+	if _, err := repo.DeleteIndex(DefaultIndexName, ctx); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("Expected the default index not to be deletable, got %v", err)
+	}
+}
+
+// TestIndexScoping tests that projects, files, the journal and source policies of an
+// index are separate from those of the other indexes
+func TestIndexScoping(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	idx, err := repo.CreateIndex(&Index{Name: "dev"}, ctx)
+	if err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	devCtx := WithIndex(ctx, idx.ID)
+
+	// The same project and file names can be used in both indexes
+	dir := t.TempDir()
+	addTestFile(t, repo, dir, "demo", "1.0", "demo-1.0.tar.gz", "sdist")
+	err = repo.CreateProjectVersion(&ProjectVersionInsert{
+		ProjectName:  "Demo",
+		Version:      "1.0",
+		Filename:     "demo-1.0.tar.gz",
+		SHA256Digest: "other",
+		FilePath:     dir + "/dev/demo/demo-1.0.tar.gz",
+		FileType:     "sdist",
+	}, devCtx)
+	if err != nil {
+		t.Fatalf("CreateProjectVersion failed: %v", err)
+	}
+	if _, err := repo.GetOrCreateProject("dev-only", devCtx); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+
+	all, err := repo.GetAllProjects(ctx)
+	if err != nil || len(all.Projects) != 1 {
+		t.Errorf("Expected one project in the default index, got %v (err: %v)", all, err)
+	}
+	devAll, err := repo.GetAllProjects(devCtx)
+	if err != nil || len(devAll.Projects) != 2 || devAll.Projects[0].Name != "Demo" {
+		t.Errorf("Expected two projects in the dev index, got %v (err: %v)", devAll, err)
+	}
+	f, err := repo.FindFile("demo-1.0.tar.gz", devCtx)
+	if err != nil || f.SHA256Digest != "other" {
+		t.Errorf("Expected the file of the dev index, got %+v (err: %v)", f, err)
+	}
+	if _, err := repo.GetProject("dev-only", ctx); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("Expected ErrProjectNotFound in the default index, got %v", err)
+	}
+
+	journal, err := repo.GetJournal("", 100, ctx)
+	if err != nil || len(journal) != 3 {
+		t.Errorf("Expected 3 journal entries in the default index, got %d (err: %v)", len(journal), err)
+	}
+	if all.LastSerial != journal[0].ID || devAll.LastSerial <= all.LastSerial {
+		t.Errorf("Expected separate serials, got %d and %d", all.LastSerial, devAll.LastSerial)
+	}
+
+	if err := repo.SetSourcePolicy("requests", SourceLocal, devCtx); err != nil {
+		t.Fatalf("SetSourcePolicy failed: %v", err)
+	}
+	if policy, _ := repo.GetSourcePolicy("requests", ctx); policy != SourceUpstream {
+		t.Errorf("Expected the policy of the dev index not to apply, got %s", policy)
+	}
+	if policy, _ := repo.GetSourcePolicy("dev-only", ctx); policy != SourceUpstream {
+		t.Errorf("Expected dev-only to be unknown in the default index, got %s", policy)
+	}
+}
+
+// TestVirtualIndexes tests creating, updating and deleting virtual indexes and their layers
+func TestVirtualIndexes(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{"team-a", "shared"} {
+		if _, err := repo.CreateIndex(&Index{Name: name}, ctx); err != nil {
+			t.Fatalf("CreateIndex failed: %v", err)
+		}
+	}
+
+	idx, err := repo.CreateIndex(&Index{Name: "all", Layers: []string{"team-a", "shared", DefaultIndexName}, UploadTarget: "team-a"}, ctx)
+	if err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	if !idx.Virtual() || idx.Resolution != ResolveFirstMatch || idx.UploadTarget != "team-a" {
+		t.Errorf("Unexpected virtual index %+v", idx)
+	}
+	if len(idx.Layers) != 3 || idx.Layers[0] != "team-a" || idx.Layers[2] != DefaultIndexName {
+		t.Errorf("Expected the layers in priority order, got %v", idx.Layers)
+	}
+	for _, invalid := range []*Index{
+		{Name: "v", Layers: []string{"missing"}},
+		{Name: "v", Layers: []string{"all"}},
+		{Name: "v", Layers: []string{"shared", "shared"}},
+		{Name: "v", Layers: []string{"shared"}, Resolution: "last-match"},
+		{Name: "v", Layers: []string{"shared"}, UploadPolicy: UploadsAnonymous},
+		{Name: "v", Layers: []string{"shared"}, UploadTarget: "all"},
+		{Name: "v", Layers: []string{"shared"}, UploadTarget: "team-a"},
+		{Name: "v", Resolution: ResolveUnion},
+		{Name: "v", UploadTarget: "shared"},
+	} {
+		if _, err := repo.CreateIndex(invalid, ctx); !errors.Is(err, ErrInvalidIndex) {
+			t.Errorf("Expected ErrInvalidIndex for %+v, got %v", invalid, err)
+		}
+	}
+	if _, err := repo.GetIndex("v", ctx); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("Expected invalid virtual indexes not to be created, got %v", err)
+	}
+
+	idx, err = repo.UpdateIndex("all", &Index{Layers: []string{"shared"}, Resolution: ResolveUnion}, ctx)
+	if err != nil {
+		t.Fatalf("UpdateIndex failed: %v", err)
+	}
+	if len(idx.Layers) != 1 || idx.Resolution != ResolveUnion || idx.UploadTarget != "" {
+		t.Errorf("Expected the layers to be replaced, got %+v", idx)
+	}
+	if _, err := repo.UpdateIndex("all", &Index{}, ctx); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("Expected a virtual index to stay virtual, got %v", err)
+	}
+	if _, err := repo.UpdateIndex("shared", &Index{Layers: []string{"team-a"}}, ctx); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("Expected a regular index to stay regular, got %v", err)
+	}
+
+	// Layers can only be deleted once no virtual index uses them
+	if _, err := repo.DeleteIndex("shared", ctx); !errors.Is(err, ErrIndexInUse) {
+		t.Errorf("Expected ErrIndexInUse, got %v", err)
+	}
+	if _, err := repo.DeleteIndex("all", ctx); err != nil {
+		t.Fatalf("DeleteIndex failed: %v", err)
+	}
+	if _, err := repo.DeleteIndex("shared", ctx); err != nil {
+		t.Errorf("DeleteIndex failed: %v", err)
+	}
+}
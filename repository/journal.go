@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// actorKey is the context key of the actor recorded in journal entries
+type actorKey struct{}
+
+// WithActor returns a context in which changes are recorded in the journal as made by
+// the given actor, such as a user name
+func WithActor(c context.Context, actor string) context.Context {
+	return context.WithValue(c, actorKey{}, actor)
+}
+
+// userKey is the context key of the authenticated user making changes
+type userKey struct{}
+
+// WithUser returns a context in which changes are made by an authenticated user: new
+// projects are owned by the user, uploads are checked against the user's roles, and
+// changes are recorded in the journal as made by the user.
+func WithUser(c context.Context, u *User) context.Context {
+	return WithActor(context.WithValue(c, userKey{}, u), u.Username)
+}
+
+// UserFrom returns the authenticated user of a context, or nil if none was set
+func UserFrom(c context.Context) *User {
+	u, _ := c.Value(userKey{}).(*User)
+	return u
+}
+
+// publisherKey is the context key of uploads by trusted publishers
+type publisherKey struct{}
+
+// WithPublisher returns a context in which uploads are made by a trusted publisher, who
+// needs no role on the projects it was registered for
+func WithPublisher(c context.Context) context.Context {
+	return context.WithValue(c, publisherKey{}, true)
+}
+
+// publisherFrom reports whether uploads of a context are made by a trusted publisher
+func publisherFrom(c context.Context) bool {
+	publisher, _ := c.Value(publisherKey{}).(bool)
+	return publisher
+}
+
+// actorFrom returns the actor of a context, or an empty string if none was set
+func actorFrom(c context.Context) string {
+	actor, _ := c.Value(actorKey{}).(string)
+	return actor
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(c context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// addJournalEntry records a change to a project in the journal. The version and file
+// name may be empty.
+func addJournalEntry(db execer, project, version, filename, action string, c context.Context) error {
+	_, err := db.ExecContext(
+		c,
+		`insert into journal_entries (index_id, project_name, version, filename, action, actor, created_at)
+         values (?, ?, nullif(?, ''), nullif(?, ''), ?, ?, ?)`,
+		IndexID(c),
+		NormalizeName(project),
+		version,
+		filename,
+		action,
+		actorFrom(c),
+		time.Now().UTC().Format(SQLiteTimeFormat),
+	)
+	return err
+}
+
+// withReason appends the reason given for a change to its journal action
+func withReason(action, reason string) string {
+	if reason == "" {
+		return action
+	}
+	return action + ": " + reason
+}
+
+// GetJournal retrieves the latest journal entries, newest first, of a project or of all
+// projects if the name is empty. Entries of deleted projects are kept.
+func (r *Repository) GetJournal(n string, limit int, c context.Context) ([]*JournalEntry, error) {
+	rows, err := r.DB.QueryContext(
+		c,
+		`select id, project_name, coalesce(version, ''), coalesce(filename, ''), action, actor, created_at
+         from journal_entries
+         where index_id = ? and (? = '' or project_name = ?)
+         order by id desc
+         limit ?`,
+		IndexID(c),
+		n,
+		NormalizeName(n),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*JournalEntry, 0, limit)
+	for rows.Next() {
+		var e JournalEntry
+		err := rows.Scan(&e.ID, &e.ProjectName, &e.Version, &e.Filename, &e.Action, &e.Actor, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// lastSerial returns the ID of the latest journal entry of the index, or 0 if its journal
+// is empty
+func (r *Repository) lastSerial(c context.Context) (int64, error) {
+	var serial int64
+	err := r.DB.QueryRowContext(
+		c,
+		"select coalesce(max(id), 0) from journal_entries where index_id = ?",
+		IndexID(c),
+	).Scan(&serial)
+	return serial, err
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFileName matches migration files, named with their number and a description,
+// e.g. 0002-add-users.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)[-_]([\w-]+)\.sql$`)
+
+// Migration is a numbered SQL file from the queries directory which changes the schema
+type Migration struct {
+	Version int64
+	Name    string
+	Path    string
+}
+
+// MigrationState is a migration along with the time it was applied, nil if it is pending
+type MigrationState struct {
+	*Migration
+	AppliedAt *time.Time
+}
+
+// loadMigrations lists the migration files in the queries directory, ordered by version
+func (r *Repository) loadMigrations() ([]*Migration, error) {
+	entries, err := os.ReadDir(r.QueriesPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading queries directory: %w", err)
+	}
+
+	migrations := make([]*Migration, 0, len(entries))
+	seen := make(map[int64]string)
+	for _, e := range entries {
+		m := migrationFileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		v, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration number in %s: %w", e.Name(), err)
+		}
+		if other, ok := seen[v]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same number", other, e.Name())
+		}
+		seen[v] = e.Name()
+		migrations = append(migrations, &Migration{
+			Version: v,
+			Name:    m[2],
+			Path:    filepath.Join(r.QueriesPath, e.Name()),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// appliedMigrations returns the times at which migrations were applied, by version
+func (r *Repository) appliedMigrations(c context.Context) (map[int64]time.Time, error) {
+	_, err := r.DB.ExecContext(
+		c,
+		`create table if not exists schema_migrations (
+             version integer primary key,
+             name nvarchar(256) not null,
+             applied_at datetime default current_timestamp
+         )`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.DB.QueryContext(c, "select version, applied_at from schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var v int64
+		var t time.Time
+		if err := rows.Scan(&v, &t); err != nil {
+			return nil, err
+		}
+		applied[v] = t
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists all known migrations along with whether they have been applied.
+// Returns an error if the database has migrations applied that are unknown to this binary.
+func (r *Repository) MigrationStatus(c context.Context) ([]*MigrationState, error) {
+	migrations, err := r.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := r.appliedMigrations(c)
+	if err != nil {
+		return nil, fmt.Errorf("error reading applied migrations: %w", err)
+	}
+
+	states := make([]*MigrationState, 0, len(migrations))
+	known := make(map[int64]bool)
+	for _, m := range migrations {
+		known[m.Version] = true
+		st := &MigrationState{Migration: m}
+		if t, ok := applied[m.Version]; ok {
+			st.AppliedAt = &t
+		}
+		states = append(states, st)
+	}
+	for v := range applied {
+		if !known[v] {
+			return states, fmt.Errorf("%w: migration %d is not known", ErrSchemaAhead, v)
+		}
+	}
+	return states, nil
+}
+
+// MigrateUp applies all pending migrations in order, each one in its own transaction.
+// Returns the number of migrations applied.
+func (r *Repository) MigrateUp(c context.Context) (int, error) {
+	bootstrap, err := r.needsLegacyBootstrap(c)
+	if err != nil {
+		return 0, err
+	}
+	states, err := r.MigrationStatus(c)
+	if err != nil {
+		return 0, err
+	}
+	if bootstrap {
+		// Databases created before migrations were tracked are brought up to the
+		// initial schema, which is then recorded as applied
+		err = r.upgradeSchema(c)
+		if err != nil {
+			return 0, fmt.Errorf("error upgrading existing schema: %w", err)
+		}
+	}
+
+	count := 0
+	for _, st := range states {
+		if st.AppliedAt != nil {
+			continue
+		}
+		err = r.applyMigration(st.Migration, c)
+		if err != nil {
+			return count, fmt.Errorf("error applying migration %d (%s): %w", st.Version, st.Name, err)
+		}
+		slog.Info("Applied database migration", "version", st.Version, "name", st.Name)
+		count++
+
+		if bootstrap {
+			err = r.migrateLegacyData(c)
+			if err != nil {
+				return count, fmt.Errorf("error migrating existing data: %w", err)
+			}
+			bootstrap = false
+		}
+	}
+	return count, nil
+}
+
+// needsLegacyBootstrap checks if the database was created before migrations were tracked
+func (r *Repository) needsLegacyBootstrap(c context.Context) (bool, error) {
+	hasMigrations, err := r.tableExists("schema_migrations", c)
+	if err != nil || hasMigrations {
+		return false, err
+	}
+	return r.tableExists("projects", c)
+}
+
+// applyMigration runs the statements of a migration file and records it, in a single transaction
+func (r *Repository) applyMigration(m *Migration, c context.Context) error {
+	content, err := os.ReadFile(m.Path)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.DB.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(string(content)) {
+		if _, err := tx.ExecContext(c, stmt); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(
+		c,
+		"insert into schema_migrations (version, name) values (?, ?)",
+		m.Version,
+		m.Name,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// splitStatements splits the content of a migration file into its statements
+func splitStatements(content string) []string {
+	parts := strings.Split(content, StatementSeparator)
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// getTestRepositoryWithMigrations sets up an in-memory database with a copy of the
+// queries directory plus extra migration files
+func getTestRepositoryWithMigrations(t *testing.T, extra map[string]string) *Repository {
+	dir := t.TempDir()
+	initial, err := os.ReadFile(filepath.Join("..", "assets", "queries", RequiredQueryFiles))
+	if err != nil {
+		t.Fatalf("Error reading initial migration: %v", err)
+	}
+	files := map[string]string{RequiredQueryFiles: string(initial)}
+	for name, content := range extra {
+		files[name] = content
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing migration %s: %v", name, err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", DataSourceName(":memory:"))
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	repo, err := NewRepository(db, dir)
+	if err != nil {
+		t.Fatalf("NewRepository failed: %v", err)
+	}
+	return repo
+}
+
+// TestMigrateUp tests that migrations are applied in order and only once
+func TestMigrateUp(t *testing.T) {
+	repo := getTestRepositoryWithMigrations(t, map[string]string{
+		"0003-add-index.sql": "create index idx_notes_text on notes (text);",
+		"0002-add-notes.sql": `create table notes (id integer primary key, text nvarchar(64));
+-- [SEP] --
+insert into notes (text) values ('first');`,
+		"README.md": "not a migration",
+	})
+	ctx := context.Background()
+
+	applied, err := repo.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+	if applied != 3 {
+		t.Errorf("Expected 3 migrations to be applied, got %d", applied)
+	}
+
+	states, err := repo.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	for i, st := range states {
+		if st.Version != int64(i+1) {
+			t.Errorf("Expected migration %d at position %d, got %d", i+1, i, st.Version)
+		}
+		if st.AppliedAt == nil {
+			t.Errorf("Expected migration %d to be applied", st.Version)
+		}
+	}
+
+	applied, err = repo.MigrateUp(ctx)
+	if err != nil {
+		t.Fatalf("Second MigrateUp failed: %v", err)
+	}
+	if applied != 0 {
+		t.Errorf("Expected no migrations to be applied again, got %d", applied)
+	}
+}
+
+// TestMigrateUpRollback tests that a failing migration leaves no partial changes
+func TestMigrateUpRollback(t *testing.T) {
+	repo := getTestRepositoryWithMigrations(t, map[string]string{
+		"0002-broken.sql": `create table partial (id integer primary key);
+-- [SEP] --
+insert into missing_table (id) values (1);`,
+	})
+	ctx := context.Background()
+
+	applied, err := repo.MigrateUp(ctx)
+	if err == nil {
+		t.Fatalf("Expected MigrateUp to fail")
+	}
+	if applied != 1 {
+		t.Errorf("Expected only the initial migration to be applied, got %d", applied)
+	}
+
+	exists, err := repo.tableExists("partial", ctx)
+	if err != nil || exists {
+		t.Errorf("Expected the failed migration to be rolled back (err: %v)", err)
+	}
+	states, err := repo.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	if states[1].AppliedAt != nil {
+		t.Errorf("Expected the failed migration to be pending")
+	}
+}
+
+// TestSetUpDBSchemaAhead tests that the database is refused if it has migrations
+// applied which are not known
+func TestSetUpDBSchemaAhead(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	_, err = repo.DB.Exec("insert into schema_migrations (version, name) values (9999, 'from-the-future')")
+	if err != nil {
+		t.Fatalf("Error recording migration: %v", err)
+	}
+	err = repo.SetUpDB()
+	if !errors.Is(err, ErrSchemaAhead) {
+		t.Errorf("Expected ErrSchemaAhead, got %v", err)
+	}
+}
+
+// TestSplitStatements tests splitting migration files on the statement separator
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements("\ncreate table a (id integer);\n\n-- [SEP] --\n\n-- [SEP] --\ncreate table b (id integer);\n")
+	if len(stmts) != 2 {
+		t.Fatalf("Expected 2 statements, got %d: %q", len(stmts), stmts)
+	}
+	if stmts[0] != "create table a (id integer);" || stmts[1] != "create table b (id integer);" {
+		t.Errorf("Unexpected statements: %q", stmts)
+	}
+}
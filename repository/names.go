@@ -0,0 +1,10 @@
+package repository
+
+import "go-pip-server/distribution"
+
+// NormalizeName normalizes a project name following PEP 503, so that names differing
+// only in case or in the use of '-', '_' and '.' refer to the same project. Distribution
+// file names are checked with the same normalization.
+func NormalizeName(n string) string {
+	return distribution.NormalizeName(n)
+}
@@ -0,0 +1,20 @@
+package repository
+
+import "testing"
+
+// TestNormalizeName tests PEP 503 project name normalization
+func TestNormalizeName(t *testing.T) {
+	cases := map[string]string{
+		"my-package":     "my-package",
+		"My_Package":     "my-package",
+		"my.package":     "my-package",
+		"MY--package__2": "my-package-2",
+		"a._-b":          "a-b",
+		"Django":         "django",
+	}
+	for in, expected := range cases {
+		if got := NormalizeName(in); got != expected {
+			t.Errorf("NormalizeName(%q): expected %q, got %q", in, expected, got)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ProjectStatus is the lifecycle state of a project
+type ProjectStatus string
+
+const (
+	// ProjectActive projects are listed, served and accept uploads
+	ProjectActive ProjectStatus = "active"
+	// ProjectArchived projects are listed and served but read-only (PEP 792)
+	ProjectArchived ProjectStatus = "archived"
+	// ProjectQuarantined projects are hidden from the index and their files are not served (PEP 792)
+	ProjectQuarantined ProjectStatus = "quarantined"
+	// ProjectDeleted projects are soft-deleted, they can be restored during the retention window
+	ProjectDeleted ProjectStatus = "deleted"
+)
+
+// projectTransitions lists the statuses each status can move to
+var projectTransitions = map[ProjectStatus][]ProjectStatus{
+	ProjectActive:      {ProjectArchived, ProjectQuarantined, ProjectDeleted},
+	ProjectArchived:    {ProjectActive, ProjectQuarantined, ProjectDeleted},
+	ProjectQuarantined: {ProjectActive, ProjectArchived, ProjectDeleted},
+	ProjectDeleted:     {ProjectActive},
+}
+
+// ownerTransitions lists the statuses project owners can move their projects to. Only
+// admins can quarantine projects, lift a quarantine or restore a deleted project.
+var ownerTransitions = map[ProjectStatus][]ProjectStatus{
+	ProjectActive:   {ProjectArchived, ProjectDeleted},
+	ProjectArchived: {ProjectActive, ProjectDeleted},
+}
+
+// ParseProjectStatus checks that a string is a known project status
+func ParseProjectStatus(s string) (ProjectStatus, error) {
+	status := ProjectStatus(s)
+	if _, ok := projectTransitions[status]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrInvalidStatus, s)
+	}
+	return status, nil
+}
+
+// Listed reports whether projects with the status appear in the index
+func (s ProjectStatus) Listed() bool {
+	return s == ProjectActive || s == ProjectArchived
+}
+
+// SetProjectStatus moves a project to another status, with an optional reason which is
+// shown to clients for archived and quarantined projects. Deleted projects can only be
+// restored to active, within the DeletionRetention window. Returns ErrStatusTransition
+// if the project cannot move to the status, and ErrRetentionExpired if it was deleted
+// too long ago.
+func (r *Repository) SetProjectStatus(n string, status ProjectStatus, reason string, c context.Context) error {
+	return r.setProjectStatus(n, status, reason, projectTransitions, c)
+}
+
+// SetOwnedProjectStatus moves a project to another status on behalf of its owners, who
+// can only archive, unarchive and delete it. Returns ErrPermissionDenied for the other
+// transitions.
+func (r *Repository) SetOwnedProjectStatus(n string, status ProjectStatus, reason string, c context.Context) error {
+	return r.setProjectStatus(n, status, reason, ownerTransitions, c)
+}
+
+// setProjectStatus moves a project to another status allowed by transitions
+func (r *Repository) setProjectStatus(n string, status ProjectStatus, reason string, transitions map[ProjectStatus][]ProjectStatus, c context.Context) error {
+	if _, ok := projectTransitions[status]; !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+	p, err := r.GetProject(n, c)
+	if err != nil {
+		return err
+	}
+	if p.Status != status && !slices.Contains(projectTransitions[p.Status], status) {
+		return fmt.Errorf("%w: from %s to %s", ErrStatusTransition, p.Status, status)
+	}
+	if allowed, ok := transitions[p.Status]; !ok || p.Status != status && !slices.Contains(allowed, status) {
+		return fmt.Errorf("%w: only admins can move projects from %s to %s", ErrPermissionDenied, p.Status, status)
+	}
+	if p.Status == ProjectDeleted && status == ProjectActive && r.retentionExpired(p, time.Now()) {
+		return ErrRetentionExpired
+	}
+
+	return r.withTx(c, func(tx *sql.Tx) error {
+		// The status is checked again in the update, in case it changed concurrently. The
+		// time of the change is kept if only the reason changes.
+		res, err := tx.ExecContext(
+			c,
+			`update projects
+             set status = ?, status_reason = ?, updated_at = current_timestamp,
+                 status_changed_at = case when status = ? then status_changed_at else ? end
+             where id = ? and status = ?`,
+			status,
+			reason,
+			status,
+			time.Now().UTC().Format(SQLiteTimeFormat),
+			p.ID,
+			p.Status,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			return fmt.Errorf("%w: status of %s changed concurrently", ErrStatusTransition, p.Name)
+		}
+		return addJournalEntry(tx, p.NormalizedName, "", "", withReason("set status "+string(status), reason), c)
+	})
+}
+
+// DeleteProject soft-deletes a project. It disappears from the index and can be restored
+// with RestoreProject during the DeletionRetention window.
+func (r *Repository) DeleteProject(n, reason string, c context.Context) error {
+	return r.SetProjectStatus(n, ProjectDeleted, reason, c)
+}
+
+// RestoreProject restores a soft-deleted project to active.
+func (r *Repository) RestoreProject(n string, c context.Context) error {
+	return r.SetProjectStatus(n, ProjectActive, "", c)
+}
+
+// retentionExpired checks if a deleted project can no longer be restored
+func (r *Repository) retentionExpired(p *Project, now time.Time) bool {
+	if r.DeletionRetention == 0 || p.StatusChangedAt == nil {
+		return false
+	}
+	return now.After(p.StatusChangedAt.Add(r.DeletionRetention))
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestProjectStatusTransitions tests moving projects between statuses, and that only
+// active projects accept uploads
+func TestProjectStatusTransitions(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	ctx := context.Background()
+	p, err := repo.GetOrCreateProject("lifecycle", ctx)
+	if err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+	if p.Status != ProjectActive || p.StatusChangedAt != nil {
+		t.Errorf("Expected new project to be active, got %+v", p)
+	}
+
+	err = repo.SetProjectStatus("lifecycle", ProjectArchived, "Moved to lifecycle2", ctx)
+	if err != nil {
+		t.Fatalf("SetProjectStatus failed: %v", err)
+	}
+	p, _ = repo.GetProject("lifecycle", ctx)
+	if p.Status != ProjectArchived || p.StatusReason != "Moved to lifecycle2" || p.StatusChangedAt == nil {
+		t.Errorf("Expected project to be archived, got %+v", p)
+	}
+
+	pvi := &ProjectVersionInsert{
+		ProjectName:  "lifecycle",
+		Version:      "1.0",
+		Filename:     "lifecycle-1.0.tar.gz",
+		SHA256Digest: "abc",
+		FilePath:     "/data/lifecycle/lifecycle-1.0.tar.gz",
+		FileType:     "sdist",
+	}
+	if err := repo.CreateProjectVersion(pvi, ctx); !errors.Is(err, ErrProjectNotActive) {
+		t.Errorf("Expected ErrProjectNotActive for archived project, got %v", err)
+	}
+
+	if err := repo.DeleteProject("lifecycle", "", ctx); err != nil {
+		t.Fatalf("DeleteProject failed: %v", err)
+	}
+	err = repo.SetProjectStatus("lifecycle", ProjectArchived, "", ctx)
+	if !errors.Is(err, ErrStatusTransition) {
+		t.Errorf("Expected deleted projects to only be restored, got %v", err)
+	}
+	if err := repo.RestoreProject("lifecycle", ctx); err != nil {
+		t.Fatalf("RestoreProject failed: %v", err)
+	}
+	if err := repo.CreateProjectVersion(pvi, ctx); err != nil {
+		t.Errorf("Expected restored project to accept uploads, got %v", err)
+	}
+
+	if _, err := ParseProjectStatus("frozen"); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("Expected ErrInvalidStatus, got %v", err)
+	}
+}
+
+// TestRestoreProjectRetention tests that deleted projects can only be restored during
+// the retention window
+func TestRestoreProjectRetention(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	repo.DeletionRetention = 24 * time.Hour
+
+	ctx := context.Background()
+	if _, err := repo.GetOrCreateProject("expired", ctx); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+	if err := repo.DeleteProject("expired", "Spam", ctx); err != nil {
+		t.Fatalf("DeleteProject failed: %v", err)
+	}
+	_, err = repo.DB.Exec(
+		"update projects set status_changed_at = ? where normalized_name = 'expired'",
+		time.Now().Add(-48*time.Hour).UTC().Format(SQLiteTimeFormat),
+	)
+	if err != nil {
+		t.Fatalf("Error backdating deletion: %v", err)
+	}
+
+	if err := repo.RestoreProject("expired", ctx); !errors.Is(err, ErrRetentionExpired) {
+		t.Errorf("Expected ErrRetentionExpired, got %v", err)
+	}
+}
+
+// TestOwnedProjectStatus tests that owners can archive and delete their projects, but not
+// lift a quarantine or restore a deletion
+func TestOwnedProjectStatus(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := repo.GetOrCreateProject("owned", ctx); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+
+	if err := repo.SetOwnedProjectStatus("owned", ProjectArchived, "", ctx); err != nil {
+		t.Fatalf("SetOwnedProjectStatus failed: %v", err)
+	}
+	if err := repo.SetOwnedProjectStatus("owned", ProjectActive, "", ctx); err != nil {
+		t.Fatalf("SetOwnedProjectStatus failed: %v", err)
+	}
+	if err := repo.SetOwnedProjectStatus("owned", ProjectQuarantined, "", ctx); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected owners not to quarantine projects, got %v", err)
+	}
+
+	if err := repo.SetProjectStatus("owned", ProjectQuarantined, "Malware", ctx); err != nil {
+		t.Fatalf("SetProjectStatus failed: %v", err)
+	}
+	for _, status := range []ProjectStatus{ProjectActive, ProjectArchived, ProjectQuarantined} {
+		if err := repo.SetOwnedProjectStatus("owned", status, "", ctx); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected owners not to move quarantined projects to %s, got %v", status, err)
+		}
+	}
+
+	if err := repo.SetProjectStatus("owned", ProjectActive, "", ctx); err != nil {
+		t.Fatalf("SetProjectStatus failed: %v", err)
+	}
+	if err := repo.SetOwnedProjectStatus("owned", ProjectDeleted, "", ctx); err != nil {
+		t.Fatalf("SetOwnedProjectStatus failed: %v", err)
+	}
+	if err := repo.SetOwnedProjectStatus("owned", ProjectActive, "", ctx); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected owners not to restore deleted projects, got %v", err)
+	}
+	if p, _ := repo.GetProject("owned", ctx); p == nil || p.Status != ProjectDeleted {
+		t.Errorf("Expected the project to stay deleted, got %+v", p)
+	}
+}
@@ -3,21 +3,25 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"go-pip-server/pep440"
 	"log/slog"
 	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 // GetOrCreateProject retrieves a project by name, or creates it if it does not exist.
+// Names are compared after PEP 503 normalization, and new projects keep the given
+// name as their display name. New projects are owned by the user of the context, if any.
 func (r *Repository) GetOrCreateProject(n string, c context.Context) (*Project, error) {
 	tx, err := r.DB.BeginTx(c, nil)
 	if err != nil {
 		return nil, err
 	}
-	_, err = tx.Exec(
-		"insert into projects (name) values (?) on conflict(name) do nothing",
-		n,
-	)
+	_, err = createProject(tx, n, c)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
@@ -28,100 +32,255 @@ func (r *Repository) GetOrCreateProject(n string, c context.Context) (*Project,
 		return nil, err
 	}
 
+	return r.GetProject(n, c)
+}
+
+// projectColumns are the columns selected to build a Project, from the projects table
+const projectColumns = `id, name, normalized_name, status, status_reason, status_changed_at,
+    (select coalesce(max(j.id), 0) from journal_entries as j where j.index_id = projects.index_id and j.project_name = projects.normalized_name)`
+
+// scanProject scans a row selected with projectColumns
+func scanProject(row scanner) (*Project, error) {
 	var p Project
-	err = r.DB.QueryRow("select id, name from projects where name = ?", n).Scan(&p.ID, &p.Name)
+	var changedAt sql.NullTime
+	err := row.Scan(&p.ID, &p.Name, &p.NormalizedName, &p.Status, &p.StatusReason, &changedAt, &p.LastSerial)
 	if err != nil {
 		return nil, err
 	}
+	if changedAt.Valid {
+		p.StatusChangedAt = &changedAt.Time
+	}
 	return &p, nil
 }
 
-// GetAllProjects retrieves all projects from the database along with the highest project ID.
+// GetProject retrieves a project by name, compared after PEP 503 normalization, whatever
+// its status. Returns ErrProjectNotFound if there is no such project.
+func (r *Repository) GetProject(n string, c context.Context) (*Project, error) {
+	p, err := scanProject(r.DB.QueryRowContext(
+		c,
+		"select "+projectColumns+" from projects where index_id = ? and normalized_name = ?",
+		IndexID(c),
+		NormalizeName(n),
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetAllProjects retrieves all projects from the database, whatever their status, along
+// with the last serial of the index.
 func (r *Repository) GetAllProjects(c context.Context) (*AllProjects, error) {
-	rows, err := r.DB.QueryContext(c, "select id, name from projects")
+	serial, err := r.lastSerial(c)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(
+		c,
+		"select "+projectColumns+" from projects where index_id = ? order by normalized_name",
+		IndexID(c),
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	projects := make([]*Project, 0, 64)
-	var maxId int64 = -1
 
 	for rows.Next() {
-		var p Project
-		err := rows.Scan(&p.ID, &p.Name)
+		p, err := scanProject(rows)
 		if err != nil {
 			slog.Error("Failed to scan project row", "error", err)
+			continue
 		}
-		if p.ID > maxId {
-			maxId = p.ID
-		}
-		projects = append(projects, &p)
+		projects = append(projects, p)
 	}
 	return &AllProjects{
 		Projects:   projects,
-		LastSerial: maxId,
+		LastSerial: serial,
 	}, nil
 }
 
-// GetLatestProjectVersionId retrieves the latest version ID for a given project name,
-// optionally within a transaction.
+// GetLatestProjectVersionId retrieves the ID of the latest release for a given project name,
+// following PEP 440 ordering. Final releases are preferred over pre-releases.
 func (r *Repository) GetLatestProjectVersionId(pn string, c context.Context, tx *sql.Tx) (int64, error) {
-	qry := `select v.id 
-            from versions as v 
-            join projects as p on v.project_id = p.id 
-            where p.name = ? 
-            order by v.created_at desc, v.id desc
-            limit 1`
-	var id int64
+	qry := `select rl.id, rl.version
+            from releases as rl
+            join projects as p on rl.project_id = p.id
+            where p.index_id = ? and p.normalized_name = ?`
+	var rows *sql.Rows
 	var err error = nil
 	if tx != nil {
-		err = tx.QueryRowContext(c, qry, pn).Scan(&id)
+		rows, err = tx.QueryContext(c, qry, IndexID(c), NormalizeName(pn))
 	} else {
-		err = r.DB.QueryRowContext(c, qry, pn).Scan(&id)
+		rows, err = r.DB.QueryContext(c, qry, IndexID(c), NormalizeName(pn))
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, 16)
+	versions := make([]string, 0, 16)
+	for rows.Next() {
+		var id int64
+		var version string
+		if err := rows.Scan(&id, &version); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	latest := pep440.Latest(versions)
+	if latest < 0 {
+		return 0, sql.ErrNoRows
 	}
-	return id, err
+	return ids[latest], nil
 }
 
-// CreateProjectVersion creates a new project version along with its metadata in the database.
+// CreateProjectVersion stores a distribution file of a project version in the database. The
+// file is attached to the existing release for the version, or to a new release which is
+// created along with its metadata. The project, release and file are created in a single
+// transaction. Returns ErrFileExists if a file with the same name was already uploaded,
+// ErrProjectNotActive if the project is archived, quarantined or deleted, and
+// ErrPermissionDenied if the user of the context has no upload role on an existing project,
+// or if the upload is anonymous and the project has collaborators.
 func (r *Repository) CreateProjectVersion(pvi *ProjectVersionInsert, c context.Context) error {
+	tx, err := r.DB.BeginTx(c, nil)
+	if err != nil {
+		slog.Error("Unable to begin transaction", "error", err)
+		return err
+	}
+
 	// Get / create parent project
-	proj, err := r.GetOrCreateProject(pvi.ProjectName, c)
+	projectCreated, err := createProject(tx, pvi.ProjectName, c)
+	if err != nil {
+		slog.Error("Unable to create project", "error", err)
+		tx.Rollback()
+		return err
+	}
+	var projectId int64
+	var status ProjectStatus
+	err = tx.QueryRowContext(
+		c,
+		"select id, status from projects where index_id = ? and normalized_name = ?",
+		IndexID(c),
+		NormalizeName(pvi.ProjectName),
+	).Scan(&projectId, &status)
 	if err != nil {
-		slog.Error("Unable to get or create project", "error", err)
+		slog.Error("Unable to get project ID", "error", err)
+		tx.Rollback()
 		return err
 	}
+	if status != ProjectActive {
+		tx.Rollback()
+		return fmt.Errorf("%w: project is %s", ErrProjectNotActive, status)
+	}
+	// Users need a role to upload to projects they did not create, and anonymous uploads
+	// are limited to projects without collaborators. Trusted publishers are registered
+	// for the project they upload to.
+	if u := UserFrom(c); u != nil && !projectCreated {
+		role, err := userRole(tx, projectId, u.ID, c)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if !role.Can(PermissionUpload) {
+			tx.Rollback()
+			return fmt.Errorf("%w: %s cannot upload to %s", ErrPermissionDenied, u.Username, pvi.ProjectName)
+		}
+	} else if u == nil && !projectCreated && !publisherFrom(c) {
+		var roles int
+		err := tx.QueryRowContext(c, "select count(*) from project_roles where project_id = ?", projectId).Scan(&roles)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if roles > 0 {
+			tx.Rollback()
+			return fmt.Errorf("%w: anonymous uploads to %s", ErrPermissionDenied, pvi.ProjectName)
+		}
+	}
 
-	// Insert new version
-	tx, err := r.DB.BeginTx(c, nil)
+	// Get / create the release, files of equal versions such as 1.0 and 1.0.0 share one
+	version, err := releaseVersion(tx, projectId, pvi.Version, c)
 	if err != nil {
-		slog.Error("Unable to begin transaction", "error", err)
+		slog.Error("Unable to get release versions", "error", err)
+		tx.Rollback()
+		return err
+	}
+	res, err := tx.ExecContext(
+		c,
+		"insert into releases (project_id, version) values (?, ?) on conflict do nothing",
+		projectId,
+		version,
+	)
+	if err != nil {
+		slog.Error("Unable to insert release", "error", err)
+		tx.Rollback()
 		return err
 	}
+	created, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var releaseId int64
+	err = tx.QueryRowContext(
+		c,
+		"select id from releases where project_id = ? and version = ?",
+		projectId,
+		version,
+	).Scan(&releaseId)
+	if err != nil {
+		slog.Error("Unable to get release ID", "error", err)
+		tx.Rollback()
+		return err
+	}
+
+	// Insert the file
 	_, err = tx.ExecContext(
 		c,
-		"insert into versions (project_id, digest, digest_type, filepath, version, file_type) values (?, ?, ?, ?, ?, ?)",
-		proj.ID,
-		pvi.Digest,
-		pvi.DigestType,
+		`insert into release_files (
+             index_id, release_id, filename, filepath, file_type, python_tag, requires_python, size,
+             sha256_digest, md5_digest, blake2_256_digest, metadata_digest
+         ) values (?, ?, ?, ?, ?, ?, ?, ?, ?, nullif(?, ''), nullif(?, ''), nullif(?, ''))`,
+		IndexID(c),
+		releaseId,
+		pvi.Filename,
 		pvi.FilePath,
-		pvi.Version,
 		pvi.FileType,
+		pvi.PythonTag,
+		pvi.RequiresPython,
+		pvi.Size,
+		pvi.SHA256Digest,
+		pvi.MD5Digest,
+		pvi.Blake2b256Digest,
+		pvi.MetadataDigest,
 	)
-	if err != nil {
-		slog.Error("Unable to insert version", "error", err)
+	if isUniqueViolation(err) {
 		tx.Rollback()
-		return err
+		return ErrFileExists
+	}
+	if err == nil && created > 0 {
+		err = addJournalEntry(tx, pvi.ProjectName, version, "", "new release", c)
+	}
+	if err == nil {
+		err = addJournalEntry(tx, pvi.ProjectName, version, pvi.Filename, "add "+pvi.FileType+" file", c)
 	}
-	vId, err := r.GetLatestProjectVersionId(pvi.ProjectName, c, tx)
 	if err != nil {
-		slog.Error("Unable to get latest version ID", "error", err)
+		slog.Error("Unable to insert release file", "error", err)
 		tx.Rollback()
 		return err
 	}
 
-	// Add metadata fields
-	if len(pvi.Metadata) > 0 {
-		metaQry := makeMetaInsertQuery(vId, len(pvi.Metadata))
+	// Add metadata fields of new releases
+	if created > 0 && len(pvi.Metadata) > 0 {
+		metaQry := makeMetaInsertQuery(releaseId, len(pvi.Metadata))
 		flatKVs := flattenKVs(pvi.Metadata)
 		_, err = tx.ExecContext(c, metaQry, flatKVs...)
 		if err != nil {
@@ -130,16 +289,83 @@ func (r *Repository) CreateProjectVersion(pvi *ProjectVersionInsert, c context.C
 			return err
 		}
 	}
-	tx.Commit()
-	return nil
+
+	if pvi.BeforeCommit != nil {
+		err = pvi.BeforeCommit()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// releaseVersion returns the version of an existing release of a project which is equal to
+// the given version under PEP 440, or the version itself if there is none
+func releaseVersion(tx *sql.Tx, projectId int64, version string, c context.Context) (string, error) {
+	v, err := pep440.Parse(version)
+	if err != nil {
+		return version, nil
+	}
+	rows, err := tx.QueryContext(c, "select version from releases where project_id = ?", projectId)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var existing string
+		if err := rows.Scan(&existing); err != nil {
+			return "", err
+		}
+		if ev, err := pep440.Parse(existing); err == nil && ev.Equal(v) {
+			return existing, nil
+		}
+	}
+	return version, rows.Err()
+}
+
+// createProject creates a project if it does not exist, and reports whether it did. New
+// projects are journaled, and owned by the user of the context if any.
+func createProject(tx *sql.Tx, n string, c context.Context) (bool, error) {
+	res, err := tx.ExecContext(
+		c,
+		"insert into projects (index_id, name, normalized_name) values (?, ?, ?) on conflict do nothing",
+		IndexID(c),
+		n,
+		NormalizeName(n),
+	)
+	if err != nil {
+		return false, err
+	}
+	created, err := res.RowsAffected()
+	if err != nil || created == 0 {
+		return false, err
+	}
+	if err := addJournalEntry(tx, n, "", "", "create", c); err != nil {
+		return false, err
+	}
+	if u := UserFrom(c); u != nil {
+		id, err := res.LastInsertId()
+		if err != nil {
+			return false, err
+		}
+		return true, addOwner(tx, id, u, n, c)
+	}
+	return true, nil
+}
+
+// isUniqueViolation checks if an error is caused by a unique constraint
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
 }
 
 // makeMetaInsertQuery constructs an SQL insert query template for metadata key-value pairs.
-func makeMetaInsertQuery(vId int64, total int) string {
-	base := "insert into version_metadata_fields (version_id, key, value) values "
+func makeMetaInsertQuery(rId int64, total int) string {
+	base := "insert into release_metadata_fields (release_id, key, value) values "
 	slots := make([]string, 0, total)
 	for range total {
-		slots = append(slots, fmt.Sprintf("(%d, ?, ?)", vId))
+		slots = append(slots, fmt.Sprintf("(%d, ?, ?)", rId))
 	}
 	base += strings.Join(slots, ", ")
 	return base
@@ -2,6 +2,9 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
 	"testing"
 )
 
@@ -46,12 +49,14 @@ func TestCreateProjectVersion(t *testing.T) {
 
 	ctx := context.Background()
 	pvi := &ProjectVersionInsert{
-		ProjectName: "test-project",
-		Version:     "1.0.0",
-		Digest:      "abc123",
-		DigestType:  "sha256",
-		FilePath:    "/path/to/file.whl",
-		FileType:    "bdist_wheel",
+		ProjectName:  "test-project",
+		Version:      "1.0.0",
+		Filename:     "file.whl",
+		SHA256Digest: "abc123",
+		MD5Digest:    "def456",
+		FilePath:     "/path/to/file.whl",
+		FileType:     "bdist_wheel",
+		Size:         1024,
 	}
 
 	// Create the first version
@@ -69,25 +74,29 @@ func TestCreateProjectVersion(t *testing.T) {
 		t.Errorf("Expected positive version ID, got %d", versionId)
 	}
 
-	// Verify version data in database
-	var digest, digestType, filepath string
+	// Verify file data in database
+	var sha256Digest, md5Digest, filepath string
+	var size int64
 	err = repo.DB.QueryRow(
-		"SELECT digest, digest_type, filepath FROM versions WHERE id = ?",
+		"SELECT sha256_digest, md5_digest, filepath, size FROM release_files WHERE release_id = ?",
 		versionId,
-	).Scan(&digest, &digestType, &filepath)
+	).Scan(&sha256Digest, &md5Digest, &filepath, &size)
 	if err != nil {
-		t.Fatalf("Error querying version: %v", err)
+		t.Fatalf("Error querying release file: %v", err)
 	}
 
-	if digest != pvi.Digest {
-		t.Errorf("Expected digest %s, got %s", pvi.Digest, digest)
+	if sha256Digest != pvi.SHA256Digest {
+		t.Errorf("Expected digest %s, got %s", pvi.SHA256Digest, sha256Digest)
 	}
-	if digestType != pvi.DigestType {
-		t.Errorf("Expected digest type %s, got %s", pvi.DigestType, digestType)
+	if md5Digest != pvi.MD5Digest {
+		t.Errorf("Expected MD5 digest %s, got %s", pvi.MD5Digest, md5Digest)
 	}
 	if filepath != pvi.FilePath {
 		t.Errorf("Expected filepath %s, got %s", pvi.FilePath, filepath)
 	}
+	if size != pvi.Size {
+		t.Errorf("Expected size %d, got %d", pvi.Size, size)
+	}
 }
 
 // TestCreateProjectVersionWithMetadata tests creating a version with metadata
@@ -105,13 +114,13 @@ func TestCreateProjectVersionWithMetadata(t *testing.T) {
 	}
 
 	pvi := &ProjectVersionInsert{
-		ProjectName: "test-metadata-project",
-		Version:     "2.0.0",
-		Digest:      "def456",
-		DigestType:  "sha256",
-		FilePath:    "/path/to/metadata-file.whl",
-		Metadata:    metadata,
-		FileType:    "bdist_wheel",
+		ProjectName:  "test-metadata-project",
+		Version:      "2.0.0",
+		Filename:     "metadata-file.whl",
+		SHA256Digest: "def456",
+		FilePath:     "/path/to/metadata-file.whl",
+		Metadata:     metadata,
+		FileType:     "bdist_wheel",
 	}
 
 	// Create version with metadata
@@ -128,7 +137,7 @@ func TestCreateProjectVersionWithMetadata(t *testing.T) {
 
 	// Verify metadata was stored
 	rows, err := repo.DB.Query(
-		"SELECT key, value FROM version_metadata_fields WHERE version_id = ?",
+		"SELECT key, value FROM release_metadata_fields WHERE release_id = ?",
 		versionId,
 	)
 	if err != nil {
@@ -157,3 +166,236 @@ func TestCreateProjectVersionWithMetadata(t *testing.T) {
 		}
 	}
 }
+
+// TestCreateProjectNormalizedName tests that project names differing only in
+// PEP 503 normalization refer to the same project
+func TestCreateProjectNormalizedName(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	ctx := context.Background()
+	p1, err := repo.GetOrCreateProject("My_Package", ctx)
+	if err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+	if p1.NormalizedName != "my-package" {
+		t.Errorf("Expected normalized name my-package, got %s", p1.NormalizedName)
+	}
+
+	for _, n := range []string{"my-package", "my.package", "MY-PACKAGE"} {
+		p2, err := repo.GetOrCreateProject(n, ctx)
+		if err != nil {
+			t.Fatalf("GetOrCreateProject failed: %v", err)
+		}
+		if p2.ID != p1.ID {
+			t.Errorf("Expected %s to map to project %d, got %d", n, p1.ID, p2.ID)
+		}
+		if p2.Name != "My_Package" {
+			t.Errorf("Expected display name My_Package to be kept, got %s", p2.Name)
+		}
+	}
+
+	ps, err := repo.GetAllProjects(ctx)
+	if err != nil {
+		t.Fatalf("GetAllProjects failed: %v", err)
+	}
+	if len(ps.Projects) != 1 {
+		t.Errorf("Expected a single project, got %d", len(ps.Projects))
+	}
+}
+
+// TestCreateProjectVersionSameRelease tests that files of the same version are attached
+// to a single release, which keeps the metadata of its first file
+func TestCreateProjectVersionSameRelease(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	ctx := context.Background()
+	filenames := []string{
+		"multi-1.0.tar.gz",
+		"multi-1.0-py3-none-any.whl",
+		"multi-1.0-cp312-cp312-manylinux_2_17_x86_64.whl",
+	}
+	for i, fn := range filenames {
+		pvi := &ProjectVersionInsert{
+			ProjectName:  "multi",
+			Version:      "1.0",
+			Filename:     fn,
+			SHA256Digest: fmt.Sprintf("digest%d", i),
+			FilePath:     "/data/multi/" + fn,
+			FileType:     "bdist_wheel",
+			Metadata:     []*KeyVal{{Key: "summary", Val: fn}},
+		}
+		if err := repo.CreateProjectVersion(pvi, ctx); err != nil {
+			t.Fatalf("CreateProjectVersion failed: %v", err)
+		}
+	}
+
+	releases, err := repo.GetProjectReleases("multi", ctx)
+	if err != nil {
+		t.Fatalf("GetProjectReleases failed: %v", err)
+	}
+	if len(releases) != 1 {
+		t.Fatalf("Expected a single release, got %d", len(releases))
+	}
+	if len(releases[0].Files) != len(filenames) {
+		t.Errorf("Expected %d files in release, got %d", len(filenames), len(releases[0].Files))
+	}
+
+	var count int
+	err = repo.DB.QueryRow(
+		"SELECT count(*) FROM release_metadata_fields WHERE release_id = ?",
+		releases[0].ID,
+	).Scan(&count)
+	if err != nil {
+		t.Fatalf("Error querying metadata: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected metadata to be stored once, got %d rows", count)
+	}
+
+	rl, err := repo.GetRelease("multi", "1.0", ctx)
+	if err != nil {
+		t.Fatalf("GetRelease failed: %v", err)
+	}
+	if rl.ID != releases[0].ID {
+		t.Errorf("Expected release %d, got %d", releases[0].ID, rl.ID)
+	}
+	if _, err := repo.GetRelease("multi", "2.0", ctx); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("Expected ErrReleaseNotFound, got %v", err)
+	}
+}
+
+// TestCreateProjectVersionDuplicateFile tests that a file name can only be uploaded once,
+// and that failed inserts leave nothing behind
+func TestCreateProjectVersionDuplicateFile(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	ctx := context.Background()
+	committed := 0
+	pvi := &ProjectVersionInsert{
+		ProjectName:  "dup",
+		Version:      "1.0",
+		Filename:     "dup-1.0.tar.gz",
+		SHA256Digest: "abc123",
+		FilePath:     "/data/dup/dup-1.0.tar.gz",
+		FileType:     "sdist",
+		BeforeCommit: func() error {
+			committed++
+			return nil
+		},
+	}
+	if err := repo.CreateProjectVersion(pvi, ctx); err != nil {
+		t.Fatalf("CreateProjectVersion failed: %v", err)
+	}
+	if committed != 1 {
+		t.Errorf("Expected BeforeCommit to be called once, got %d", committed)
+	}
+
+	err = repo.CreateProjectVersion(pvi, ctx)
+	if !errors.Is(err, ErrFileExists) {
+		t.Errorf("Expected ErrFileExists, got %v", err)
+	}
+	if committed != 1 {
+		t.Errorf("Expected BeforeCommit not to be called for duplicates")
+	}
+
+	f, err := repo.FindFile("dup-1.0.tar.gz", ctx)
+	if err != nil {
+		t.Fatalf("FindFile failed: %v", err)
+	}
+	if f.SHA256Digest != "abc123" {
+		t.Errorf("Expected original file to be kept, got %+v", f)
+	}
+
+	// An error before committing rolls back the project, release and file
+	failing := &ProjectVersionInsert{
+		ProjectName:  "rolled-back",
+		Version:      "1.0",
+		Filename:     "rolled_back-1.0.tar.gz",
+		SHA256Digest: "def456",
+		FilePath:     "/data/rolled-back/rolled_back-1.0.tar.gz",
+		FileType:     "sdist",
+		BeforeCommit: func() error { return errors.New("disk full") },
+	}
+	if err := repo.CreateProjectVersion(failing, ctx); err == nil {
+		t.Fatalf("Expected CreateProjectVersion to fail")
+	}
+	if _, err := repo.GetProject("rolled-back", ctx); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("Expected project to be rolled back, got %v", err)
+	}
+	if _, err := repo.FindFile("rolled_back-1.0.tar.gz", ctx); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected file to be rolled back, got %v", err)
+	}
+}
+
+// TestReleaseVersionOrdering tests that releases are ordered by PEP 440 rather than by upload,
+// and that files of equal versions share a release
+func TestReleaseVersionOrdering(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	ctx := context.Background()
+	uploads := [][2]string{
+		{"2.0.0", "ordered-2.0.0.tar.gz"},
+		{"1.0.1", "ordered-1.0.1.tar.gz"},
+		{"2.1rc1", "ordered-2.1rc1.tar.gz"},
+		{"10.0", "ordered-10.0.tar.gz"},
+		{"10.0.0", "ordered-10.0.0-py3-none-any.whl"},
+	}
+	for _, u := range uploads {
+		pvi := &ProjectVersionInsert{
+			ProjectName:  "ordered",
+			Version:      u[0],
+			Filename:     u[1],
+			SHA256Digest: u[1],
+			FilePath:     "/data/ordered/" + u[1],
+			FileType:     "sdist",
+		}
+		if err := repo.CreateProjectVersion(pvi, ctx); err != nil {
+			t.Fatalf("CreateProjectVersion failed: %v", err)
+		}
+	}
+
+	releases, err := repo.GetProjectReleases("ordered", ctx)
+	if err != nil {
+		t.Fatalf("GetProjectReleases failed: %v", err)
+	}
+	versions := make([]string, len(releases))
+	for i, rl := range releases {
+		versions[i] = rl.Version
+	}
+	expected := []string{"1.0.1", "2.0.0", "2.1rc1", "10.0"}
+	if !slices.Equal(versions, expected) {
+		t.Errorf("Expected releases %v, got %v", expected, versions)
+	}
+	if len(releases[3].Files) != 2 {
+		t.Errorf("Expected 10.0 and 10.0.0 to share a release, got %d files", len(releases[3].Files))
+	}
+
+	latest, err := repo.GetLatestProjectVersionId("ordered", ctx, nil)
+	if err != nil {
+		t.Fatalf("GetLatestProjectVersionId failed: %v", err)
+	}
+	if latest != releases[3].ID {
+		t.Errorf("Expected latest release to be 10.0, got ID %d", latest)
+	}
+
+	rl, err := repo.GetRelease("ordered", "10.0.0", ctx)
+	if err != nil || rl.ID != releases[3].ID {
+		t.Errorf("Expected GetRelease to match equal versions, got %+v (err: %v)", rl, err)
+	}
+}
@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AddTrustedPublisher registers a trusted publisher for a project. At least one claim
+// constraint is required, as any job of the issuer could publish otherwise. Claim values
+// may contain wildcards as understood by path.Match, such as refs/tags/v*.
+func (r *Repository) AddTrustedPublisher(n string, ins *TrustedPublisherInsert, c context.Context) (*TrustedPublisher, error) {
+	p, err := r.GetProject(n, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := ins.validate(r.AllowLoopbackHTTPIssuers); err != nil {
+		return nil, err
+	}
+	claims, err := json.Marshal(ins.Claims)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int64
+	err = r.withTx(c, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(
+			c,
+			"insert into trusted_publishers (project_id, issuer, audience, claims) values (?, ?, ?, ?)",
+			p.ID,
+			ins.Issuer,
+			ins.Audience,
+			string(claims),
+		)
+		if err != nil {
+			return err
+		}
+		if id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		return addJournalEntry(tx, p.NormalizedName, "", "", "add trusted publisher "+ins.Issuer, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.getTrustedPublisher(id, c)
+}
+
+// validate checks the issuer URL, audience and claim constraints of a new publisher. The
+// issuer must use https, as its signing keys could be replaced in transit otherwise.
+func (ins *TrustedPublisherInsert) validate(allowLoopbackHTTP bool) error {
+	u, err := url.Parse(ins.Issuer)
+	if err != nil || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("%w: the issuer must be an https URL", ErrInvalidPublisher)
+	}
+	loopback := u.Hostname() == "localhost" || net.ParseIP(u.Hostname()).IsLoopback()
+	if u.Scheme != "https" && !(u.Scheme == "http" && allowLoopbackHTTP && loopback) {
+		return fmt.Errorf("%w: the issuer must be an https URL", ErrInvalidPublisher)
+	}
+	if strings.TrimSpace(ins.Audience) == "" {
+		return fmt.Errorf("%w: an audience is required", ErrInvalidPublisher)
+	}
+	if len(ins.Claims) == 0 {
+		return fmt.Errorf("%w: at least one claim constraint is required", ErrInvalidPublisher)
+	}
+	for name, pattern := range ins.Claims {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" || strings.Trim(pattern, "*") == "" {
+			return fmt.Errorf("%w: invalid constraint for claim %q", ErrInvalidPublisher, name)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the claims of a verified token satisfy the constraints of the
+// publisher. The issuer and audience are checked when verifying the token.
+func (tp *TrustedPublisher) Matches(claims map[string]any) bool {
+	for name, pattern := range tp.Claims {
+		value, ok := claims[name].(string)
+		if !ok {
+			return false
+		}
+		if matched, err := path.Match(pattern, value); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// GetTrustedPublishers retrieves the trusted publishers of a project, or of all projects
+// of the index if the name is empty
+func (r *Repository) GetTrustedPublishers(n string, c context.Context) ([]*TrustedPublisher, error) {
+	return r.queryTrustedPublishers(c, "? = '' or p.normalized_name = ?", n, NormalizeName(n))
+}
+
+// GetPublishersByIssuer retrieves the trusted publishers of an issuer in the index
+func (r *Repository) GetPublishersByIssuer(issuer string, c context.Context) ([]*TrustedPublisher, error) {
+	return r.queryTrustedPublishers(c, "tp.issuer = ?", issuer)
+}
+
+// RemoveTrustedPublisher removes a trusted publisher of a project, which revokes the
+// tokens minted for it. Returns ErrPublisherNotFound if there is no such publisher.
+func (r *Repository) RemoveTrustedPublisher(n string, id int64, c context.Context) error {
+	tp, err := r.getTrustedPublisher(id, c)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && tp.Project != NormalizeName(n)) {
+		return fmt.Errorf("%w: %d", ErrPublisherNotFound, id)
+	} else if err != nil {
+		return err
+	}
+	return r.withTx(c, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(c, "delete from trusted_publishers where id = ?", id); err != nil {
+			return err
+		}
+		return addJournalEntry(tx, tp.Project, "", "", "remove trusted publisher "+tp.Issuer, c)
+	})
+}
+
+// MintPublisherToken issues a short-lived API token for trusted publishers, in exchange
+// for an OIDC token with the given ID, which is required to detect replays. Returns
+// ErrTokenReused if the OIDC token was already exchanged.
+func (r *Repository) MintPublisherToken(publishers []*TrustedPublisher, jti string, ttl time.Duration, c context.Context) (string, time.Time, error) {
+	if jti == "" {
+		return "", time.Time{}, errors.New("an OIDC token ID is required")
+	}
+	secret, err := newTokenSecret()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl).Truncate(time.Second)
+
+	err = r.withTx(c, func(tx *sql.Tx) error {
+		// Old tokens are cleaned up, but their OIDC token IDs are kept for a while longer
+		// than OIDC tokens usually live
+		_, err := tx.ExecContext(
+			c,
+			"delete from publisher_tokens where expires_at < ?",
+			now.Add(-24*time.Hour).Format(SQLiteTimeFormat),
+		)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(
+			c,
+			"insert into publisher_tokens (index_id, token_hash, jti, expires_at) values (?, ?, ?, ?)",
+			IndexID(c),
+			hashToken(secret),
+			jti,
+			expiresAt.Format(SQLiteTimeFormat),
+		)
+		if isUniqueViolation(err) {
+			return ErrTokenReused
+		} else if err != nil {
+			return err
+		}
+		tokenId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, tp := range publishers {
+			_, err := tx.ExecContext(
+				c,
+				"insert into publisher_token_scopes (token_id, publisher_id) values (?, ?)",
+				tokenId,
+				tp.ID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return secret, expiresAt, nil
+}
+
+// authenticatePublisherToken checks a token minted for trusted publishers, and returns
+// it as an API token without a user, covering the projects of its publishers in the
+// index it was minted for
+func (r *Repository) authenticatePublisherToken(secret string, c context.Context) (*APIToken, error) {
+	var t APIToken
+	var projects string
+	err := r.DB.QueryRowContext(
+		c,
+		`select t.id, t.index_id, t.expires_at, t.created_at,
+                (select coalesce(group_concat(p.normalized_name, ' '), '')
+                 from publisher_token_scopes as s
+                 join trusted_publishers as tp on s.publisher_id = tp.id
+                 join projects as p on tp.project_id = p.id
+                 where s.token_id = t.id)
+         from publisher_tokens as t where t.token_hash = ?`,
+		hashToken(secret),
+	).Scan(&t.ID, &t.IndexID, &t.ExpiresAt, &t.CreatedAt, &projects)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(*t.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	t.Projects = strings.Fields(projects)
+	slices.Sort(t.Projects)
+	// Tokens without projects would cover all projects, but here it means their
+	// publishers were removed
+	if len(t.Projects) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	t.Name = "trusted publisher"
+	t.Prefix = secret[:tokenDisplayLen]
+	t.Publisher = true
+	return &t, nil
+}
+
+// getTrustedPublisher retrieves a trusted publisher by ID
+func (r *Repository) getTrustedPublisher(id int64, c context.Context) (*TrustedPublisher, error) {
+	tps, err := r.queryTrustedPublishers(c, "tp.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(tps) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return tps[0], nil
+}
+
+// queryTrustedPublishers retrieves the trusted publishers of the index matching a where
+// clause, from trusted_publishers as tp joined with projects as p
+func (r *Repository) queryTrustedPublishers(c context.Context, where string, args ...any) ([]*TrustedPublisher, error) {
+	rows, err := r.DB.QueryContext(
+		c,
+		`select tp.id, p.normalized_name, tp.issuer, tp.audience, tp.claims, tp.created_at
+         from trusted_publishers as tp join projects as p on tp.project_id = p.id
+         where p.index_id = ? and (`+where+`)
+         order by p.normalized_name, tp.id`,
+		append([]any{IndexID(c)}, args...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tps := make([]*TrustedPublisher, 0, 4)
+	for rows.Next() {
+		var tp TrustedPublisher
+		var claims string
+		if err := rows.Scan(&tp.ID, &tp.Project, &tp.Issuer, &tp.Audience, &claims, &tp.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(claims), &tp.Claims); err != nil {
+			return nil, fmt.Errorf("invalid claims of trusted publisher %d: %w", tp.ID, err)
+		}
+		tps = append(tps, &tp)
+	}
+	return tps, rows.Err()
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestTrustedPublishers tests registering publishers, matching claims and minting tokens
+func TestTrustedPublishers(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := repo.GetOrCreateProject("My_Package", ctx); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+
+	invalid := []*TrustedPublisherInsert{
+		{Issuer: "ftp://ci.example.com", Audience: "pip", Claims: map[string]string{"repository": "org/repo"}},
+		{Issuer: "http://ci.example.com", Audience: "pip", Claims: map[string]string{"repository": "org/repo"}},
+		{Issuer: "http://127.0.0.1:8090", Audience: "pip", Claims: map[string]string{"repository": "org/repo"}},
+		{Issuer: "https://ci.example.com", Audience: "", Claims: map[string]string{"repository": "org/repo"}},
+		{Issuer: "https://ci.example.com", Audience: "pip"},
+		{Issuer: "https://ci.example.com", Audience: "pip", Claims: map[string]string{"repository": "*"}},
+		{Issuer: "https://ci.example.com", Audience: "pip", Claims: map[string]string{"ref": "refs/tags/["}},
+	}
+	for _, ins := range invalid {
+		if _, err := repo.AddTrustedPublisher("my-package", ins, ctx); !errors.Is(err, ErrInvalidPublisher) {
+			t.Errorf("Expected ErrInvalidPublisher for %+v, got %v", ins, err)
+		}
+	}
+	repo.AllowLoopbackHTTPIssuers = true
+	_, err := repo.AddTrustedPublisher("my-package", &TrustedPublisherInsert{
+		Issuer: "http://ci.example.com", Audience: "pip", Claims: map[string]string{"repository": "org/repo"},
+	}, ctx)
+	if !errors.Is(err, ErrInvalidPublisher) {
+		t.Errorf("Expected ErrInvalidPublisher for an http issuer which is not a loopback address, got %v", err)
+	}
+	repo.AllowLoopbackHTTPIssuers = false
+
+	_, err = repo.AddTrustedPublisher("missing", &TrustedPublisherInsert{
+		Issuer: "https://ci.example.com", Audience: "pip", Claims: map[string]string{"repository": "org/repo"},
+	}, ctx)
+	if !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("Expected ErrProjectNotFound, got %v", err)
+	}
+
+	tp, err := repo.AddTrustedPublisher("my.package", &TrustedPublisherInsert{
+		Issuer:   "https://ci.example.com",
+		Audience: "pip",
+		Claims:   map[string]string{"repository": "org/repo", "ref": "refs/tags/v*"},
+	}, ctx)
+	if err != nil {
+		t.Fatalf("AddTrustedPublisher failed: %v", err)
+	}
+	if tp.Project != "my-package" || tp.Claims["ref"] != "refs/tags/v*" {
+		t.Errorf("Unexpected publisher %+v", tp)
+	}
+
+	publishers, err := repo.GetPublishersByIssuer("https://ci.example.com", ctx)
+	if err != nil || len(publishers) != 1 {
+		t.Fatalf("Expected the publisher of the issuer, got %v (err: %v)", publishers, err)
+	}
+	matching := map[string]any{"repository": "org/repo", "ref": "refs/tags/v1.0", "sub": "x"}
+	if !publishers[0].Matches(matching) {
+		t.Errorf("Expected the claims to match")
+	}
+	for _, claims := range []map[string]any{
+		{"repository": "org/repo", "ref": "refs/heads/main"},
+		{"repository": "org/other", "ref": "refs/tags/v1.0"},
+		{"repository": "org/repo"},
+		{"repository": "org/repo", "ref": 1},
+	} {
+		if publishers[0].Matches(claims) {
+			t.Errorf("Expected the claims %v not to match", claims)
+		}
+	}
+
+	secret, expires, err := repo.MintPublisherToken(publishers, "jti-1", 15*time.Minute, ctx)
+	if err != nil {
+		t.Fatalf("MintPublisherToken failed: %v", err)
+	}
+	if time.Until(expires) > 15*time.Minute || time.Until(expires) < 14*time.Minute {
+		t.Errorf("Unexpected expiration %v", expires)
+	}
+	if _, _, err := repo.MintPublisherToken(publishers, "jti-1", 15*time.Minute, ctx); !errors.Is(err, ErrTokenReused) {
+		t.Errorf("Expected ErrTokenReused, got %v", err)
+	}
+	if _, _, err := repo.MintPublisherToken(publishers, "", 15*time.Minute, ctx); err == nil {
+		t.Errorf("Expected OIDC tokens without an ID to be rejected")
+	}
+
+	u, token, err := repo.AuthenticateToken(secret, ctx)
+	if err != nil {
+		t.Fatalf("AuthenticateToken failed: %v", err)
+	}
+	if u != nil || !token.Publisher || !token.Allows(DefaultIndexID, "My_Package") || token.Allows(DefaultIndexID, "other") {
+		t.Errorf("Unexpected user %+v and token %+v", u, token)
+	}
+
+	if err := repo.RemoveTrustedPublisher("other", tp.ID, ctx); !errors.Is(err, ErrPublisherNotFound) {
+		t.Errorf("Expected ErrPublisherNotFound for another project, got %v", err)
+	}
+	if err := repo.RemoveTrustedPublisher("my-package", tp.ID, ctx); err != nil {
+		t.Fatalf("RemoveTrustedPublisher failed: %v", err)
+	}
+	// Tokens of removed publishers must not turn into tokens for all projects
+	if _, _, err := repo.AuthenticateToken(secret, ctx); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials after removing the publisher, got %v", err)
+	}
+	if publishers, _ := repo.GetTrustedPublishers("", ctx); len(publishers) != 0 {
+		t.Errorf("Expected no publishers left, got %v", publishers)
+	}
+}
+
+// TestPublisherTokenExpired tests that expired minted tokens are refused
+func TestPublisherTokenExpired(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := repo.GetOrCreateProject("pkg", ctx); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+	tp, err := repo.AddTrustedPublisher("pkg", &TrustedPublisherInsert{
+		Issuer: "https://ci.example.com", Audience: "pip", Claims: map[string]string{"sub": "repo:org/pkg:*"},
+	}, ctx)
+	if err != nil {
+		t.Fatalf("AddTrustedPublisher failed: %v", err)
+	}
+	secret, _, err := repo.MintPublisherToken([]*TrustedPublisher{tp}, "jti-2", -time.Minute, ctx)
+	if err != nil {
+		t.Fatalf("MintPublisherToken failed: %v", err)
+	}
+	if _, _, err := repo.AuthenticateToken(secret, ctx); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
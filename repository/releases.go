@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"go-pip-server/pep440"
+	"slices"
+)
+
+// GetProjectReleases retrieves all releases of a project along with their files,
+// ordered by version following PEP 440.
+func (r *Repository) GetProjectReleases(n string, c context.Context) ([]*Release, error) {
+	pf, err := r.GetProjectFiles(n, c)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.DB.QueryContext(
+		c,
+		"select id, version, created_at, yanked, yanked_reason from releases where project_id = ? order by created_at, id",
+		pf.Project.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := make([]*Release, 0, 16)
+	byId := make(map[int64]*Release)
+	for rows.Next() {
+		var rl Release
+		err := rows.Scan(&rl.ID, &rl.Version, &rl.CreatedAt, &rl.Yanked, &rl.YankedReason)
+		if err != nil {
+			return nil, err
+		}
+		rl.Files = make([]*ProjectFile, 0, 4)
+		byId[rl.ID] = &rl
+		releases = append(releases, &rl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(releases, func(a, b *Release) int {
+		return pep440.Compare(a.Version, b.Version)
+	})
+
+	for _, f := range pf.Files {
+		if rl, ok := byId[f.ReleaseID]; ok {
+			rl.Files = append(rl.Files, f)
+		}
+	}
+	return releases, nil
+}
+
+// GetRelease retrieves a single release of a project along with its files. Versions are
+// matched under PEP 440, so 1.0 finds the release 1.0.0. Returns ErrReleaseNotFound if the
+// project has no such version.
+func (r *Repository) GetRelease(n, version string, c context.Context) (*Release, error) {
+	releases, err := r.GetProjectReleases(n, c)
+	if err != nil {
+		return nil, err
+	}
+	v, err := pep440.Parse(version)
+	for _, rl := range releases {
+		if rl.Version == version {
+			return rl, nil
+		}
+		if rv, rvErr := pep440.Parse(rl.Version); err == nil && rvErr == nil && rv.Equal(v) {
+			return rl, nil
+		}
+	}
+	return nil, ErrReleaseNotFound
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
@@ -8,6 +9,16 @@ import (
 	"strings"
 )
 
+// DataSourceName returns the data source name to open a SQLite database with the options
+// the repository relies on, such as foreign key enforcement for cascading deletes
+func DataSourceName(fp string) string {
+	sep := "?"
+	if strings.Contains(fp, "?") {
+		sep = "&"
+	}
+	return fp + sep + "_pragma=foreign_keys(1)"
+}
+
 // NewRepository creates a new Repository instance
 func NewRepository(db *sql.DB, queriesPath string) (*Repository, error) {
 	info, err := os.Stat(queriesPath)
@@ -48,3 +59,17 @@ func checkQueryDirectory(path string) error {
 	}
 	return nil
 }
+
+// withTx runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise
+func (r *Repository) withTx(c context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.DB.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,6 +1,13 @@
 package repository
 
-import "testing"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 // TestSetup verifies that the database setup function works correctly
 func TestSetup(t *testing.T) {
@@ -11,7 +18,7 @@ func TestSetup(t *testing.T) {
 	}
 
 	// Test that required tables exist
-	reqNames := []string{"projects", "versions", "version_metadata_fields"}
+	reqNames := []string{"projects", "releases", "release_files", "release_metadata_fields"}
 	for _, tableName := range reqNames {
 		var name string
 		err = repo.DB.QueryRow(
@@ -26,3 +33,125 @@ func TestSetup(t *testing.T) {
 		}
 	}
 }
+
+// TestSetupUpgradesLegacySchema verifies that databases created by older versions are
+// upgraded, merging projects whose names collide once normalized and splitting the
+// legacy versions table into releases and files. Files missing from disk are skipped.
+func TestSetupUpgradesLegacySchema(t *testing.T) {
+	repo := getTestRepository()
+	dir := t.TempDir()
+	sdist := filepath.Join(dir, "my.package-1.0.tar.gz")
+	wheel := filepath.Join(dir, "my.package-1.0-py3-none-any.whl")
+	for _, fp := range []string{sdist, wheel} {
+		if err := os.WriteFile(fp, []byte(filepath.Base(fp)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := sha256.Sum256([]byte(filepath.Base(sdist)))
+	sdistDigest := hex.EncodeToString(sum[:])
+	legacy := []string{
+		`create table projects (
+            id integer primary key autoincrement,
+            name nvarchar(256) not null,
+            status nvarchar(32) not null default 'active',
+            created_at datetime default current_timestamp,
+            updated_at datetime default current_timestamp
+        )`,
+		"create unique index idx_project_name on projects (name)",
+		`create table versions (
+            id integer primary key autoincrement,
+            project_id integer not null,
+            version nvarchar(64) not null,
+            digest nvarchar(128) not null,
+            digest_type nvarchar(16) not null,
+            filepath nvarchar(256) not null,
+            file_type nvarchar(16) not null,
+            created_at datetime default current_timestamp,
+            updated_at datetime default current_timestamp
+        )`,
+		`create table version_metadata_fields (
+            id integer primary key autoincrement,
+            version_id integer not null,
+            key nvarchar(255) not null,
+            value nvarchar(1024) not null,
+            created_at datetime default current_timestamp,
+            updated_at datetime default current_timestamp
+        )`,
+		"insert into projects (name) values ('My_Package'), ('other'), ('my.package')",
+		`insert into version_metadata_fields (version_id, key, value)
+         values (1, 'summary', 'A package'), (3, 'requires-python', '>=3.8')`,
+	}
+	for _, stmt := range legacy {
+		if _, err := repo.DB.Exec(stmt); err != nil {
+			t.Fatalf("Error creating legacy schema: %v", err)
+		}
+	}
+	_, err := repo.DB.Exec(
+		`insert into versions (project_id, version, digest, digest_type, filepath, file_type)
+         values
+             (3, '1.0', ?, 'sha256', ?, 'sdist'),
+             (3, '1.0', 'def', 'md5', ?, 'bdist_wheel'),
+             (3, '1.0', 'fed', 'md5', ?, 'bdist_wheel'),
+             (3, '2.0', 'abc', 'md5', ?, 'sdist')`,
+		sdistDigest,
+		sdist,
+		wheel,
+		wheel,
+		filepath.Join(dir, "my.package-2.0.tar.gz"),
+	)
+	if err != nil {
+		t.Fatalf("Error creating legacy versions: %v", err)
+	}
+
+	err = repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	var count int
+	err = repo.DB.QueryRow("select count(*) from projects").Scan(&count)
+	if err != nil {
+		t.Fatalf("Error counting projects: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 projects after merging, got %d", count)
+	}
+
+	p, err := repo.GetProject("my-package", context.Background())
+	if err != nil {
+		t.Fatalf("GetProject failed: %v", err)
+	}
+	if p.ID != 1 || p.Name != "My_Package" {
+		t.Errorf("Expected oldest project to be kept, got %+v", p)
+	}
+
+	releases, err := repo.GetProjectReleases("my-package", context.Background())
+	if err != nil {
+		t.Fatalf("GetProjectReleases failed: %v", err)
+	}
+	if len(releases) != 1 {
+		t.Fatalf("Expected the release without files on disk to be skipped, got %d releases", len(releases))
+	}
+	if len(releases[0].Files) != 2 {
+		t.Fatalf("Expected overwritten file to be dropped, got %d files", len(releases[0].Files))
+	}
+	for _, f := range releases[0].Files {
+		if f.FileType == "bdist_wheel" && (f.MD5Digest != "fed" || f.RequiresPython != ">=3.8") {
+			t.Errorf("Expected latest upload of wheel to be kept, got %+v", f)
+		}
+		if f.FileType == "sdist" && (f.SHA256Digest != sdistDigest || f.Size != int64(len(filepath.Base(sdist)))) {
+			t.Errorf("Expected sdist digest and size to be computed from disk, got %+v", f)
+		}
+	}
+	err = repo.DB.QueryRow("select count(*) from release_files where coalesce(sha256_digest, '') = ''").Scan(&count)
+	if err != nil || count != 0 {
+		t.Errorf("Expected no files without a SHA256 digest, got %d (err: %v)", count, err)
+	}
+
+	for _, table := range []string{"versions", "version_metadata_fields"} {
+		exists, err := repo.tableExists(table, context.Background())
+		if err != nil || exists {
+			t.Errorf("Expected legacy table %s to be dropped (err: %v)", table, err)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+// Role is the role of a user or a group on a project
+type Role string
+
+const (
+	// RoleOwner can do anything with a project, including managing its collaborators
+	RoleOwner Role = "owner"
+	// RoleMaintainer can upload, yank and delete releases and files
+	RoleMaintainer Role = "maintainer"
+	// RoleUploader can upload new releases and files
+	RoleUploader Role = "uploader"
+	// RoleReader can read the project when the index is private
+	RoleReader Role = "reader"
+)
+
+// Permission is an action on a project which requires a role
+type Permission string
+
+const (
+	PermissionRead   Permission = "read"
+	PermissionUpload Permission = "upload"
+	PermissionYank   Permission = "yank"
+	PermissionDelete Permission = "delete"
+	// PermissionManage covers collaborators, the project status and deleting the project
+	PermissionManage Permission = "manage"
+)
+
+// roles lists the roles from the most to the least privileged, with their permissions
+var roles = []struct {
+	Role        Role
+	Permissions []Permission
+}{
+	{RoleOwner, []Permission{PermissionRead, PermissionUpload, PermissionYank, PermissionDelete, PermissionManage}},
+	{RoleMaintainer, []Permission{PermissionRead, PermissionUpload, PermissionYank, PermissionDelete}},
+	{RoleUploader, []Permission{PermissionRead, PermissionUpload}},
+	{RoleReader, []Permission{PermissionRead}},
+}
+
+// CollaboratorUser and CollaboratorGroup are the kinds of collaborators
+const (
+	CollaboratorUser  = "user"
+	CollaboratorGroup = "group"
+)
+
+// ParseRole checks that a string is a known role
+func ParseRole(s string) (Role, error) {
+	for _, r := range roles {
+		if string(r.Role) == s {
+			return r.Role, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidRole, s)
+}
+
+// Can reports whether the role grants a permission. The empty role grants none.
+func (r Role) Can(p Permission) bool {
+	for _, rp := range roles {
+		if rp.Role == r {
+			return slices.Contains(rp.Permissions, p)
+		}
+	}
+	return false
+}
+
+// rank orders roles by privilege, higher is more privileged
+func (r Role) rank() int {
+	for i, rp := range roles {
+		if rp.Role == r {
+			return len(roles) - i
+		}
+	}
+	return 0
+}
+
+// GetCollaborators retrieves the users and groups with a role on a project, users first.
+// Returns ErrProjectNotFound if there is no such project.
+func (r *Repository) GetCollaborators(n string, c context.Context) ([]*Collaborator, error) {
+	p, err := r.GetProject(n, c)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(
+		c,
+		`select case when pr.user_id is not null then 'user' else 'group' end,
+                coalesce(u.username, g.name), pr.role, pr.created_at
+         from project_roles as pr
+         left join users as u on pr.user_id = u.id
+         left join groups as g on pr.group_id = g.id
+         where pr.project_id = ?
+         order by pr.user_id is null, 2`,
+		p.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := make([]*Collaborator, 0, 4)
+	for rows.Next() {
+		var cb Collaborator
+		if err := rows.Scan(&cb.Kind, &cb.Name, &cb.Role, &cb.CreatedAt); err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, &cb)
+	}
+	return collaborators, rows.Err()
+}
+
+// SetCollaboratorRole gives a role on a project to a user or a group, replacing any role
+// it had. Returns ErrLastOwner if this would leave the project without an owner.
+func (r *Repository) SetCollaboratorRole(n, kind, name string, role Role, c context.Context) error {
+	if role.rank() == 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+	p, column, id, err := r.collaboratorTarget(n, kind, name, c)
+	if err != nil {
+		return err
+	}
+	return r.withTx(c, func(tx *sql.Tx) error {
+		if role != RoleOwner {
+			if err := checkLastOwner(tx, p.ID, column, id, c); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(
+			c,
+			fmt.Sprintf(
+				`insert into project_roles (project_id, %[1]s, role) values (?, ?, ?)
+                 on conflict (project_id, %[1]s) do update set role = excluded.role`,
+				column,
+			),
+			p.ID,
+			id,
+			role,
+		)
+		if err != nil {
+			return err
+		}
+		return addJournalEntry(tx, p.NormalizedName, "", "", fmt.Sprintf("set role %s for %s:%s", role, kind, name), c)
+	})
+}
+
+// RemoveCollaborator removes the role of a user or a group on a project. Returns
+// ErrCollaboratorNotFound if it has none, and ErrLastOwner if it is the last owner.
+func (r *Repository) RemoveCollaborator(n, kind, name string, c context.Context) error {
+	p, column, id, err := r.collaboratorTarget(n, kind, name, c)
+	if err != nil {
+		return err
+	}
+	return r.withTx(c, func(tx *sql.Tx) error {
+		if err := checkLastOwner(tx, p.ID, column, id, c); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(
+			c,
+			"delete from project_roles where project_id = ? and "+column+" = ?",
+			p.ID,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return fmt.Errorf("%w: %s:%s", ErrCollaboratorNotFound, kind, name)
+		}
+		return addJournalEntry(tx, p.NormalizedName, "", "", fmt.Sprintf("remove role of %s:%s", kind, name), c)
+	})
+}
+
+// ProjectRole returns the most privileged role of a user on a project, given to the user
+// or to one of the user's groups. Returns an empty role if the user has none.
+func (r *Repository) ProjectRole(n string, u *User, c context.Context) (Role, error) {
+	p, err := r.GetProject(n, c)
+	if err != nil {
+		return "", err
+	}
+	return userRole(r.DB, p.ID, u.ID, c)
+}
+
+// HasPermission checks if a user has a permission on a project through a role
+func (r *Repository) HasPermission(n string, u *User, perm Permission, c context.Context) (bool, error) {
+	role, err := r.ProjectRole(n, u, c)
+	return role.Can(perm), err
+}
+
+// ReadableProjects returns the normalized names of the projects a user can read, through
+// a role given to the user or to one of the user's groups. Every role grants reading.
+func (r *Repository) ReadableProjects(u *User, c context.Context) (map[string]bool, error) {
+	rows, err := r.DB.QueryContext(
+		c,
+		`select distinct p.normalized_name
+         from project_roles as pr
+         join projects as p on pr.project_id = p.id
+         where p.index_id = ?
+             and (pr.user_id = ? or pr.group_id in (select group_id from group_members where user_id = ?))`,
+		IndexID(c),
+		u.ID,
+		u.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(c context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// userRole returns the most privileged role of a user on a project, given directly or
+// through a group
+func userRole(db queryer, projectId, userId int64, c context.Context) (Role, error) {
+	rows, err := db.QueryContext(
+		c,
+		`select role from project_roles
+         where project_id = ?
+           and (user_id = ? or group_id in (select group_id from group_members where user_id = ?))`,
+		projectId,
+		userId,
+		userId,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var best Role
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role); err != nil {
+			return "", err
+		}
+		if role.rank() > best.rank() {
+			best = role
+		}
+	}
+	return best, rows.Err()
+}
+
+// addOwner makes a user the owner of a project, when the user creates it
+func addOwner(tx *sql.Tx, projectId int64, u *User, project string, c context.Context) error {
+	_, err := tx.ExecContext(
+		c,
+		"insert into project_roles (project_id, user_id, role) values (?, ?, ?)",
+		projectId,
+		u.ID,
+		RoleOwner,
+	)
+	if err != nil {
+		return err
+	}
+	return addJournalEntry(tx, project, "", "", "set role owner for user:"+u.Username, c)
+}
+
+// collaboratorTarget looks up the project and the user or group of a role change, and
+// returns the project_roles column and ID which refer to the user or group
+func (r *Repository) collaboratorTarget(n, kind, name string, c context.Context) (*Project, string, int64, error) {
+	p, err := r.GetProject(n, c)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	switch kind {
+	case CollaboratorUser:
+		u, err := r.GetUser(name, c)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		return p, "user_id", u.ID, nil
+	case CollaboratorGroup:
+		g, err := r.GetGroup(name, c)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		return p, "group_id", g.ID, nil
+	default:
+		return nil, "", 0, fmt.Errorf("%w: unknown collaborator kind %q", ErrCollaboratorNotFound, kind)
+	}
+}
+
+// checkLastOwner returns ErrLastOwner if the user or group is the only owner of a project
+func checkLastOwner(tx *sql.Tx, projectId int64, column string, id int64, c context.Context) error {
+	var isOwner bool
+	var owners int
+	err := tx.QueryRowContext(
+		c,
+		`select coalesce(sum(`+column+` = ?), 0) > 0, count(*)
+         from project_roles where project_id = ? and role = ?`,
+		id,
+		projectId,
+		RoleOwner,
+	).Scan(&isOwner, &owners)
+	if err != nil {
+		return err
+	}
+	if isOwner && owners == 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestProjectRoles tests that the first uploader owns a project, and that other users
+// need a role given to them or to their group to upload
+func TestProjectRoles(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	users := make(map[string]*User)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		u, err := repo.CreateUser(name, "correct horse", ctx)
+		if err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+		users[name] = u
+	}
+
+	upload := func(user, filename string) error {
+		return repo.CreateProjectVersion(&ProjectVersionInsert{
+			ProjectName:  "Shared_Lib",
+			Version:      "1.0",
+			Filename:     filename,
+			SHA256Digest: filename,
+			FilePath:     "/data/shared-lib/" + filename,
+			FileType:     "sdist",
+		}, WithUser(ctx, users[user]))
+	}
+	if err := upload("alice", "shared_lib-1.0.tar.gz"); err != nil {
+		t.Fatalf("Expected the first upload to succeed, got %v", err)
+	}
+	if role, _ := repo.ProjectRole("shared-lib", users["alice"], ctx); role != RoleOwner {
+		t.Errorf("Expected alice to own the project, got %q", role)
+	}
+	if err := upload("bob", "shared_lib-1.0.zip"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied for bob, got %v", err)
+	}
+
+	if err := repo.SetCollaboratorRole("shared-lib", CollaboratorUser, "bob", RoleUploader, ctx); err != nil {
+		t.Fatalf("SetCollaboratorRole failed: %v", err)
+	}
+	if err := upload("bob", "shared_lib-1.0.zip"); err != nil {
+		t.Errorf("Expected bob to upload as uploader, got %v", err)
+	}
+	if ok, _ := repo.HasPermission("shared-lib", users["bob"], PermissionYank, ctx); ok {
+		t.Errorf("Expected uploaders not to be able to yank")
+	}
+
+	if _, err := repo.CreateGroup("release-team", ctx); err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+	if err := repo.AddGroupMember("release-team", "carol", ctx); err != nil {
+		t.Fatalf("AddGroupMember failed: %v", err)
+	}
+	if err := repo.SetCollaboratorRole("shared-lib", CollaboratorGroup, "release-team", RoleMaintainer, ctx); err != nil {
+		t.Fatalf("SetCollaboratorRole failed: %v", err)
+	}
+	if ok, _ := repo.HasPermission("shared-lib", users["carol"], PermissionDelete, ctx); !ok {
+		t.Errorf("Expected carol to be a maintainer through her group")
+	}
+	if err := repo.RemoveGroupMember("release-team", "carol", ctx); err != nil {
+		t.Fatalf("RemoveGroupMember failed: %v", err)
+	}
+	if role, _ := repo.ProjectRole("shared-lib", users["carol"], ctx); role != "" {
+		t.Errorf("Expected carol to have no role left, got %q", role)
+	}
+
+	if _, err := repo.GetOrCreateProject("private-lib", WithUser(ctx, users["alice"])); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+	readable, err := repo.ReadableProjects(users["bob"], ctx)
+	if err != nil {
+		t.Fatalf("ReadableProjects failed: %v", err)
+	}
+	if len(readable) != 1 || !readable["shared-lib"] {
+		t.Errorf("Expected bob to only read shared-lib, got %v", readable)
+	}
+
+	collaborators, err := repo.GetCollaborators("shared-lib", ctx)
+	if err != nil {
+		t.Fatalf("GetCollaborators failed: %v", err)
+	}
+	if len(collaborators) != 3 || collaborators[0].Name != "alice" || collaborators[2].Kind != CollaboratorGroup {
+		t.Errorf("Unexpected collaborators %+v", collaborators)
+	}
+}
+
+// TestAnonymousUploads tests that anonymous uploads are only accepted for projects without
+// collaborators, while trusted publishers need no role
+func TestAnonymousUploads(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	alice, err := repo.CreateUser("alice", "correct horse", ctx)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	upload := func(project, filename string, c context.Context) error {
+		return repo.CreateProjectVersion(&ProjectVersionInsert{
+			ProjectName:  project,
+			Version:      "1.0",
+			Filename:     filename,
+			SHA256Digest: filename,
+			FilePath:     "/data/" + project + "/" + filename,
+			FileType:     "sdist",
+		}, c)
+	}
+	if err := upload("unowned", "unowned-1.0.tar.gz", ctx); err != nil {
+		t.Fatalf("Expected the anonymous upload of a new project to succeed, got %v", err)
+	}
+	if err := upload("unowned", "unowned-1.0.zip", ctx); err != nil {
+		t.Errorf("Expected anonymous uploads to projects without collaborators, got %v", err)
+	}
+
+	if err := upload("owned", "owned-1.0.tar.gz", WithUser(ctx, alice)); err != nil {
+		t.Fatalf("Expected alice's upload to succeed, got %v", err)
+	}
+	if err := upload("owned", "owned-1.0.zip", ctx); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied for an anonymous upload to an owned project, got %v", err)
+	}
+	if err := upload("owned", "owned-1.0.zip", WithPublisher(ctx)); err != nil {
+		t.Errorf("Expected trusted publishers to upload to owned projects, got %v", err)
+	}
+}
+
+// TestLastOwner tests that projects cannot be left without an owner
+func TestLastOwner(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	alice, err := repo.CreateUser("alice", "correct horse", ctx)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := repo.CreateUser("bob", "correct horse", ctx); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := repo.GetOrCreateProject("owned", WithUser(ctx, alice)); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+
+	if err := repo.RemoveCollaborator("owned", CollaboratorUser, "alice", ctx); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("Expected ErrLastOwner when removing the owner, got %v", err)
+	}
+	err = repo.SetCollaboratorRole("owned", CollaboratorUser, "alice", RoleReader, ctx)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("Expected ErrLastOwner when demoting the owner, got %v", err)
+	}
+
+	if err := repo.SetCollaboratorRole("owned", CollaboratorUser, "bob", RoleOwner, ctx); err != nil {
+		t.Fatalf("SetCollaboratorRole failed: %v", err)
+	}
+	if err := repo.RemoveCollaborator("owned", CollaboratorUser, "alice", ctx); err != nil {
+		t.Errorf("Expected alice to be removed once bob owns the project, got %v", err)
+	}
+	if err := repo.RemoveCollaborator("owned", CollaboratorUser, "alice", ctx); !errors.Is(err, ErrCollaboratorNotFound) {
+		t.Errorf("Expected ErrCollaboratorNotFound, got %v", err)
+	}
+	if _, err := ParseRole("admin"); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("Expected ErrInvalidRole, got %v", err)
+	}
+}
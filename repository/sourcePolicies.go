@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SourcePolicy decides where the files of a project are served from
+type SourcePolicy string
+
+const (
+	// SourceLocal serves the project from the local index only
+	SourceLocal SourcePolicy = "local"
+	// SourceUpstream serves the project from the upstream index only
+	SourceUpstream SourcePolicy = "upstream"
+	// SourceMerged serves the files of both indexes, local files taking precedence
+	SourceMerged SourcePolicy = "merged"
+)
+
+// ParseSourcePolicy checks that a string is a known source policy
+func ParseSourcePolicy(s string) (SourcePolicy, error) {
+	switch p := SourcePolicy(s); p {
+	case SourceLocal, SourceUpstream, SourceMerged:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidSourcePolicy, s)
+}
+
+// GetSourcePolicy returns the source policy of a project. Projects without a policy are
+// local if the name was ever used locally, even by a deleted project, and upstream
+// otherwise. Only the creation of projects and uploads count as uses, not policy changes.
+func (r *Repository) GetSourcePolicy(n string, c context.Context) (SourcePolicy, error) {
+	var policy SourcePolicy
+	err := r.DB.QueryRowContext(
+		c,
+		`select coalesce(
+             (select policy from source_policies where index_id = ?1 and project_name = ?2),
+             (select 'local' where exists (select 1 from projects where index_id = ?1 and normalized_name = ?2)
+                 or exists (
+                     select 1 from journal_entries
+                     where index_id = ?1 and project_name = ?2
+                         and (action in ('create', 'new release') or action like 'add % file')
+                 )),
+             'upstream'
+         )`,
+		IndexID(c),
+		NormalizeName(n),
+	).Scan(&policy)
+	return policy, err
+}
+
+// GetProjectSource retrieves the explicit source policy of a project. Returns
+// ErrSourcePolicyNotFound if the project has none.
+func (r *Repository) GetProjectSource(n string, c context.Context) (*ProjectSource, error) {
+	ps := ProjectSource{Project: NormalizeName(n)}
+	err := r.DB.QueryRowContext(
+		c,
+		"select policy, created_at from source_policies where index_id = ? and project_name = ?",
+		IndexID(c),
+		ps.Project,
+	).Scan(&ps.Policy, &ps.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrSourcePolicyNotFound, n)
+	} else if err != nil {
+		return nil, err
+	}
+	return &ps, nil
+}
+
+// GetSourcePolicies retrieves the projects with an explicit source policy
+func (r *Repository) GetSourcePolicies(c context.Context) ([]*ProjectSource, error) {
+	rows, err := r.DB.QueryContext(
+		c,
+		"select project_name, policy, created_at from source_policies where index_id = ? order by project_name",
+		IndexID(c),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := make([]*ProjectSource, 0, 16)
+	for rows.Next() {
+		var ps ProjectSource
+		if err := rows.Scan(&ps.Project, &ps.Policy, &ps.CreatedAt); err != nil {
+			return nil, err
+		}
+		sources = append(sources, &ps)
+	}
+	return sources, rows.Err()
+}
+
+// SetSourcePolicy sets the source policy of a project, which does not need to exist
+// locally
+func (r *Repository) SetSourcePolicy(n string, policy SourcePolicy, c context.Context) error {
+	if _, err := ParseSourcePolicy(string(policy)); err != nil {
+		return err
+	}
+	return r.withTx(c, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(
+			c,
+			`insert into source_policies (index_id, project_name, policy) values (?, ?, ?)
+             on conflict (index_id, project_name) do update set policy = excluded.policy`,
+			IndexID(c),
+			NormalizeName(n),
+			policy,
+		)
+		if err != nil {
+			return err
+		}
+		return addJournalEntry(tx, n, "", "", "set source policy "+string(policy), c)
+	})
+}
+
+// ResetSourcePolicy removes the source policy of a project, which then gets the default
+// one. Returns ErrSourcePolicyNotFound if the project has no policy.
+func (r *Repository) ResetSourcePolicy(n string, c context.Context) error {
+	return r.withTx(c, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(
+			c,
+			"delete from source_policies where index_id = ? and project_name = ?",
+			IndexID(c),
+			NormalizeName(n),
+		)
+		if err != nil {
+			return err
+		}
+		if deleted, err := res.RowsAffected(); err != nil {
+			return err
+		} else if deleted == 0 {
+			return fmt.Errorf("%w: %s", ErrSourcePolicyNotFound, n)
+		}
+		return addJournalEntry(tx, n, "", "", "reset source policy", c)
+	})
+}
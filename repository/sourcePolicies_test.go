@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestSourcePolicies tests the default and explicit source policies of projects
+func TestSourcePolicies(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := repo.GetOrCreateProject("Internal_Lib", ctx); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+	if _, err := repo.GetOrCreateProject("purged", ctx); err != nil {
+		t.Fatalf("GetOrCreateProject failed: %v", err)
+	}
+	if err := repo.PurgeProject("purged", ctx); err != nil {
+		t.Fatalf("PurgeProject failed: %v", err)
+	}
+
+	// Names used locally, even by purged projects, are local by default
+	for name, expected := range map[string]SourcePolicy{
+		"internal-lib": SourceLocal,
+		"purged":       SourceLocal,
+		"requests":     SourceUpstream,
+	} {
+		policy, err := repo.GetSourcePolicy(name, ctx)
+		if err != nil || policy != expected {
+			t.Errorf("Expected %s to be %s, got %s (err: %v)", name, expected, policy, err)
+		}
+	}
+
+	if err := repo.SetSourcePolicy("internal.lib", SourceMerged, ctx); err != nil {
+		t.Fatalf("SetSourcePolicy failed: %v", err)
+	}
+	if err := repo.SetSourcePolicy("Requests", SourceLocal, ctx); err != nil {
+		t.Fatalf("SetSourcePolicy failed: %v", err)
+	}
+	if err := repo.SetSourcePolicy("requests", SourcePolicy("both"), ctx); !errors.Is(err, ErrInvalidSourcePolicy) {
+		t.Errorf("Expected ErrInvalidSourcePolicy, got %v", err)
+	}
+	if policy, _ := repo.GetSourcePolicy("internal-lib", ctx); policy != SourceMerged {
+		t.Errorf("Expected internal-lib to be merged, got %s", policy)
+	}
+	ps, err := repo.GetProjectSource("requests", ctx)
+	if err != nil || ps.Policy != SourceLocal || ps.Project != "requests" {
+		t.Errorf("Unexpected source %+v (err: %v)", ps, err)
+	}
+	sources, err := repo.GetSourcePolicies(ctx)
+	if err != nil || len(sources) != 2 || sources[0].Project != "internal-lib" {
+		t.Errorf("Unexpected sources %v (err: %v)", sources, err)
+	}
+
+	if err := repo.ResetSourcePolicy("internal-lib", ctx); err != nil {
+		t.Fatalf("ResetSourcePolicy failed: %v", err)
+	}
+	if policy, _ := repo.GetSourcePolicy("internal-lib", ctx); policy != SourceLocal {
+		t.Errorf("Expected internal-lib to be local again, got %s", policy)
+	}
+	if err := repo.ResetSourcePolicy("internal-lib", ctx); !errors.Is(err, ErrSourcePolicyNotFound) {
+		t.Errorf("Expected ErrSourcePolicyNotFound, got %v", err)
+	}
+	if _, err := repo.GetProjectSource("internal-lib", ctx); !errors.Is(err, ErrSourcePolicyNotFound) {
+		t.Errorf("Expected ErrSourcePolicyNotFound, got %v", err)
+	}
+
+	// Policy changes do not make a name local
+	if err := repo.ResetSourcePolicy("requests", ctx); err != nil {
+		t.Fatalf("ResetSourcePolicy failed: %v", err)
+	}
+	if policy, _ := repo.GetSourcePolicy("requests", ctx); policy != SourceUpstream {
+		t.Errorf("Expected requests to be upstream again, got %s", policy)
+	}
+	if err := repo.SetSourcePolicy("urllib3", SourceUpstream, ctx); err != nil {
+		t.Fatalf("SetSourcePolicy failed: %v", err)
+	}
+	if err := repo.ResetSourcePolicy("urllib3", ctx); err != nil {
+		t.Fatalf("ResetSourcePolicy failed: %v", err)
+	}
+	if policy, _ := repo.GetSourcePolicy("urllib3", ctx); policy != SourceUpstream {
+		t.Errorf("Expected urllib3 to be upstream after a set and reset, got %s", policy)
+	}
+}
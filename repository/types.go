@@ -1,24 +1,204 @@
 package repository
 
-import "database/sql"
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrProjectNotFound is returned when a requested project does not exist.
+var ErrProjectNotFound = errors.New("project not found")
+
+// ErrFileNotFound is returned when a requested distribution file does not exist.
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrSchemaAhead is returned when the database has migrations applied that this
+// version of the server does not know about.
+var ErrSchemaAhead = errors.New("database schema is ahead of the server")
+
+// ErrFileExists is returned when inserting a distribution file whose name was already uploaded.
+var ErrFileExists = errors.New("file already exists")
+
+// ErrProjectNotActive is returned when uploading to a project which is not active.
+var ErrProjectNotActive = errors.New("project does not accept uploads")
+
+// ErrInvalidStatus is returned for an unknown project status.
+var ErrInvalidStatus = errors.New("invalid project status")
+
+// ErrStatusTransition is returned when a project cannot move from its status to the requested one.
+var ErrStatusTransition = errors.New("project status transition not allowed")
+
+// ErrRetentionExpired is returned when restoring a deleted project after its retention window.
+var ErrRetentionExpired = errors.New("deleted project retention window has expired")
+
+// ErrForeignKeysDisabled is returned by deletions when the database connection does not
+// enforce foreign keys, see DataSourceName.
+var ErrForeignKeysDisabled = errors.New("foreign key enforcement is disabled")
+
+// ErrReleaseNotFound is returned when a requested release does not exist.
+var ErrReleaseNotFound = errors.New("release not found")
+
+// ErrUserNotFound is returned when a requested user does not exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserExists is returned when creating a user whose name is already taken.
+var ErrUserExists = errors.New("user already exists")
+
+// ErrInvalidUsername is returned when creating a user with a name that cannot be used.
+var ErrInvalidUsername = errors.New("invalid user name")
+
+// ErrInvalidPassword is returned when setting a password that is too weak.
+var ErrInvalidPassword = errors.New("invalid password")
+
+// ErrInvalidCredentials is returned when authenticating an unknown user or with a wrong password.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrUserDisabled is returned when authenticating a disabled user with the right password.
+var ErrUserDisabled = errors.New("user is disabled")
+
+// ErrTokenNotFound is returned when a requested API token does not exist.
+var ErrTokenNotFound = errors.New("API token not found")
+
+// ErrInvalidToken is returned when issuing an API token with invalid settings.
+var ErrInvalidToken = errors.New("invalid API token")
+
+// ErrTokenExpired is returned when authenticating with an expired API token.
+var ErrTokenExpired = errors.New("API token has expired")
+
+// ErrInvalidRole is returned for an unknown role.
+var ErrInvalidRole = errors.New("invalid role")
+
+// ErrGroupNotFound is returned when a requested group does not exist.
+var ErrGroupNotFound = errors.New("group not found")
+
+// ErrGroupExists is returned when creating a group whose name is already taken.
+var ErrGroupExists = errors.New("group already exists")
+
+// ErrCollaboratorNotFound is returned when a user or group has no role on a project.
+var ErrCollaboratorNotFound = errors.New("collaborator not found")
+
+// ErrLastOwner is returned when a change would leave a project without an owner.
+var ErrLastOwner = errors.New("project would have no owner left")
+
+// ErrPermissionDenied is returned when a user does not have the role required for a change.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ErrInvalidPublisher is returned when registering a trusted publisher with invalid settings.
+var ErrInvalidPublisher = errors.New("invalid trusted publisher")
+
+// ErrPublisherNotFound is returned when a requested trusted publisher does not exist.
+var ErrPublisherNotFound = errors.New("trusted publisher not found")
+
+// ErrTokenReused is returned when exchanging an OIDC token which was already exchanged.
+var ErrTokenReused = errors.New("OIDC token was already used")
+
+// ErrInvalidSourcePolicy is returned for an unknown source policy.
+var ErrInvalidSourcePolicy = errors.New("invalid source policy")
+
+// ErrSourcePolicyNotFound is returned when a project has no explicit source policy.
+var ErrSourcePolicyNotFound = errors.New("source policy not found")
+
+// ErrIndexNotFound is returned when a requested index does not exist.
+var ErrIndexNotFound = errors.New("index not found")
+
+// ErrIndexExists is returned when creating an index whose name is already taken.
+var ErrIndexExists = errors.New("index already exists")
+
+// ErrInvalidIndex is returned when creating or updating an index with invalid settings.
+var ErrInvalidIndex = errors.New("invalid index")
+
+// ErrIndexNotEmpty is returned when deleting an index which still has projects.
+var ErrIndexNotEmpty = errors.New("index is not empty")
+
+// ErrIndexInUse is returned when deleting an index which a virtual index layers.
+var ErrIndexInUse = errors.New("index is used by a virtual index")
 
 // Project represents a project entity in the database.
+// Name is the display name given by the first upload, while NormalizedName
+// is the PEP 503 normalized name used for lookups and URLs.
 type Project struct {
-	ID   int64  `json:"_last-serial"`
-	Name string `json:"name"`
+	ID             int64  `json:"-"`
+	Name           string `json:"name"`
+	NormalizedName string `json:"-"`
+	// LastSerial is the ID of the latest journal entry of the project
+	LastSerial int64 `json:"_last-serial"`
+	// Status is the lifecycle state of the project, see ProjectStatus
+	Status          ProjectStatus `json:"-"`
+	StatusReason    string        `json:"-"`
+	StatusChangedAt *time.Time    `json:"-"`
 }
 
-// AllProjects represents a collection of all projects along with the last serial number.
+// AllProjects represents a collection of all projects along with the last serial number,
+// which is the ID of the latest journal entry.
 // This will be used to return the response for the /simple/ endpoint.
 type AllProjects struct {
 	LastSerial int64
 	Projects   []*Project
 }
 
+// Release represents a version of a project, which groups all the distribution files
+// uploaded for that version.
+type Release struct {
+	ID           int64
+	Version      string
+	CreatedAt    time.Time
+	Yanked       bool
+	YankedReason string
+	Files        []*ProjectFile
+}
+
+// ProjectFile represents a single distribution file uploaded for a release.
+type ProjectFile struct {
+	ID               int64
+	ReleaseID        int64
+	Version          string
+	Filename         string
+	FilePath         string
+	FileType         string
+	PythonTag        string
+	RequiresPython   string
+	Size             int64
+	SHA256Digest     string
+	MD5Digest        string
+	Blake2b256Digest string
+	MetadataDigest   string
+	UploadTime       time.Time
+	// Yanked is true if the file or its release was yanked (PEP 592)
+	Yanked       bool
+	YankedReason string
+}
+
+// ProjectFiles represents a project along with all of its distribution files and the
+// last serial of the project.
+// This will be used to return the response for the /simple/<project>/ endpoint.
+type ProjectFiles struct {
+	Project    *Project
+	LastSerial int64
+	Files      []*ProjectFile
+}
+
+// JournalEntry is a change recorded in the journal, which serves as the audit log.
+type JournalEntry struct {
+	ID          int64
+	ProjectName string
+	Version     string
+	Filename    string
+	Action      string
+	Actor       string
+	CreatedAt   time.Time
+}
+
 // Repository holds the DB connection pool and performs database operations.
 type Repository struct {
 	DB          *sql.DB
 	QueriesPath string
+	// DeletionRetention is how long deleted projects can be restored, zero keeps them forever
+	DeletionRetention time.Duration
+	// AllowLoopbackHTTPIssuers lets trusted publishers use http:// issuers on loopback
+	// addresses, for testing. Other issuers must use https.
+	AllowLoopbackHTTPIssuers bool
+	// authCache remembers recently verified passwords, see Authenticate
+	authCache authCache
 }
 
 // KeyVal represents a key-value pair, used for metadata storage.
@@ -27,17 +207,129 @@ type KeyVal struct {
 	Val string
 }
 
-// ProjectVersionInsert represents a specific version of a project to store in the
-// database.
+// ProjectVersionInsert represents a distribution file of a project version to store in
+// the database. The file is attached to the release of that version, which is created
+// along with its metadata if it does not exist yet.
 type ProjectVersionInsert struct {
-	ProjectName string
-	Version     string
-	Digest      string
-	DigestType  string
-	FilePath    string
-	FileType    string
-	Metadata    []*KeyVal
+	ProjectName      string
+	Version          string
+	Filename         string
+	FilePath         string
+	FileType         string
+	PythonTag        string
+	RequiresPython   string
+	Size             int64
+	SHA256Digest     string
+	MD5Digest        string
+	Blake2b256Digest string
+	// MetadataDigest is the SHA256 digest of the core metadata file extracted next to
+	// the distribution, empty if there is none.
+	MetadataDigest string
+	Metadata       []*KeyVal
+	// BeforeCommit, if set, is called once the rows are inserted and before the transaction
+	// commits, e.g. to move the file into place. An error rolls back the insert.
+	BeforeCommit func() error
+}
+
+// User is an account which authenticates to upload packages. Its password hash is never
+// loaded into this struct.
+type User struct {
+	ID        int64
+	Username  string
+	Disabled  bool
+	CreatedAt time.Time
 }
 
-// ProjectFileMeta represents metadata associated with a project file
-type ProjectFileMeta struct{}
+// APIToken is a revocable token which authenticates uploads as its user, optionally only
+// to some projects. The secret token itself is not stored, only its hash.
+type APIToken struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	// Prefix is the first characters of the token, which tell tokens apart
+	Prefix string `json:"prefix"`
+	// Projects are the normalized names of the projects the token can upload to, or
+	// empty if it can upload to any project of its user
+	Projects []string `json:"projects"`
+	// Index is the name of the index of the projects, empty if the token has no projects
+	Index      string     `json:"index,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Publisher is true for tokens minted for trusted publishers, which have no user
+	Publisher bool `json:"-"`
+	// IndexID is the index the token can upload to, or 0 if it can upload to any index
+	IndexID int64 `json:"-"`
+}
+
+// APITokenInsert holds the settings of a new API token
+type APITokenInsert struct {
+	Name     string   `json:"name"`
+	Projects []string `json:"projects"`
+	// Index is the name of the index of the projects, the index of the context by default
+	Index     string     `json:"index"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Group is a named set of users, which can be given roles on projects
+type Group struct {
+	ID        int64     `json:"-"`
+	Name      string    `json:"name"`
+	Members   []string  `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Collaborator is a user or a group with a role on a project
+type Collaborator struct {
+	// Kind is either CollaboratorUser or CollaboratorGroup
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TrustedPublisher lets CI jobs upload to a project with tokens minted in exchange for an
+// OIDC token of the issuer, for the audience, whose claims match the constraints
+type TrustedPublisher struct {
+	ID       int64  `json:"id"`
+	Project  string `json:"project"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// Claims maps claim names to the values tokens must have, which may contain wildcards
+	Claims    map[string]string `json:"claims"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// TrustedPublisherInsert holds the settings of a new trusted publisher
+type TrustedPublisherInsert struct {
+	Issuer   string            `json:"issuer"`
+	Audience string            `json:"audience"`
+	Claims   map[string]string `json:"claims"`
+}
+
+// ProjectSource is the explicit source policy of a project
+type ProjectSource struct {
+	Project   string       `json:"project"`
+	Policy    SourcePolicy `json:"policy"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// Index is a package index hosted by the server, with its own projects and settings
+type Index struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// StoragePrefix is the directory of the index's files under the data path
+	StoragePrefix string `json:"storage_prefix"`
+	// Private and UploadPolicy follow the server settings if unset
+	Private      *bool        `json:"private,omitempty"`
+	UploadPolicy UploadPolicy `json:"upload_policy,omitempty"`
+	// UpstreamURL is the Simple API of an upstream index serving the missing projects
+	UpstreamURL string `json:"upstream_url,omitempty"`
+	// Layers are the names of the indexes layered by a virtual index, in priority order,
+	// and Resolution is set for virtual indexes only
+	Layers     []string   `json:"layers,omitempty"`
+	Resolution Resolution `json:"resolution,omitempty"`
+	// UploadTarget is the name of the index which uploads to a virtual index go to
+	UploadTarget string    `json:"upload_target,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
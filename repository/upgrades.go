@@ -0,0 +1,354 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// upgradeSchema brings databases created before migrations were tracked up to date
+// before the initial migration runs. Each step is a no-op on up-to-date databases.
+func (r *Repository) upgradeSchema(c context.Context) error {
+	exists, err := r.tableExists("projects", c)
+	if err != nil || !exists {
+		return err
+	}
+
+	hasNormalized, err := r.columnExists("projects", "normalized_name", c)
+	if err != nil {
+		return err
+	}
+	if !hasNormalized {
+		err = r.normalizeProjectNames(c)
+		if err != nil {
+			return fmt.Errorf("error normalizing project names: %w", err)
+		}
+	}
+
+	hasVersions, err := r.tableExists("versions", c)
+	if err != nil {
+		return err
+	}
+	if hasVersions {
+		err = r.addColumnIfMissing("versions", "metadata_digest", "nvarchar(128)", c)
+		if err != nil {
+			return fmt.Errorf("error adding metadata digest column: %w", err)
+		}
+	}
+	return nil
+}
+
+// migrateLegacyData moves data from tables of older versions of the server into the
+// current tables, once the initial migration has run.
+func (r *Repository) migrateLegacyData(c context.Context) error {
+	hasVersions, err := r.tableExists("versions", c)
+	if err != nil || !hasVersions {
+		return err
+	}
+	err = r.migrateVersionsToReleases(c)
+	if err != nil {
+		return fmt.Errorf("error migrating versions to releases: %w", err)
+	}
+	return nil
+}
+
+// legacyVersion is a row of the versions table used before releases and files were split
+type legacyVersion struct {
+	id             int64
+	projectId      int64
+	version        string
+	digest         string
+	digestType     string
+	filePath       string
+	fileType       string
+	metadataDigest sql.NullString
+	createdAt      time.Time
+}
+
+// migrateVersionsToReleases splits the rows of the legacy versions table, which held one
+// file per row, into releases and release files, then drops the legacy tables. When the
+// same file name was uploaded more than once, only the latest upload is kept, as it is
+// the one on disk. Files which cannot be read from disk are skipped, as their SHA256
+// digest cannot be computed.
+func (r *Repository) migrateVersionsToReleases(c context.Context) error {
+	tx, err := r.DB.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		c,
+		`select id, project_id, version, digest, digest_type, filepath, file_type, metadata_digest, created_at
+         from versions
+         order by id desc`,
+	)
+	if err != nil {
+		return err
+	}
+	legacy := make([]*legacyVersion, 0, 64)
+	for rows.Next() {
+		var v legacyVersion
+		err := rows.Scan(
+			&v.id, &v.projectId, &v.version, &v.digest, &v.digestType,
+			&v.filePath, &v.fileType, &v.metadataDigest, &v.createdAt,
+		)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, &v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	migrated := 0
+	for _, v := range legacy {
+		filename := filepath.Base(v.filePath)
+		if seen[filename] {
+			slog.Warn("Skipping overwritten legacy file", "file", v.filePath, "version_id", v.id)
+			continue
+		}
+		seen[filename] = true
+
+		size, sha256Digest, err := hashLegacyFile(v.filePath)
+		if err != nil {
+			slog.Warn("Skipping legacy file which cannot be read", "file", v.filePath, "version_id", v.id, "error", err)
+			continue
+		}
+		if strings.EqualFold(v.digestType, "sha256") && !strings.EqualFold(v.digest, sha256Digest) {
+			slog.Warn("Legacy file does not match its recorded digest", "file", v.filePath, "version_id", v.id)
+		}
+
+		releaseId, created, err := legacyRelease(tx, v, c)
+		if err != nil {
+			return err
+		}
+		err = insertLegacyFile(tx, releaseId, filename, size, sha256Digest, v, c)
+		if err != nil {
+			return err
+		}
+		migrated++
+		if created {
+			_, err = tx.ExecContext(
+				c,
+				`insert into release_metadata_fields (release_id, key, value, created_at, updated_at)
+                 select ?, key, value, created_at, updated_at
+                 from version_metadata_fields
+                 where version_id = ?
+                 order by id`,
+				releaseId,
+				v.id,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Releases were created with the time of their latest file, use the earliest instead
+	_, err = tx.ExecContext(
+		c,
+		`update releases set created_at = (
+             select min(f.upload_time) from release_files as f where f.release_id = releases.id
+         )
+         where exists (select 1 from release_files as f where f.release_id = releases.id)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{"drop table if exists version_metadata_fields", "drop table versions"} {
+		if _, err := tx.ExecContext(c, stmt); err != nil {
+			return err
+		}
+	}
+	slog.Info("Migrated legacy versions to releases", "files", migrated)
+	return tx.Commit()
+}
+
+// legacyRelease gets or creates the release for a legacy version row. Reports whether the
+// release was created.
+func legacyRelease(tx *sql.Tx, v *legacyVersion, c context.Context) (int64, bool, error) {
+	res, err := tx.ExecContext(
+		c,
+		"insert into releases (project_id, version, created_at) values (?, ?, ?) on conflict do nothing",
+		v.projectId,
+		v.version,
+		v.createdAt.UTC().Format(SQLiteTimeFormat),
+	)
+	if err != nil {
+		return 0, false, err
+	}
+	created, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(
+		c,
+		"select id from releases where project_id = ? and version = ?",
+		v.projectId,
+		v.version,
+	).Scan(&id)
+	return id, created > 0, err
+}
+
+// hashLegacyFile returns the size and SHA256 digest of a legacy file on disk
+func hashLegacyFile(fp string) (int64, string, error) {
+	fh, err := os.Open(fp)
+	if err != nil {
+		return 0, "", err
+	}
+	defer fh.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, fh)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// insertLegacyFile inserts the release file for a legacy version row, with the size and
+// SHA256 digest of the file on disk
+func insertLegacyFile(tx *sql.Tx, releaseId int64, filename string, size int64, sha256Digest string, v *legacyVersion, c context.Context) error {
+	digests := map[string]string{}
+	digests[strings.ToLower(v.digestType)] = v.digest
+	digests["sha256"] = sha256Digest
+
+	var requiresPython string
+	err := tx.QueryRowContext(
+		c,
+		`select coalesce(max(value), '') from version_metadata_fields
+         where version_id = ? and key = 'requires-python'`,
+		v.id,
+	).Scan(&requiresPython)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		c,
+		`insert into release_files (
+             release_id, filename, filepath, file_type, requires_python, size, sha256_digest,
+             md5_digest, blake2_256_digest, metadata_digest, upload_time, created_at
+         ) values (?, ?, ?, ?, ?, ?, ?, nullif(?, ''), nullif(?, ''), ?, ?, ?)`,
+		releaseId,
+		filename,
+		v.filePath,
+		v.fileType,
+		requiresPython,
+		size,
+		digests["sha256"],
+		digests["md5"],
+		digests["blake2_256"],
+		v.metadataDigest,
+		v.createdAt.UTC().Format(SQLiteTimeFormat),
+		v.createdAt.UTC().Format(SQLiteTimeFormat),
+	)
+	return err
+}
+
+// addColumnIfMissing adds a nullable column to an existing table unless it is already present
+func (r *Repository) addColumnIfMissing(table, column, definition string, c context.Context) error {
+	exists, err := r.columnExists(table, column, c)
+	if err != nil || exists {
+		return err
+	}
+	_, err = r.DB.ExecContext(c, fmt.Sprintf("alter table %s add column %s %s", table, column, definition))
+	return err
+}
+
+// tableExists checks whether a table exists in the database
+func (r *Repository) tableExists(table string, c context.Context) (bool, error) {
+	var n int
+	err := r.DB.QueryRowContext(
+		c,
+		"select count(*) from sqlite_master where type = 'table' and name = ?",
+		table,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// columnExists checks whether a table has a given column
+func (r *Repository) columnExists(table, column string, c context.Context) (bool, error) {
+	var n int
+	err := r.DB.QueryRowContext(
+		c,
+		"select count(*) from pragma_table_info(?) where name = ?",
+		table,
+		column,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// normalizeProjectNames adds the normalized_name column to the projects table and fills it.
+// Projects whose names collide once normalized are merged into the oldest one, which keeps
+// its display name, and the versions of the others are moved to it.
+func (r *Repository) normalizeProjectNames(c context.Context) error {
+	tx, err := r.DB.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(c, "alter table projects add column normalized_name nvarchar(256)")
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(c, "select id, name from projects order by id")
+	if err != nil {
+		return err
+	}
+	canonical := make(map[string]int64)
+	merges := make(map[int64]int64)
+	for rows.Next() {
+		var p Project
+		if err := rows.Scan(&p.ID, &p.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		norm := NormalizeName(p.Name)
+		if id, ok := canonical[norm]; ok {
+			merges[p.ID] = id
+		} else {
+			canonical[norm] = p.ID
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for dup, id := range merges {
+		slog.Warn("Merging project with colliding normalized name", "from", dup, "into", id)
+		_, err = tx.ExecContext(c, "update versions set project_id = ? where project_id = ?", id, dup)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(c, "delete from projects where id = ?", dup)
+		if err != nil {
+			return err
+		}
+	}
+	for norm, id := range canonical {
+		_, err = tx.ExecContext(c, "update projects set normalized_name = ? where id = ?", norm, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
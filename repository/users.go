@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MinPasswordLength is the minimum number of characters of user passwords
+const MinPasswordLength = 8
+
+// usernamePattern matches valid user names. Colons are excluded as they separate the
+// user name from the password in HTTP Basic credentials.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// CreateUser creates a user with a password. Returns ErrUserExists if the user name is
+// taken, ignoring case.
+func (r *Repository) CreateUser(username, password string, c context.Context) (*User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidUsername, username)
+	}
+	hash, err := newPasswordHash(password)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.DB.ExecContext(c, "insert into users (username, password_hash) values (?, ?)", username, hash)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%w: %s", ErrUserExists, username)
+	} else if err != nil {
+		return nil, err
+	}
+	return r.GetUser(username, c)
+}
+
+// GetUser retrieves a user by name, ignoring case. Returns ErrUserNotFound if there is
+// no such user.
+func (r *Repository) GetUser(username string, c context.Context) (*User, error) {
+	u, _, err := r.getUserWithHash(username, c)
+	return u, err
+}
+
+// GetAllUsers retrieves all users, ordered by name
+func (r *Repository) GetAllUsers(c context.Context) ([]*User, error) {
+	rows, err := r.DB.QueryContext(c, "select "+userColumns+" from users order by username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*User, 0, 8)
+	for rows.Next() {
+		var u User
+		var hash string
+		if err := rows.Scan(&u.ID, &u.Username, &hash, &u.Disabled, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+	return users, rows.Err()
+}
+
+// SetUserPassword replaces the password of a user. Returns ErrUserNotFound if there is
+// no such user.
+func (r *Repository) SetUserPassword(username, password string, c context.Context) error {
+	hash, err := newPasswordHash(password)
+	if err != nil {
+		return err
+	}
+	err = r.updateUser(
+		c,
+		"update users set password_hash = ?, updated_at = current_timestamp where username = ?",
+		hash,
+		username,
+	)
+	r.authCache.forget(username)
+	return err
+}
+
+// SetUserDisabled disables or enables a user. Disabled users cannot authenticate, but
+// are kept so that their name is not reused. Returns ErrUserNotFound if there is no
+// such user.
+func (r *Repository) SetUserDisabled(username string, disabled bool, c context.Context) error {
+	err := r.updateUser(
+		c,
+		"update users set disabled = ?, updated_at = current_timestamp where username = ?",
+		disabled,
+		username,
+	)
+	r.authCache.forget(username)
+	return err
+}
+
+// authCacheTTL is how long a verified password is remembered
+const authCacheTTL = 5 * time.Minute
+
+// authCache remembers recently verified passwords. Entries are keyed by an HMAC of the
+// user and password under a random key of the process, so that memory holds nothing
+// faster to brute-force than the stored argon2id hashes.
+type authCache struct {
+	mu        sync.Mutex
+	key       []byte
+	entries   map[string]authCacheEntry
+	lastPrune time.Time
+}
+
+// authCacheEntry is a verified password, valid as long as the stored hash is unchanged
+type authCacheEntry struct {
+	Username string
+	Hash     string
+	Expires  time.Time
+}
+
+// entryKey returns the key of the password of a user, generating the HMAC key on first use
+func (a *authCache) entryKey(u *User, password string) (string, error) {
+	if a.key == nil {
+		a.key = make([]byte, 32)
+		if _, err := rand.Read(a.key); err != nil {
+			a.key = nil
+			return "", err
+		}
+		a.entries = make(map[string]authCacheEntry)
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(strconv.FormatInt(u.ID, 10) + "\x00" + password))
+	return string(mac.Sum(nil)), nil
+}
+
+// verified reports whether the password of a user was verified against its current hash
+// recently
+func (a *authCache) verified(u *User, password, hash string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key, err := a.entryKey(u, password)
+	if err != nil {
+		return false
+	}
+	entry, ok := a.entries[key]
+	return ok && entry.Hash == hash && time.Now().Before(entry.Expires)
+}
+
+// remember records that the password of a user matches its current hash, and prunes
+// expired entries at most once per authCacheTTL
+func (a *authCache) remember(u *User, password, hash string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key, err := a.entryKey(u, password)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(a.lastPrune) >= authCacheTTL {
+		for k, entry := range a.entries {
+			if now.After(entry.Expires) {
+				delete(a.entries, k)
+			}
+		}
+		a.lastPrune = now
+	}
+	a.entries[key] = authCacheEntry{Username: u.Username, Hash: hash, Expires: now.Add(authCacheTTL)}
+}
+
+// forget drops the verified passwords of a user, ignoring case
+func (a *authCache) forget(username string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, entry := range a.entries {
+		if strings.EqualFold(entry.Username, username) {
+			delete(a.entries, k)
+		}
+	}
+}
+
+// Authenticate checks the credentials of a user. Returns ErrInvalidCredentials if the
+// user does not exist or the password is wrong, and ErrUserDisabled if the credentials
+// are right but the user is disabled. Verified passwords are remembered for a few
+// minutes, as installers send the credentials with each of their many requests and
+// hashing is slow by design.
+func (r *Repository) Authenticate(username, password string, c context.Context) (*User, error) {
+	u, hash, err := r.getUserWithHash(username, c)
+	if errors.Is(err, ErrUserNotFound) {
+		verifyPassword(password, dummyHash())
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !r.authCache.verified(u, password, hash) {
+		valid, err := verifyPassword(password, hash)
+		if err != nil {
+			return nil, fmt.Errorf("error verifying password of user %s: %w", u.Username, err)
+		}
+		if !valid {
+			return nil, ErrInvalidCredentials
+		}
+		r.authCache.remember(u, password, hash)
+	}
+	if u.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return u, nil
+}
+
+// userColumns lists the columns scanned into a User and its password hash
+const userColumns = "id, username, password_hash, disabled, created_at"
+
+// getUserWithHash retrieves a user along with its password hash
+func (r *Repository) getUserWithHash(username string, c context.Context) (*User, string, error) {
+	var u User
+	var hash string
+	err := r.DB.QueryRowContext(c, "select "+userColumns+" from users where username = ?", username).
+		Scan(&u.ID, &u.Username, &hash, &u.Disabled, &u.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	} else if err != nil {
+		return nil, "", err
+	}
+	return &u, hash, nil
+}
+
+// updateUser runs an update of a single user, whose name is the last argument
+func (r *Repository) updateUser(c context.Context, query string, args ...any) error {
+	res, err := r.DB.ExecContext(c, query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, args[len(args)-1])
+	}
+	return nil
+}
+
+// newPasswordHash checks that a password is long enough and hashes it
+func newPasswordHash(password string) (string, error) {
+	if len([]rune(password)) < MinPasswordLength {
+		return "", fmt.Errorf("%w: use at least %d characters", ErrInvalidPassword, MinPasswordLength)
+	}
+	return hashPassword(password)
+}
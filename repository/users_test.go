@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestUserAuthentication tests creating users and authenticating them, including with
+// a reset password and while disabled
+func TestUserAuthentication(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+
+	u, err := repo.CreateUser("ci-bot", "correct horse", ctx)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if u.Username != "ci-bot" || u.Disabled {
+		t.Errorf("Unexpected user %+v", u)
+	}
+	if _, err := repo.CreateUser("CI-Bot", "another password", ctx); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists for a name differing in case, got %v", err)
+	}
+	if _, err := repo.CreateUser("ci:bot", "correct horse", ctx); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("Expected ErrInvalidUsername, got %v", err)
+	}
+	if _, err := repo.CreateUser("short", "secret", ctx); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Expected ErrInvalidPassword, got %v", err)
+	}
+
+	if u, err := repo.Authenticate("CI-BOT", "correct horse", ctx); err != nil || u.Username != "ci-bot" {
+		t.Errorf("Expected to authenticate ci-bot, got %+v, %v", u, err)
+	}
+	if _, err := repo.Authenticate("ci-bot", "wrong horse", ctx); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for a wrong password, got %v", err)
+	}
+	if _, err := repo.Authenticate("nobody", "correct horse", ctx); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for an unknown user, got %v", err)
+	}
+
+	if err := repo.SetUserPassword("ci-bot", "battery staple", ctx); err != nil {
+		t.Fatalf("SetUserPassword failed: %v", err)
+	}
+	// The old password was verified before, it must not be remembered past the reset
+	if _, err := repo.Authenticate("ci-bot", "correct horse", ctx); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected the old password to be rejected, got %v", err)
+	}
+	if err := repo.SetUserDisabled("ci-bot", true, ctx); err != nil {
+		t.Fatalf("SetUserDisabled failed: %v", err)
+	}
+	if _, err := repo.Authenticate("ci-bot", "battery staple", ctx); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("Expected ErrUserDisabled, got %v", err)
+	}
+	if err := repo.SetUserDisabled("nobody", true, ctx); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+// TestAuthCache tests that verified passwords are remembered under a keyed hash, pruned
+// once expired and forgotten when the user changes
+func TestAuthCache(t *testing.T) {
+	repo := getTestRepository()
+	if err := repo.SetUpDB(); err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{"ci-bot", "other"} {
+		if _, err := repo.CreateUser(name, "correct horse", ctx); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+		if _, err := repo.Authenticate(name, "correct horse", ctx); err != nil {
+			t.Fatalf("Authenticate failed: %v", err)
+		}
+	}
+	if len(repo.authCache.entries) != 2 {
+		t.Fatalf("Expected both passwords to be remembered, got %d entries", len(repo.authCache.entries))
+	}
+	unkeyed := sha256.Sum256([]byte("1\x00correct horse"))
+	if _, ok := repo.authCache.entries[string(unkeyed[:])]; ok {
+		t.Errorf("Expected entries to be keyed by an HMAC")
+	}
+
+	if err := repo.SetUserDisabled("CI-Bot", true, ctx); err != nil {
+		t.Fatalf("SetUserDisabled failed: %v", err)
+	}
+	if len(repo.authCache.entries) != 1 {
+		t.Errorf("Expected the password of the disabled user to be forgotten, got %d entries", len(repo.authCache.entries))
+	}
+
+	for k, entry := range repo.authCache.entries {
+		entry.Expires = time.Now().Add(-time.Second)
+		repo.authCache.entries[k] = entry
+	}
+	repo.authCache.lastPrune = time.Time{}
+	if err := repo.SetUserDisabled("ci-bot", false, ctx); err != nil {
+		t.Fatalf("SetUserDisabled failed: %v", err)
+	}
+	if _, err := repo.Authenticate("ci-bot", "correct horse", ctx); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if len(repo.authCache.entries) != 1 {
+		t.Errorf("Expected expired entries to be pruned, got %d entries", len(repo.authCache.entries))
+	}
+}
+
+// TestPasswordHash tests that password hashes are salted and verified
+func TestPasswordHash(t *testing.T) {
+	h1, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hashPassword failed: %v", err)
+	}
+	h2, _ := hashPassword("correct horse")
+	if h1 == h2 || !strings.HasPrefix(h1, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("Expected distinct argon2id hashes, got %s and %s", h1, h2)
+	}
+	if ok, err := verifyPassword("correct horse", h1); !ok || err != nil {
+		t.Errorf("Expected the password to match, got %v, %v", ok, err)
+	}
+	if ok, _ := verifyPassword("correct horsE", h1); ok {
+		t.Errorf("Expected a different password not to match")
+	}
+	if _, err := verifyPassword("correct horse", "$2b$10$abc"); !errors.Is(err, errMalformedHash) {
+		t.Errorf("Expected errMalformedHash, got %v", err)
+	}
+}
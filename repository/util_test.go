@@ -9,7 +9,7 @@ import (
 
 // getTestRepository sets up an in-memory SQLite database and returns a Repository instance for testing
 func getTestRepository() *Repository {
-	db, err := sql.Open("sqlite", ":memory:")
+	db, err := sql.Open("sqlite", DataSourceName(":memory:"))
 	if err != nil {
 		panic(err)
 	}
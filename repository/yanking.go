@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// YankRelease marks a release as yanked (PEP 592), with an optional reason. Its files
+// stay available, but installers ignore them unless the version is pinned exactly.
+// Returns ErrReleaseNotFound if the project has no such version.
+func (r *Repository) YankRelease(n, version, reason string, c context.Context) error {
+	return r.setReleaseYanked(n, version, true, reason, c)
+}
+
+// UnyankRelease reverts the yanking of a release. Files which were yanked on their own
+// stay yanked. Returns ErrReleaseNotFound if the project has no such version.
+func (r *Repository) UnyankRelease(n, version string, c context.Context) error {
+	return r.setReleaseYanked(n, version, false, "", c)
+}
+
+// YankFile marks a single distribution file as yanked (PEP 592), with an optional reason.
+// Returns ErrFileNotFound if the project has no such file.
+func (r *Repository) YankFile(n, filename, reason string, c context.Context) error {
+	return r.setFileYanked(n, filename, true, reason, c)
+}
+
+// UnyankFile reverts the yanking of a single file. The file stays yanked if its release
+// is. Returns ErrFileNotFound if the project has no such file.
+func (r *Repository) UnyankFile(n, filename string, c context.Context) error {
+	return r.setFileYanked(n, filename, false, "", c)
+}
+
+// setReleaseYanked updates the yanked state of a release
+func (r *Repository) setReleaseYanked(n, version string, yanked bool, reason string, c context.Context) error {
+	rl, err := r.GetRelease(n, version, c)
+	if err != nil {
+		return err
+	}
+	return r.withTx(c, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(
+			c,
+			"update releases set yanked = ?, yanked_reason = ?, updated_at = current_timestamp where id = ?",
+			yanked,
+			reason,
+			rl.ID,
+		)
+		if err != nil {
+			return err
+		}
+		return addJournalEntry(tx, n, rl.Version, "", yankAction(yanked, "release", reason), c)
+	})
+}
+
+// setFileYanked updates the yanked state of a distribution file
+func (r *Repository) setFileYanked(n, filename string, yanked bool, reason string, c context.Context) error {
+	f, err := r.GetProjectFile(n, filename, c)
+	if err != nil {
+		return err
+	}
+	return r.withTx(c, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(
+			c,
+			"update release_files set yanked = ?, yanked_reason = ?, updated_at = current_timestamp where id = ?",
+			yanked,
+			reason,
+			f.ID,
+		)
+		if err != nil {
+			return err
+		}
+		return addJournalEntry(tx, n, f.Version, f.Filename, yankAction(yanked, "file", reason), c)
+	})
+}
+
+// yankAction describes a yank in the journal
+func yankAction(yanked bool, target, reason string) string {
+	if !yanked {
+		return "unyank " + target
+	}
+	return withReason("yank "+target, reason)
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestYanking tests yanking and unyanking of releases and single files
+func TestYanking(t *testing.T) {
+	repo := getTestRepository()
+	err := repo.SetUpDB()
+	if err != nil {
+		t.Fatalf("SetUpDB failed: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, fn := range []string{"yanky-1.0.tar.gz", "yanky-1.0-py3-none-any.whl"} {
+		pvi := &ProjectVersionInsert{
+			ProjectName:  "yanky",
+			Version:      "1.0",
+			Filename:     fn,
+			SHA256Digest: fn,
+			FilePath:     "/data/yanky/" + fn,
+			FileType:     "sdist",
+		}
+		if err := repo.CreateProjectVersion(pvi, ctx); err != nil {
+			t.Fatalf("CreateProjectVersion failed: %v", err)
+		}
+	}
+
+	// yanked returns the yanked state and reason of each file
+	yanked := func() map[string]string {
+		pf, err := repo.GetProjectFiles("yanky", ctx)
+		if err != nil {
+			t.Fatalf("GetProjectFiles failed: %v", err)
+		}
+		states := make(map[string]string)
+		for _, f := range pf.Files {
+			if f.Yanked {
+				states[f.Filename] = "yanked:" + f.YankedReason
+			}
+		}
+		return states
+	}
+
+	if err := repo.YankFile("yanky", "yanky-1.0.tar.gz", "", ctx); err != nil {
+		t.Fatalf("YankFile failed: %v", err)
+	}
+	states := yanked()
+	if len(states) != 1 || states["yanky-1.0.tar.gz"] != "yanked:" {
+		t.Errorf("Expected only the sdist to be yanked, got %v", states)
+	}
+
+	if err := repo.YankRelease("yanky", "1.0.0", "Broken build", ctx); err != nil {
+		t.Fatalf("YankRelease failed: %v", err)
+	}
+	states = yanked()
+	if len(states) != 2 || states["yanky-1.0-py3-none-any.whl"] != "yanked:Broken build" {
+		t.Errorf("Expected all files to be yanked with the release reason, got %v", states)
+	}
+	rl, err := repo.GetRelease("yanky", "1.0", ctx)
+	if err != nil || !rl.Yanked || rl.YankedReason != "Broken build" {
+		t.Errorf("Expected release to be yanked, got %+v (err: %v)", rl, err)
+	}
+
+	if err := repo.UnyankRelease("yanky", "1.0", ctx); err != nil {
+		t.Fatalf("UnyankRelease failed: %v", err)
+	}
+	states = yanked()
+	if len(states) != 1 || states["yanky-1.0.tar.gz"] == "" {
+		t.Errorf("Expected the file yanked on its own to stay yanked, got %v", states)
+	}
+	if err := repo.UnyankFile("yanky", "yanky-1.0.tar.gz", ctx); err != nil {
+		t.Fatalf("UnyankFile failed: %v", err)
+	}
+	if states = yanked(); len(states) != 0 {
+		t.Errorf("Expected no yanked files, got %v", states)
+	}
+
+	if err := repo.YankRelease("yanky", "2.0", "", ctx); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("Expected ErrReleaseNotFound, got %v", err)
+	}
+	if err := repo.YankFile("yanky", "missing.tar.gz", "", ctx); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+}
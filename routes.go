@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"go-pip-server/repository"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 )
 
@@ -20,57 +26,423 @@ type SimpleIdxResponse struct {
 	Projects []*repository.Project `json:"projects"`
 }
 
-// HandleUpload parses multipart form data from an HTTP request to upload a package.
+// SimpleFile Describes a single distribution file in a project page
+type SimpleFile struct {
+	Filename       string            `json:"filename"`
+	URL            string            `json:"url"`
+	Hashes         map[string]string `json:"hashes"`
+	RequiresPython string            `json:"requires-python,omitempty"`
+	UploadTime     string            `json:"upload-time,omitempty"`
+	Size           int64             `json:"size"`
+	// CoreMetadata advertises the metadata file of the distribution (PEP 658 / PEP 714).
+	// DistInfoMetadata is its pre-PEP 714 name, kept for older clients.
+	CoreMetadata     map[string]string `json:"core-metadata,omitempty"`
+	DistInfoMetadata map[string]string `json:"dist-info-metadata,omitempty"`
+	// Yanked is the reason for yanked files, or true if none was given (PEP 592)
+	Yanked       any    `json:"yanked,omitempty"`
+	YankedReason string `json:"-"`
+}
+
+// ProjectStatusInfo Describes the status of a project (PEP 792)
+type ProjectStatusInfo struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// pep792Statuses are the project statuses of PEP 792, which are the only ones shown to clients
+var pep792Statuses = []string{"active", "archived", "quarantined", "deprecated"}
+
+// projectStatusInfo returns the status of a project for clients, or nil for statuses
+// outside PEP 792
+func projectStatusInfo(status, reason string) *ProjectStatusInfo {
+	if !slices.Contains(pep792Statuses, status) {
+		return nil
+	}
+	return &ProjectStatusInfo{Status: status, Reason: reason}
+}
+
+// SimpleProjectResponse Returned by the /simple/<project>/ endpoint
+type SimpleProjectResponse struct {
+	Metadata APIMeta       `json:"meta"`
+	Name     string        `json:"name"`
+	Files    []*SimpleFile `json:"files"`
+	Versions []string      `json:"versions"`
+	// ProjectStatus is only understood by clients of API version 1.4 and later
+	ProjectStatus *ProjectStatusInfo `json:"project-status,omitempty"`
+	// Tracks and AlternateLocations tell installers which other indexes serve the same
+	// project, and whose files they may merge (PEP 708)
+	Tracks             []string `json:"tracks,omitempty"`
+	AlternateLocations []string `json:"alternate-locations,omitempty"`
+}
+
+// HandleUpload handles uploads through the legacy upload API used by twine. It parses
+// multipart form data from an HTTP request and stores the uploaded package.
 func (p *PipServer) HandleUpload(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseMultipartForm(32 << 20)
+	if r.ContentLength > p.MaxUploadSize {
+		http.Error(w, tooLarge(p.MaxUploadSize).Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, p.MaxUploadSize)
+	mr, err := r.MultipartReader()
 	if err != nil {
-		http.Error(w, `{"detail": "Invalid form data"}`, http.StatusBadRequest)
+		http.Error(w, "Invalid form data.", http.StatusBadRequest)
 		return
 	}
 
-	pvi, err := p.PrepareFormData(r.MultipartForm)
-	if err != nil {
+	up, err := p.PrepareFormData(mr, requestIndex(r.Context()).DataPath)
+	var uErr *uploadError
+	if errors.As(err, &uErr) {
+		slog.Warn("Rejected upload", "status", uErr.Status, "error", uErr.Message)
+		http.Error(w, uErr.Message, uErr.Status)
+		return
+	} else if err != nil {
 		slog.Error("Error preparing form data", "error", err)
-		http.Error(w, `{"detail": "Invalid form data"}`, http.StatusBadRequest)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer up.Discard()
+
+	// API tokens can be limited to some projects
+	if token := requestToken(r.Context()); token != nil && !token.Allows(requestIndex(r.Context()).ID, up.Insert.ProjectName) {
+		msg := fmt.Sprintf("API token is not allowed to upload to project '%s'.", up.Insert.ProjectName)
+		slog.Warn("Rejected upload", "status", http.StatusForbidden, "error", msg)
+		http.Error(w, msg, http.StatusForbidden)
+		return
+	}
+
+	// Projects served from the upstream index only do not accept uploads
+	if requestIndex(r.Context()).Upstream != nil {
+		ps, err := p.Repo.GetProjectSource(up.Insert.ProjectName, r.Context())
+		if err == nil && ps.Policy == repository.SourceUpstream {
+			msg := fmt.Sprintf("Project '%s' is served from the upstream index and does not accept uploads.", up.Insert.ProjectName)
+			slog.Warn("Rejected upload", "status", http.StatusBadRequest, "error", msg)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		} else if err != nil && !errors.Is(err, repository.ErrSourcePolicyNotFound) {
+			slog.Error("Error fetching source policy", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Only active projects accept uploads
+	proj, err := p.Repo.GetProject(up.Insert.ProjectName, r.Context())
+	if err == nil && proj.Status != repository.ProjectActive {
+		projectNotActive(w, proj)
+		return
+	} else if err != nil && !errors.Is(err, repository.ErrProjectNotFound) {
+		slog.Error("Error looking up project", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	err = p.Repo.CreateProjectVersion(pvi, r.Context())
+	// File names can only be used once, like on PyPI
+	existing, err := p.Repo.FindFile(up.Insert.Filename, r.Context())
+	if err == nil {
+		if p.AllowIdenticalReupload && existing.SHA256Digest == up.Insert.SHA256Digest {
+			slog.Info("Ignoring identical re-upload", "file", existing.Filename)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		fileExists(w, up.Insert)
+		return
+	} else if !errors.Is(err, repository.ErrFileNotFound) {
+		slog.Error("Error looking up existing file", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Names of deleted files cannot be reused either, installers may have cached them
+	used, err := p.Repo.FileNameUsed(up.Insert.Filename, r.Context())
 	if err != nil {
+		slog.Error("Error looking up file name", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	} else if used {
+		msg := "This filename has already been used, use a different version."
+		slog.Warn("Rejected upload", "status", http.StatusBadRequest, "error", msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = p.Repo.CreateProjectVersion(up.Insert, r.Context())
+	if errors.Is(err, repository.ErrFileExists) {
+		fileExists(w, up.Insert)
+		return
+	} else if errors.Is(err, repository.ErrPermissionDenied) {
+		msg := fmt.Sprintf("Anonymous uploads to project '%s' aren't allowed.", up.Insert.ProjectName)
+		if u := requestUser(r.Context()); u != nil {
+			msg = fmt.Sprintf("The user '%s' isn't allowed to upload to project '%s'.", u.Username, up.Insert.ProjectName)
+		}
+		slog.Warn("Rejected upload", "status", http.StatusForbidden, "error", msg)
+		http.Error(w, msg, http.StatusForbidden)
+		return
+	} else if errors.Is(err, repository.ErrProjectNotActive) {
+		// The project status changed during the upload
+		http.Error(w, "Project does not accept uploads.", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		up.Revert()
 		slog.Error("Error inserting project version", "error", err)
-		http.Error(w, `{"detail": "Internal Server Error"}`, http.StatusInternalServerError)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
+}
 
+// fileExists rejects the upload of a file whose name was already used
+func fileExists(w http.ResponseWriter, pvi *repository.ProjectVersionInsert) {
+	msg := fmt.Sprintf(
+		"File already exists ('%s', with sha256 hash '%s'). File names cannot be reused, even if the file was changed.",
+		pvi.Filename,
+		pvi.SHA256Digest,
+	)
+	slog.Warn("Rejected upload", "status", http.StatusBadRequest, "error", msg)
+	http.Error(w, msg, http.StatusBadRequest)
 }
 
+// projectNotActive rejects an upload to an archived, quarantined or deleted project
+func projectNotActive(w http.ResponseWriter, proj *repository.Project) {
+	msg := fmt.Sprintf("Project '%s' is %s and does not accept new uploads.", proj.Name, proj.Status)
+	slog.Warn("Rejected upload", "status", http.StatusBadRequest, "error", msg)
+	http.Error(w, msg, http.StatusBadRequest)
+}
+
+// HandleSimpleIndex returns the list of all projects in the repository.
 func (p *PipServer) HandleSimpleIndex(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("Accept") != JSONHeader {
+	format, ok := negotiateFormat(r)
+	if !ok {
 		http.Error(w, "Not Acceptable", http.StatusNotAcceptable)
+		return
 	}
 
-	ps, err := p.Repo.GetAllProjects(r.Context())
+	var rsp *SimpleIdxResponse
+	var err error
+	if idx := requestIndex(r.Context()); idx.Virtual() {
+		rsp, err = p.virtualProjectList(r.Context(), idx)
+	} else {
+		rsp, err = p.projectList(r.Context())
+	}
 	if err != nil {
 		slog.Error("Error fetching projects", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	p.writeSimpleResponse(w, format, SimpleIndexTemplate, rsp, rsp.Metadata.MaxId)
+}
+
+// projectList lists the projects of the index of a context which the user can see
+func (p *PipServer) projectList(c context.Context) (*SimpleIdxResponse, error) {
+	ps, err := p.Repo.GetAllProjects(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// On private indexes, users only see the projects they can read
+	private := requestIndex(c).Private
+	var readable map[string]bool
+	if private {
+		readable, err = p.Repo.ReadableProjects(requestUser(c), c)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching readable projects: %w", err)
+		}
+	}
+	token := requestToken(c)
+
+	// Quarantined and deleted projects are hidden from the index
+	listed := make([]*repository.Project, 0, len(ps.Projects))
+	for _, proj := range ps.Projects {
+		if !proj.Status.Listed() {
+			continue
+		}
+		if private && (!readable[proj.NormalizedName] || (token != nil && !token.Allows(requestIndex(c).ID, proj.NormalizedName))) {
+			continue
+		}
+		listed = append(listed, proj)
+	}
 
-	rsp := SimpleIdxResponse{
-		Projects: ps.Projects,
+	return &SimpleIdxResponse{
+		Projects: listed,
 		Metadata: APIMeta{
 			Version: APIVersion,
 			MaxId:   ps.LastSerial,
 		},
+	}, nil
+}
+
+// HandleSimpleProject returns the list of files available for a single project.
+func (p *PipServer) HandleSimpleProject(w http.ResponseWriter, r *http.Request) {
+	format, ok := negotiateFormat(r)
+	if !ok {
+		http.Error(w, "Not Acceptable", http.StatusNotAcceptable)
+		return
 	}
-	w.Header().Set("Content-Type", JSONHeader)
-	w.Header().Set("X-PyPI-Last-Serial", strconv.FormatInt(ps.LastSerial, 10))
-	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(&rsp)
+
+	// Non-normalized project names are redirected to the canonical URL
+	name := r.PathValue("project")
+	if norm := repository.NormalizeName(name); norm != name {
+		target := requestIndex(r.Context()).Prefix + "/simple/" + url.PathEscape(norm) + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	var rsp *SimpleProjectResponse
+	var err error
+	if idx := requestIndex(r.Context()); idx.Virtual() {
+		rsp, err = p.virtualProjectPage(r, idx, name)
+	} else {
+		rsp, err = p.projectPage(r, name)
+	}
+	switch {
+	case errors.Is(err, repository.ErrProjectNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case errors.Is(err, errUpstreamFailed):
+		slog.Error("Error fetching upstream project", "project", name, "error", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	case err != nil:
+		slog.Error("Error fetching project page", "project", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		p.writeSimpleResponse(w, format, SimpleProjectTemplate, rsp, rsp.Metadata.MaxId)
+	}
+}
+
+// projectPage builds the page of a project of the index of a request, from its local
+// files, the upstream index or both according to its source policy. Returns
+// ErrProjectNotFound if the project does not exist or cannot be read, and errors wrapping
+// errUpstreamFailed if the upstream index failed.
+func (p *PipServer) projectPage(r *http.Request, name string) (*SimpleProjectResponse, error) {
+	// Projects which cannot be read are reported as missing, so as not to reveal them
+	readable, err := p.canRead(r, name)
 	if err != nil {
-		slog.Error("Error encoding JSON response", "error", err)
+		return nil, fmt.Errorf("error checking read permission: %w", err)
+	} else if !readable {
+		return nil, repository.ErrProjectNotFound
+	}
+
+	// Without an upstream index, all projects are local
+	policy := repository.SourceLocal
+	if requestIndex(r.Context()).Upstream != nil {
+		policy, err = p.Repo.GetSourcePolicy(name, r.Context())
+		if err != nil {
+			return nil, fmt.Errorf("error fetching source policy: %w", err)
+		}
+	}
+	if policy == repository.SourceUpstream {
+		return p.upstreamProjectPage(r, name)
+	}
+
+	pf, err := p.Repo.GetProjectFiles(name, r.Context())
+	if errors.Is(err, repository.ErrProjectNotFound) && policy == repository.SourceMerged {
+		return p.upstreamProjectPage(r, name)
+	} else if err != nil {
+		return nil, err
+	}
+	if pf.Project.Status == repository.ProjectDeleted {
+		return nil, repository.ErrProjectNotFound
+	}
+	if pf.Project.Status == repository.ProjectQuarantined {
+		// Quarantined projects are shown without their files (PEP 792)
+		pf.Files = nil
+	}
+
+	rsp := SimpleProjectResponse{
+		Name:          pf.Project.NormalizedName,
+		ProjectStatus: projectStatusInfo(string(pf.Project.Status), pf.Project.StatusReason),
+		Files:         make([]*SimpleFile, 0, len(pf.Files)),
+		Versions:      make([]string, 0, len(pf.Files)),
+		Metadata: APIMeta{
+			Version: APIVersion,
+			MaxId:   pf.LastSerial,
+		},
+	}
+	seen := make(map[string]bool)
+	for _, f := range pf.Files {
+		if !seen[f.Version] {
+			seen[f.Version] = true
+			rsp.Versions = append(rsp.Versions, f.Version)
+		}
+
+		sf := &SimpleFile{
+			Filename:       f.Filename,
+			URL:            fileURL(requestIndex(r.Context()).Prefix, pf.Project.NormalizedName, f),
+			Hashes:         fileHashes(f),
+			RequiresPython: f.RequiresPython,
+			UploadTime:     f.UploadTime.UTC().Format(UploadTimeFormat),
+			Size:           f.Size,
+		}
+		if f.Yanked {
+			sf.Yanked, sf.YankedReason = true, f.YankedReason
+			if f.YankedReason != "" {
+				sf.Yanked = f.YankedReason
+			}
+		}
+		if f.MetadataDigest != "" {
+			sf.CoreMetadata = map[string]string{"sha256": f.MetadataDigest}
+			sf.DistInfoMetadata = sf.CoreMetadata
+		}
+		rsp.Files = append(rsp.Files, sf)
+	}
+	if policy == repository.SourceMerged && pf.Project.Status != repository.ProjectQuarantined {
+		p.mergeUpstreamFiles(r, &rsp)
+	}
+	return &rsp, nil
+}
+
+// writeSimpleResponse renders a Simple API response in the negotiated format, either
+// as JSON or with the given HTML template.
+func (p *PipServer) writeSimpleResponse(w http.ResponseWriter, format, tmpl string, rsp any, serial int64) {
+	var buf bytes.Buffer
+	var err error
+	if format == JSONHeader {
+		err = json.NewEncoder(&buf).Encode(rsp)
+	} else {
+		err = p.Templates.ExecuteTemplate(&buf, tmpl, rsp)
+	}
+	if err != nil {
+		slog.Error("Error rendering Simple API response", "format", format, "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+
+	if format == LegacyHTMLHeader {
+		format += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("X-PyPI-Last-Serial", strconv.FormatInt(serial, 10))
+	w.WriteHeader(http.StatusOK)
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		slog.Error("Error writing Simple API response", "error", err)
+	}
+}
+
+// fileURL builds the download URL of a distribution file of the index with the given URL
+// path prefix. The URL carries the file hash as a fragment so that installers can verify
+// the downloaded content.
+func fileURL(prefix, project string, f *repository.ProjectFile) string {
+	u := prefix + "/packages/" + url.PathEscape(project) + "/" + url.PathEscape(f.Filename)
+	if f.SHA256Digest != "" {
+		u += "#sha256=" + f.SHA256Digest
+	} else if f.MD5Digest != "" {
+		u += "#md5=" + f.MD5Digest
+	}
+	return u
+}
+
+// fileHashes builds the hashes dictionary of a distribution file, using the
+// hashlib names of the digest algorithms.
+func fileHashes(f *repository.ProjectFile) map[string]string {
+	hashes := make(map[string]string)
+	if f.SHA256Digest != "" {
+		hashes["sha256"] = f.SHA256Digest
+	}
+	if f.MD5Digest != "" {
+		hashes["md5"] = f.MD5Digest
+	}
+	return hashes
 }
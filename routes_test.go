@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-pip-server/repository"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestProjectStatusPages tests which project statuses are shown on project pages, deleted
+// projects being reported as missing
+func TestProjectStatusPages(t *testing.T) {
+	p := newTestServer(t)
+	p.AllowAnonymousUploads = true
+	ctx := context.Background()
+	if w := uploadWheel(t, p, "/upload/", "demo-pkg", "1.0"); w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with %d: %s", w.Code, w.Body)
+	}
+
+	var rsp SimpleProjectResponse
+	getJSON(t, p, "/simple/demo-pkg/", &rsp)
+	if rsp.Metadata.Version != APIVersion || rsp.ProjectStatus == nil || rsp.ProjectStatus.Status != "active" {
+		t.Errorf("Expected an active project, got %+v", rsp)
+	}
+	if err := p.Repo.SetProjectStatus("demo-pkg", repository.ProjectArchived, "Moved", ctx); err != nil {
+		t.Fatalf("SetProjectStatus failed: %v", err)
+	}
+	getJSON(t, p, "/simple/demo-pkg/", &rsp)
+	if rsp.ProjectStatus == nil || rsp.ProjectStatus.Status != "archived" || rsp.ProjectStatus.Reason != "Moved" {
+		t.Errorf("Expected an archived project, got %+v", rsp.ProjectStatus)
+	}
+
+	if err := p.Repo.SetProjectStatus("demo-pkg", repository.ProjectDeleted, "", ctx); err != nil {
+		t.Fatalf("SetProjectStatus failed: %v", err)
+	}
+	for _, path := range []string{"/simple/demo-pkg/", "/packages/demo-pkg/demo_pkg-1.0-py3-none-any.whl"} {
+		w := httptest.NewRecorder()
+		p.Server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected %s of a deleted project to be missing, got %d", path, w.Code)
+		}
+	}
+
+	if info := projectStatusInfo(string(repository.ProjectDeleted), ""); info != nil {
+		t.Errorf("Expected no status outside PEP 792, got %+v", info)
+	}
+}
+
+// TestPackageFile tests that package files are served with range and conditional
+// requests, except for quarantined and deleted projects
+func TestPackageFile(t *testing.T) {
+	p := newTestServer(t)
+	p.AllowAnonymousUploads = true
+	ctx := context.Background()
+	for _, name := range []string{"demo-pkg", "quarantined-pkg", "deleted-pkg"} {
+		if w := uploadWheel(t, p, "/upload/", name, "1.0"); w.Code != http.StatusOK {
+			t.Fatalf("Upload of %s failed with %d: %s", name, w.Code, w.Body)
+		}
+	}
+	if err := p.Repo.SetProjectStatus("quarantined-pkg", repository.ProjectQuarantined, "Malware", ctx); err != nil {
+		t.Fatalf("SetProjectStatus failed: %v", err)
+	}
+	if err := p.Repo.SetProjectStatus("deleted-pkg", repository.ProjectDeleted, "", ctx); err != nil {
+		t.Fatalf("SetProjectStatus failed: %v", err)
+	}
+	content := testWheel(t, "demo-pkg", "1.0")
+	sum := sha256.Sum256(content)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		headers map[string]string
+		code    int
+		body    []byte
+	}{
+		{"whole file", "/packages/demo-pkg/demo_pkg-1.0-py3-none-any.whl", nil, http.StatusOK, content},
+		{"range request", "/packages/demo-pkg/demo_pkg-1.0-py3-none-any.whl", map[string]string{"Range": "bytes=0-9"}, http.StatusPartialContent, content[:10]},
+		{"matching ETag", "/packages/demo-pkg/demo_pkg-1.0-py3-none-any.whl", map[string]string{"If-None-Match": etag}, http.StatusNotModified, nil},
+		{"other ETag", "/packages/demo-pkg/demo_pkg-1.0-py3-none-any.whl", map[string]string{"If-None-Match": `"abc"`}, http.StatusOK, content},
+		{"missing file", "/packages/demo-pkg/demo_pkg-2.0-py3-none-any.whl", nil, http.StatusNotFound, nil},
+		{"quarantined project", "/packages/quarantined-pkg/quarantined_pkg-1.0-py3-none-any.whl", nil, http.StatusNotFound, nil},
+		{"deleted project", "/packages/deleted-pkg/deleted_pkg-1.0-py3-none-any.whl", nil, http.StatusNotFound, nil},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		w := serve(p, r)
+		if w.Code != tc.code {
+			t.Errorf("Expected %d for the %s, got %d: %s", tc.code, tc.name, w.Code, w.Body)
+			continue
+		}
+		if tc.body != nil && !bytes.Equal(w.Body.Bytes(), tc.body) {
+			t.Errorf("Unexpected body of %d bytes for the %s", w.Body.Len(), tc.name)
+		}
+		if tc.code < http.StatusBadRequest && w.Header().Get("ETag") != etag {
+			t.Errorf("Expected ETag %s for the %s, got %q", etag, tc.name, w.Header().Get("ETag"))
+		}
+	}
+}
+
+// TestUploadAuthentication tests that uploads and token management ask for HTTP Basic
+// credentials, and that wrong credentials and disabled users are rejected
+func TestUploadAuthentication(t *testing.T) {
+	p := newTestServer(t)
+	ctx := context.Background()
+	if _, err := p.Repo.CreateUser("alice", "correct horse", ctx); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		username, password string
+		disabled           bool
+		code               int
+	}{
+		{"", "", false, http.StatusUnauthorized},
+		{"alice", "wrong horse", false, http.StatusUnauthorized},
+		{"nobody", "correct horse", false, http.StatusUnauthorized},
+		{"alice", "correct horse", true, http.StatusForbidden},
+		{"alice", "correct horse", false, http.StatusOK},
+	} {
+		if err := p.Repo.SetUserDisabled("alice", tc.disabled, ctx); err != nil {
+			t.Fatalf("SetUserDisabled failed: %v", err)
+		}
+		// Token management requires a user whatever the upload policy
+		for _, r := range []*http.Request{uploadRequest(t, "/upload/", "demo-pkg", "1.0"), httptest.NewRequest(http.MethodGet, "/tokens/", nil)} {
+			if tc.username != "" {
+				r.SetBasicAuth(tc.username, tc.password)
+			}
+			w := serve(p, r)
+			if w.Code != tc.code {
+				t.Errorf("Expected %d for %s with %+v, got %d: %s", tc.code, r.URL.Path, tc, w.Code, w.Body)
+			}
+			challenge := w.Header().Get("WWW-Authenticate")
+			if (w.Code == http.StatusUnauthorized) != strings.HasPrefix(challenge, "Basic ") {
+				t.Errorf("Unexpected challenge %q with %d for %s", challenge, w.Code, r.URL.Path)
+			}
+		}
+		if _, err := p.Repo.GetProject("demo-pkg", ctx); (err == nil) != (tc.code == http.StatusOK) {
+			t.Errorf("Unexpected project after upload with %+v (err: %v)", tc, err)
+		}
+	}
+}
+
+// getAs requests a path with HTTP Basic credentials, unless the user name is empty
+func getAs(p *PipServer, path, username, password string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Accept", JSONHeader)
+	if username != "" {
+		r.SetBasicAuth(username, password)
+	}
+	return serve(p, r)
+}
+
+// newStubUpstream serves the project pages of an upstream index, each with a wheel of
+// version 9.9
+func newStubUpstream(t *testing.T, projects ...string) *httptest.Server {
+	mux := http.NewServeMux()
+	for _, name := range projects {
+		filename := strings.ReplaceAll(name, "-", "_") + "-9.9-py3-none-any.whl"
+		sum := sha256.Sum256([]byte(filename))
+		mux.HandleFunc("GET /simple/"+name+"/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", JSONHeader)
+			fmt.Fprintf(w, `{"meta": {"api-version": "1.1"}, "name": %q, "versions": ["9.9"],
+                "files": [{"filename": %q, "url": "/files/%s", "hashes": {"sha256": %q}}]}`,
+				name, filename, filename, hex.EncodeToString(sum[:]))
+		})
+		mux.HandleFunc("GET /files/"+filename, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(filename))
+		})
+	}
+	stub := httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// TestPrivateIndex tests that private indexes require credentials, and only show users
+// the projects and upstream files they can read, reporting the others as missing
+func TestPrivateIndex(t *testing.T) {
+	p := newTestServer(t)
+	ctx := context.Background()
+	stub := newStubUpstream(t, "secret-pkg", "requests")
+	private := true
+	idx, err := p.Repo.CreateIndex(&repository.Index{
+		Name:         "internal",
+		Private:      &private,
+		UploadPolicy: repository.UploadsAnonymous,
+		UpstreamURL:  stub.URL + "/simple",
+	}, ctx)
+	if err != nil {
+		t.Fatalf("CreateIndex failed: %v", err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := p.Repo.CreateUser(name, "correct horse", ctx); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+	for _, name := range []string{"secret-pkg", "shared-pkg"} {
+		if w := uploadWheel(t, p, "/internal/upload/", name, "1.0"); w.Code != http.StatusOK {
+			t.Fatalf("Upload of %s failed with %d: %s", name, w.Code, w.Body)
+		}
+	}
+	ictx := repository.WithIndex(ctx, idx.ID)
+	for _, role := range []struct {
+		project, user string
+		role          repository.Role
+	}{
+		{"secret-pkg", "alice", repository.RoleOwner},
+		{"shared-pkg", "alice", repository.RoleOwner},
+		{"shared-pkg", "bob", repository.RoleReader},
+	} {
+		if err := p.Repo.SetCollaboratorRole(role.project, repository.CollaboratorUser, role.user, role.role, ictx); err != nil {
+			t.Fatalf("SetCollaboratorRole failed: %v", err)
+		}
+	}
+	// The policy lets upstream files through, only the role of the user keeps them hidden
+	if err := p.Repo.SetSourcePolicy("secret-pkg", repository.SourceMerged, ictx); err != nil {
+		t.Fatalf("SetSourcePolicy failed: %v", err)
+	}
+	_, bobToken, err := p.Repo.CreateAPIToken("bob", &repository.APITokenInsert{Name: "ci", Projects: []string{"shared-pkg"}, Index: "internal"}, ctx)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+
+	// Anonymous requests are asked for credentials
+	for _, path := range []string{"/internal/simple/", "/internal/packages/shared-pkg/shared_pkg-1.0-py3-none-any.whl"} {
+		w := getAs(p, path, "", "")
+		if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+			t.Errorf("Expected %s to ask for credentials, got %d %v", path, w.Code, w.Header())
+		}
+	}
+
+	// Users only see the projects they can read
+	for user, expected := range map[string]string{"alice": "secret-pkg shared-pkg", "bob": "shared-pkg"} {
+		w := getAs(p, "/internal/simple/", user, "correct horse")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Project list of %s returned %d: %s", user, w.Code, w.Body)
+		}
+		var list SimpleIdxResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(list.Projects))
+		for _, proj := range list.Projects {
+			names = append(names, proj.Name)
+		}
+		if strings.Join(names, " ") != expected {
+			t.Errorf("Expected %s to see %s, got %v", user, expected, names)
+		}
+	}
+
+	// Projects, files and upstream files which cannot be read are missing rather than forbidden
+	for _, tc := range []struct {
+		path, username, password string
+		code                     int
+	}{
+		{"/internal/simple/secret-pkg/", "alice", "correct horse", http.StatusOK},
+		{"/internal/simple/secret-pkg/", "bob", "correct horse", http.StatusNotFound},
+		{"/internal/packages/secret-pkg/secret_pkg-1.0-py3-none-any.whl", "alice", "correct horse", http.StatusOK},
+		{"/internal/packages/secret-pkg/secret_pkg-1.0-py3-none-any.whl", "bob", "correct horse", http.StatusNotFound},
+		{"/internal/upstream/secret-pkg/secret_pkg-9.9-py3-none-any.whl", "alice", "correct horse", http.StatusOK},
+		{"/internal/upstream/secret-pkg/secret_pkg-9.9-py3-none-any.whl", "bob", "correct horse", http.StatusNotFound},
+		// Projects missing locally can be read by all users, unless their token does not cover them
+		{"/internal/upstream/requests/requests-9.9-py3-none-any.whl", "bob", "correct horse", http.StatusOK},
+		{"/internal/upstream/requests/requests-9.9-py3-none-any.whl", repository.TokenUsername, bobToken, http.StatusNotFound},
+		{"/internal/packages/shared-pkg/shared_pkg-1.0-py3-none-any.whl", repository.TokenUsername, bobToken, http.StatusOK},
+	} {
+		if w := getAs(p, tc.path, tc.username, tc.password); w.Code != tc.code {
+			t.Errorf("Expected %d for %s as %s, got %d: %s", tc.code, tc.path, tc.username, w.Code, w.Body)
+		}
+	}
+}
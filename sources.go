@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"go-pip-server/repository"
+	"log/slog"
+	"net/http"
+)
+
+// sourceRequest is the body of requests setting the source policy of a project
+type sourceRequest struct {
+	Policy string `json:"policy"`
+}
+
+// sourceResponse describes the source policy of a project, which is explicit if it was
+// set rather than the default one
+type sourceResponse struct {
+	Project  string                  `json:"project"`
+	Policy   repository.SourcePolicy `json:"policy"`
+	Explicit bool                    `json:"explicit"`
+}
+
+// HandleGetSource returns the source policy of a project
+func (p *PipServer) HandleGetSource(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	policy, err := p.Repo.GetSourcePolicy(project, r.Context())
+	var explicit bool
+	if err == nil {
+		_, err = p.Repo.GetProjectSource(project, r.Context())
+		explicit = err == nil
+		if errors.Is(err, repository.ErrSourcePolicyNotFound) {
+			err = nil
+		}
+	}
+	if err != nil {
+		slog.Error("Error fetching source policy", "project", project, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &sourceResponse{
+		Project:  repository.NormalizeName(project),
+		Policy:   policy,
+		Explicit: explicit,
+	})
+}
+
+// HandleSetSource sets the source policy of a project, or resets it to the default one,
+// depending on the method
+func (p *PipServer) HandleSetSource(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	var err error
+	var policy repository.SourcePolicy
+	if r.Method == http.MethodDelete {
+		err = p.Repo.ResetSourcePolicy(project, r.Context())
+	} else {
+		var req sourceRequest
+		if dErr := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); dErr != nil {
+			http.Error(w, "Invalid request body.", http.StatusBadRequest)
+			return
+		}
+		policy, err = repository.ParseSourcePolicy(req.Policy)
+		if err != nil {
+			http.Error(w, "Unknown source policy, use local, upstream or merged.", http.StatusBadRequest)
+			return
+		}
+		err = p.Repo.SetSourcePolicy(project, policy, r.Context())
+	}
+
+	switch {
+	case errors.Is(err, repository.ErrSourcePolicyNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case err != nil:
+		slog.Error("Error updating source policy", "project", project, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Updated source policy", "project", project, "policy", policy, "method", r.Method)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"go-pip-server/repository"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// createdToken is the response to the creation of an API token, the only one which
+// contains the secret token
+type createdToken struct {
+	*repository.APIToken
+	Token string `json:"token"`
+}
+
+// passwordUser returns the user of a request authenticated with a password. API tokens
+// cannot manage tokens, so that a leaked token cannot be used to create others.
+func passwordUser(w http.ResponseWriter, r *http.Request) *repository.User {
+	if requestToken(r.Context()) != nil {
+		http.Error(w, "API tokens cannot be used to manage API tokens.", http.StatusForbidden)
+		return nil
+	}
+	u := requestUser(r.Context())
+	if u == nil {
+		unauthorized(w, "Authentication is required.")
+	}
+	return u
+}
+
+// HandleListTokens returns the API tokens of the authenticated user
+func (p *PipServer) HandleListTokens(w http.ResponseWriter, r *http.Request) {
+	u := passwordUser(w, r)
+	if u == nil {
+		return
+	}
+	tokens, err := p.Repo.GetAPITokens(u.Username, r.Context())
+	writeTokens(w, tokens, err)
+}
+
+// HandleCreateToken issues an API token for the authenticated user. The secret token is
+// only returned in this response.
+func (p *PipServer) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	u := passwordUser(w, r)
+	if u == nil {
+		return
+	}
+	var ins repository.APITokenInsert
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&ins); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	token, secret, err := p.Repo.CreateAPIToken(u.Username, &ins, r.Context())
+	if errors.Is(err, repository.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		slog.Error("Error creating API token", "user", u.Username, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Created API token", "user", u.Username, "token", token.Prefix, "projects", token.Projects)
+	writeJSON(w, http.StatusCreated, &createdToken{APIToken: token, Token: secret})
+}
+
+// HandleRevokeToken revokes an API token of the authenticated user
+func (p *PipServer) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	u := passwordUser(w, r)
+	if u == nil {
+		return
+	}
+	p.revokeToken(w, r, u.Username)
+}
+
+// HandleAdminListTokens returns the API tokens of all users
+func (p *PipServer) HandleAdminListTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := p.Repo.GetAPITokens("", r.Context())
+	writeTokens(w, tokens, err)
+}
+
+// HandleAdminRevokeToken revokes an API token of any user
+func (p *PipServer) HandleAdminRevokeToken(w http.ResponseWriter, r *http.Request) {
+	p.revokeToken(w, r, "")
+}
+
+// revokeToken revokes the API token in the request path, if it belongs to the user, or to
+// any user if the name is empty
+func (p *PipServer) revokeToken(w http.ResponseWriter, r *http.Request, username string) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	err = p.Repo.RevokeAPIToken(username, id, r.Context())
+	switch {
+	case errors.Is(err, repository.ErrTokenNotFound):
+		http.Error(w, "Not Found", http.StatusNotFound)
+	case err != nil:
+		slog.Error("Error revoking API token", "id", id, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	default:
+		slog.Info("Revoked API token", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeTokens writes a list of API tokens
+func writeTokens(w http.ResponseWriter, tokens []*repository.APIToken, err error) {
+	if err != nil {
+		slog.Error("Error fetching API tokens", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// writeJSON writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing JSON response", "error", err)
+	}
+}
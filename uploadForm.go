@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"go-pip-server/distribution"
+	"go-pip-server/pep440"
+	"go-pip-server/repository"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// uploadError is an error in an upload request which is reported back to the client
+type uploadError struct {
+	Status  int
+	Message string
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+// invalidField builds the error for an invalid upload form field, worded like PyPI's
+func invalidField(field, msg string) error {
+	return &uploadError{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf("Invalid value for %s. Error: %s", field, msg),
+	}
+}
+
+// badUpload builds the error for an upload request that is rejected as a whole
+func badUpload(msg string) error {
+	return &uploadError{Status: http.StatusBadRequest, Message: msg}
+}
+
+// knownMetadataVersions lists the core metadata versions accepted in uploads
+var knownMetadataVersions = []string{"1.0", "1.1", "1.2", "2.0", "2.1", "2.2", "2.3", "2.4"}
+
+// knownFileTypes lists the distribution types accepted in uploads
+var knownFileTypes = []string{"sdist", "bdist_wheel"}
+
+// digestFields lists the form fields carrying digests of the uploaded file
+var digestFields = []string{"md5_digest", "sha256_digest", "blake2_256_digest"}
+
+// controlFields are upload form fields that describe the request or the file itself
+// rather than the release, so they are not stored as version metadata.
+var controlFields = []string{
+	":action", "protocol_version", "name", "version", "filetype", "content",
+	"md5_digest", "sha256_digest", "blake2_256_digest", "digest", "digest_type",
+}
+
+// formValue returns the first value of an upload form field, or an empty string.
+func formValue(values map[string][]string, key string) string {
+	if v, ok := values[key]; ok && len(v) > 0 {
+		return strings.TrimSpace(v[0])
+	}
+	return ""
+}
+
+// validateUploadForm checks the fields of a legacy upload API request (as sent by
+// twine) before the uploaded file is processed.
+func validateUploadForm(values map[string][]string) error {
+	switch action := formValue(values, ":action"); action {
+	case "file_upload":
+	case "submit":
+		return &uploadError{
+			Status:  http.StatusGone,
+			Message: "Project pre-registration is no longer required or supported, upload your files instead.",
+		}
+	case "doc_upload":
+		return &uploadError{
+			Status:  http.StatusGone,
+			Message: "Uploading documentation is no longer supported.",
+		}
+	case "":
+		return invalidField(":action", "This field is required.")
+	default:
+		return invalidField(":action", fmt.Sprintf("Unknown action %q.", action))
+	}
+
+	if pv := formValue(values, "protocol_version"); pv != "" && pv != "1" {
+		return badUpload("Unknown protocol version.")
+	}
+
+	for _, field := range strings.Split(RequiredFields, ";") {
+		if formValue(values, field) == "" {
+			return invalidField(field, "This field is required.")
+		}
+	}
+
+	if !slices.Contains(knownMetadataVersions, formValue(values, "metadata_version")) {
+		return invalidField("metadata_version", "Use a known metadata version.")
+	}
+	if !slices.Contains(knownFileTypes, formValue(values, "filetype")) {
+		return invalidField("filetype", "Use a known file type.")
+	}
+	if _, err := pep440.Parse(formValue(values, "version")); err != nil {
+		return invalidField("version", "Use a valid PEP 440 version, such as 1.0.0 or 2.0rc1.")
+	}
+	if _, err := pep440.ParseSpecifierSet(formValue(values, "requires_python")); err != nil {
+		return invalidField("requires_python", "Use a valid PEP 440 version specifier, such as >=3.8.")
+	}
+
+	hasDigest := formValue(values, "digest") != "" && formValue(values, "digest_type") != ""
+	for _, field := range digestFields {
+		hasDigest = hasDigest || formValue(values, field) != ""
+	}
+	if !hasDigest {
+		return badUpload("Include at least one message digest.")
+	}
+	return nil
+}
+
+// checkDigests verifies every digest supplied in the upload form against the digests
+// computed from the bytes actually received.
+func checkDigests(values map[string][]string, computed *fileDigests) error {
+	supplied := make(map[string]string)
+	for _, field := range digestFields {
+		if dg := formValue(values, field); dg != "" {
+			supplied[strings.TrimSuffix(field, "_digest")] = dg
+		}
+	}
+	if dg := formValue(values, "digest"); dg != "" {
+		dt := strings.ToLower(formValue(values, "digest_type"))
+		if _, ok := computed.ByType(dt); !ok {
+			return invalidField("digest_type", "Use a known digest type.")
+		}
+		supplied[dt] = dg
+	}
+
+	for dt, dg := range supplied {
+		actual, _ := computed.ByType(dt)
+		if !strings.EqualFold(dg, actual) {
+			return invalidField(
+				dt+"_digest",
+				"The digest supplied does not match a digest calculated from the uploaded file.",
+			)
+		}
+	}
+	return nil
+}
+
+// formMetadataKey maps an upload form field to the core metadata field it carries
+func formMetadataKey(field string) string {
+	switch field {
+	case "classifiers":
+		return "classifier"
+	case "project_urls":
+		return "project-url"
+	default:
+		return strings.ReplaceAll(field, "_", "-")
+	}
+}
+
+// mergeFormMetadata adds the metadata fields sent in the upload form to the metadata
+// extracted from the distribution file. Fields present in the file take precedence.
+func mergeFormMetadata(fileMeta []*repository.KeyVal, values map[string][]string) []*repository.KeyVal {
+	fromFile := make(map[string]bool)
+	for _, kv := range fileMeta {
+		fromFile[kv.Key] = true
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	out := fileMeta
+	for _, field := range keys {
+		key := formMetadataKey(field)
+		if slices.Contains(controlFields, field) || fromFile[key] {
+			continue
+		}
+		for _, v := range values[field] {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, &repository.KeyVal{Key: key, Val: v})
+			}
+		}
+	}
+	return out
+}
+
+// validateFilename checks that the name of an uploaded file follows the naming spec of its
+// distribution type, and that the name and version in it agree with the form fields.
+func validateFilename(filename string, values map[string][]string) error {
+	var dn *distribution.Filename
+	var err error
+	if formValue(values, "filetype") == "bdist_wheel" {
+		dn, err = distribution.ParseWheelFilename(filename)
+	} else {
+		dn, err = distribution.ParseSdistFilename(filename)
+	}
+	if err != nil {
+		return badUpload(fmt.Sprintf("Invalid distribution file. Error: %v", err))
+	}
+
+	name := formValue(values, "name")
+	if repository.NormalizeName(dn.Name) != repository.NormalizeName(name) {
+		prefix := strings.ReplaceAll(repository.NormalizeName(name), "-", "_")
+		return badUpload(fmt.Sprintf("Start filename for %q with %q.", name, prefix))
+	}
+	// Versions are compared in their normal forms, which is how they are stored
+	version, _ := pep440.Normalize(formValue(values, "version"))
+	if fnVersion, err := pep440.Normalize(dn.Version); err != nil || fnVersion != version {
+		return badUpload(fmt.Sprintf("Version in filename should be %q not %q.", version, dn.Version))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"go-pip-server/repository"
+	"net/http"
+	"testing"
+)
+
+// twineForm returns the form fields twine sends for an upload
+func twineForm() map[string][]string {
+	return map[string][]string{
+		":action":          {"file_upload"},
+		"protocol_version": {"1"},
+		"metadata_version": {"2.1"},
+		"name":             {"demo-pkg"},
+		"version":          {"1.0.0"},
+		"filetype":         {"bdist_wheel"},
+		"pyversion":        {"py3"},
+		"sha256_digest":    {"abc"},
+		"classifiers":      {"Topic :: Utilities", "Framework :: Pytest"},
+		"requires_python":  {">=3.9"},
+		"summary":          {""},
+	}
+}
+
+// TestValidateUploadForm tests validation of legacy upload API requests
+func TestValidateUploadForm(t *testing.T) {
+	if err := validateUploadForm(twineForm()); err != nil {
+		t.Fatalf("Expected twine form to be valid, got %v", err)
+	}
+
+	cases := []struct {
+		field  string
+		value  []string
+		status int
+	}{
+		{":action", nil, http.StatusBadRequest},
+		{":action", []string{"submit"}, http.StatusGone},
+		{":action", []string{"remove_pkg"}, http.StatusBadRequest},
+		{"protocol_version", []string{"2"}, http.StatusBadRequest},
+		{"name", nil, http.StatusBadRequest},
+		{"version", []string{""}, http.StatusBadRequest},
+		{"version", []string{"1.0-final"}, http.StatusBadRequest},
+		{"requires_python", []string{"3.8"}, http.StatusBadRequest},
+		{"metadata_version", []string{"3.0"}, http.StatusBadRequest},
+		{"filetype", []string{"bdist_egg"}, http.StatusBadRequest},
+		{"sha256_digest", nil, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		form := twineForm()
+		if tc.value == nil {
+			delete(form, tc.field)
+		} else {
+			form[tc.field] = tc.value
+		}
+
+		var uErr *uploadError
+		err := validateUploadForm(form)
+		if !errors.As(err, &uErr) {
+			t.Errorf("%s=%v: expected upload error, got %v", tc.field, tc.value, err)
+		} else if uErr.Status != tc.status {
+			t.Errorf("%s=%v: expected status %d, got %d", tc.field, tc.value, tc.status, uErr.Status)
+		}
+	}
+}
+
+// TestCheckDigests tests that all supplied digests are verified
+func TestCheckDigests(t *testing.T) {
+	d := newDigester()
+	d.Writer().Write([]byte("distribution content"))
+	digests := d.Sum()
+
+	valid := map[string][]string{
+		"md5_digest":        {digests.MD5},
+		"sha256_digest":     {digests.SHA256},
+		"blake2_256_digest": {digests.Blake2b256},
+		"digest":            {digests.SHA256},
+		"digest_type":       {"SHA256"},
+	}
+	if err := checkDigests(valid, digests); err != nil {
+		t.Errorf("Expected digests to match, got %v", err)
+	}
+
+	for _, field := range []string{"md5_digest", "sha256_digest", "blake2_256_digest", "digest"} {
+		form := map[string][]string{field: {"0123abcd"}, "digest_type": {"sha256"}}
+		if err := checkDigests(form, digests); err == nil {
+			t.Errorf("Expected mismatched %s to be rejected", field)
+		}
+	}
+	form := map[string][]string{"digest": {digests.SHA256}, "digest_type": {"sha1"}}
+	if err := checkDigests(form, digests); err == nil {
+		t.Errorf("Expected unknown digest type to be rejected")
+	}
+}
+
+// TestMergeFormMetadata tests that form fields are stored as metadata without
+// overriding the metadata of the distribution file
+func TestMergeFormMetadata(t *testing.T) {
+	fileMeta := []*repository.KeyVal{{Key: "requires-python", Val: ">=3.10"}}
+	merged := mergeFormMetadata(fileMeta, twineForm())
+
+	found := make(map[string][]string)
+	for _, kv := range merged {
+		found[kv.Key] = append(found[kv.Key], kv.Val)
+	}
+	if rp := found["requires-python"]; len(rp) != 1 || rp[0] != ">=3.10" {
+		t.Errorf("Expected file requires-python to take precedence, got %v", rp)
+	}
+	if cs := found["classifier"]; len(cs) != 2 {
+		t.Errorf("Expected 2 classifiers from the form, got %v", cs)
+	}
+	if pv := found["pyversion"]; len(pv) != 1 || pv[0] != "py3" {
+		t.Errorf("Expected pyversion to be stored, got %v", pv)
+	}
+	for _, key := range []string{":action", "name", "sha256-digest", "summary"} {
+		if _, ok := found[key]; ok {
+			t.Errorf("Expected %s not to be stored", key)
+		}
+	}
+}
+
+// TestValidateFilename tests that file names must agree with the name and version fields
+func TestValidateFilename(t *testing.T) {
+	valid := map[string]string{
+		"demo_pkg-1.0.0-py3-none-any.whl": "bdist_wheel",
+		"demo_pkg-1.0.0.tar.gz":           "sdist",
+		"Demo.Pkg-1.0.0.zip":              "sdist",
+	}
+	for fn, fType := range valid {
+		values := twineForm()
+		values["filetype"] = []string{fType}
+		if err := validateFilename(fn, values); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", fn, err)
+		}
+	}
+
+	// Versions are compared in their normal form
+	values := twineForm()
+	values["version"] = []string{"1.0.0-RC1"}
+	if err := validateFilename("demo_pkg-1.0.0rc1-py3-none-any.whl", values); err != nil {
+		t.Errorf("Expected normalized version in file name to be valid, got %v", err)
+	}
+
+	invalid := map[string]string{
+		"demo_pkg-1.0.0.tar.gz":              "bdist_wheel",
+		"demo_pkg-1.0.0.0.tar.gz":            "sdist",
+		"demo_pkg-1.0.0-py3-none-any.whl":    "sdist",
+		"other_pkg-1.0.0-py3-none-any.whl":   "bdist_wheel",
+		"demo_pkg-1.0.1-py3-none-any.whl":    "bdist_wheel",
+		"demo_pkg-1.0.0-py3-none-any.whl.gz": "bdist_wheel",
+		"demo_pkg-1.0.0.tar.xz":              "sdist",
+		"..":                                 "sdist",
+	}
+	for fn, fType := range invalid {
+		values := twineForm()
+		values["filetype"] = []string{fType}
+		var uErr *uploadError
+		if err := validateFilename(fn, values); !errors.As(err, &uErr) || uErr.Status != http.StatusBadRequest {
+			t.Errorf("Expected %q as %s to be rejected with 400, got %v", fn, fType, err)
+		}
+	}
+}
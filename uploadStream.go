@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// uploadStream is an upload form read from a multipart stream. The uploaded file is written
+// to a temporary file as it is received, and hashed on the way.
+type uploadStream struct {
+	Values   url.Values
+	Filename string
+	TmpPath  string
+	Size     int64
+	Digests  *fileDigests
+}
+
+// tooLarge builds the error for an upload request exceeding the size limit
+func tooLarge(limit int64) error {
+	return &uploadError{
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("Upload is too large. Limit is %d bytes.", limit),
+	}
+}
+
+// readUploadStream reads the parts of an upload form in a single pass, without buffering
+// the file in memory. The file is written to a temporary file in dir, which the caller
+// must remove or move, also when an error is returned. Fields may come before or after the
+// file. Errors caused by the request itself are returned as *uploadError.
+func readUploadStream(mr *multipart.Reader, dir string) (*uploadStream, error) {
+	us := &uploadStream{Values: url.Values{}}
+	fieldsLeft := int64(MaxFormFieldsSize)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return us, formReadError(err)
+		}
+
+		if part.FormName() != UploadFileField || part.FileName() == "" {
+			// Read one byte more than allowed to detect oversized fields
+			raw, err := io.ReadAll(io.LimitReader(part, fieldsLeft+1))
+			part.Close()
+			if err != nil {
+				return us, formReadError(err)
+			}
+			fieldsLeft -= int64(len(raw))
+			if fieldsLeft < 0 {
+				return us, tooLarge(MaxFormFieldsSize)
+			}
+			us.Values.Add(part.FormName(), string(raw))
+			continue
+		}
+
+		if us.TmpPath != "" {
+			part.Close()
+			return us, badUpload("Upload payload must contain a single file.")
+		}
+		if raw := rawFileName(part); raw != part.FileName() {
+			part.Close()
+			return us, badUpload(fmt.Sprintf("Invalid file name %q, file names cannot contain paths.", raw))
+		}
+		err = us.writeFile(part, dir)
+		part.Close()
+		if err != nil {
+			return us, err
+		}
+	}
+	return us, nil
+}
+
+// writeFile streams a file part to a temporary file while computing its digests
+func (us *uploadStream) writeFile(part *multipart.Part, dir string) error {
+	us.Filename = part.FileName()
+	out, err := os.CreateTemp(dir, ".upload-*-"+us.Filename)
+	if err != nil {
+		return fmt.Errorf("error creating file on disk: %w", err)
+	}
+	us.TmpPath = out.Name()
+
+	dg := newDigester()
+	us.Size, err = io.Copy(io.MultiWriter(out, dg.Writer()), part)
+	err = errors.Join(err, out.Close())
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return fmt.Errorf("error saving file to disk: %w", err)
+	} else if err != nil {
+		return formReadError(err)
+	}
+	us.Digests = dg.Sum()
+	return nil
+}
+
+// rawFileName returns the file name of a part as sent by the client. Part.FileName strips
+// directories, which would hide path traversal attempts.
+func rawFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// formReadError converts an error reading the request body to an *uploadError
+func formReadError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return tooLarge(maxErr.Limit)
+	}
+	return badUpload("Invalid form data.")
+}
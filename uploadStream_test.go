@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// multipartBody builds a multipart upload body with the given fields and file content
+func multipartBody(t *testing.T, fields map[string]string, content []byte) (*bytes.Buffer, string) {
+	return multipartFileBody(t, fields, "demo_pkg-1.0.tar.gz", content)
+}
+
+// multipartFileBody builds a multipart upload body with a file of the given name
+func multipartFileBody(t *testing.T, fields map[string]string, filename string, content []byte) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if content != nil {
+		fw, err := mw.CreateFormFile(UploadFileField, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(content)
+	}
+	mw.Close()
+	return &buf, mw.Boundary()
+}
+
+// TestReadUploadStream tests that the uploaded file is written to disk and hashed
+func TestReadUploadStream(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("x"), 100000)
+	body, boundary := multipartBody(t, map[string]string{"name": "demo-pkg"}, content)
+
+	us, err := readUploadStream(multipart.NewReader(body, boundary), dir)
+	if err != nil {
+		t.Fatalf("readUploadStream failed: %v", err)
+	}
+	if formValue(us.Values, "name") != "demo-pkg" || us.Filename != "demo_pkg-1.0.tar.gz" {
+		t.Errorf("Unexpected form %+v", us)
+	}
+	sum := sha256.Sum256(content)
+	if us.Size != int64(len(content)) || us.Digests.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected size %d or digest %s", us.Size, us.Digests.SHA256)
+	}
+	saved, err := os.ReadFile(us.TmpPath)
+	if err != nil || !bytes.Equal(saved, content) {
+		t.Errorf("Expected file to be saved at %s (err: %v)", us.TmpPath, err)
+	}
+	if !strings.HasSuffix(us.TmpPath, us.Filename) {
+		t.Errorf("Expected temporary file name to keep the file name, got %s", us.TmpPath)
+	}
+}
+
+// TestReadUploadStreamTooLarge tests that uploads over the size limit are rejected with 413
+func TestReadUploadStreamTooLarge(t *testing.T) {
+	body, boundary := multipartBody(t, map[string]string{"name": "demo-pkg"}, bytes.Repeat([]byte("x"), 10000))
+	limited := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(body), 5000)
+
+	us, err := readUploadStream(multipart.NewReader(limited, boundary), t.TempDir())
+	var uErr *uploadError
+	if !errors.As(err, &uErr) || uErr.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 upload error, got %v", err)
+	}
+	if us.TmpPath != "" {
+		os.Remove(us.TmpPath)
+	}
+}
+
+// TestReadUploadStreamPath tests that file names with paths are rejected before being written
+func TestReadUploadStreamPath(t *testing.T) {
+	body, boundary := multipartFileBody(t, nil, "../../etc/demo_pkg-1.0.tar.gz", []byte("x"))
+
+	us, err := readUploadStream(multipart.NewReader(body, boundary), t.TempDir())
+	var uErr *uploadError
+	if !errors.As(err, &uErr) || uErr.Status != http.StatusBadRequest {
+		t.Fatalf("Expected 400 upload error, got %v", err)
+	}
+	if us.TmpPath != "" {
+		t.Errorf("Expected file not to be written, got %s", us.TmpPath)
+	}
+}
@@ -0,0 +1,353 @@
+// Package upstream fetches projects from an upstream index such as PyPI through its JSON
+// Simple API (PEP 691), and caches the project pages and the downloaded files on disk.
+package upstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultPageTTL is how long project pages are cached before they are fetched again
+const DefaultPageTTL = 10 * time.Minute
+
+// JSONHeader is the Content-Type of JSON Simple API responses (PEP 691)
+const JSONHeader = "application/vnd.pypi.simple.v1+json"
+
+// MetadataFileSuffix is appended to the file name of a distribution to get its core
+// metadata file (PEP 658)
+const MetadataFileSuffix = ".metadata"
+
+// maxPageSize limits the size of project pages, which are large for projects with
+// thousands of files
+const maxPageSize = 256 << 20
+
+// ErrNotFound is returned when a project or a file does not exist upstream, or is not
+// cached in offline mode
+var ErrNotFound = errors.New("not found upstream")
+
+// ErrHashMismatch is returned when a downloaded file does not have the hash announced by
+// its project page
+var ErrHashMismatch = errors.New("downloaded file does not match its hash")
+
+// ErrNoHash is returned for files whose project page does not give their SHA256 hash,
+// which cannot be checked before they are cached
+var ErrNoHash = errors.New("no sha256 hash of the file upstream")
+
+// Meta holds the metadata of a project page
+type Meta struct {
+	APIVersion string `json:"api-version"`
+	LastSerial int64  `json:"_last-serial,omitempty"`
+}
+
+// ProjectStatus is the status of a project (PEP 792)
+type ProjectStatus struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// File is a distribution file of a project page. URLs are resolved against the page URL.
+type File struct {
+	Filename       string            `json:"filename"`
+	URL            string            `json:"url"`
+	Hashes         map[string]string `json:"hashes"`
+	RequiresPython string            `json:"requires-python,omitempty"`
+	Size           int64             `json:"size,omitempty"`
+	UploadTime     string            `json:"upload-time,omitempty"`
+	// CoreMetadata is either a dictionary of hashes or a boolean (PEP 714), and
+	// DistInfoMetadata is its pre-PEP 714 name
+	CoreMetadata     any `json:"core-metadata,omitempty"`
+	DistInfoMetadata any `json:"dist-info-metadata,omitempty"`
+	// Yanked is either the reason the file was yanked or a boolean (PEP 592)
+	Yanked any `json:"yanked,omitempty"`
+}
+
+// MetadataHashes returns the hashes of the core metadata file of the distribution, which
+// are empty if the index does not announce them, or nil if there is no metadata file
+func (f *File) MetadataHashes() map[string]string {
+	v := f.CoreMetadata
+	if v == nil {
+		v = f.DistInfoMetadata
+	}
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return map[string]string{}
+		}
+	case map[string]any:
+		hashes := make(map[string]string, len(v))
+		for name, h := range v {
+			if s, ok := h.(string); ok {
+				hashes[name] = s
+			}
+		}
+		return hashes
+	}
+	return nil
+}
+
+// YankedReason reports whether the file was yanked, and the reason if one was given
+func (f *File) YankedReason() (bool, string) {
+	switch v := f.Yanked.(type) {
+	case bool:
+		return v, ""
+	case string:
+		return true, v
+	}
+	return false, ""
+}
+
+// Page is the page of a project in the JSON Simple API
+type Page struct {
+	Meta          Meta           `json:"meta"`
+	Name          string         `json:"name"`
+	Files         []*File        `json:"files"`
+	Versions      []string       `json:"versions,omitempty"`
+	ProjectStatus *ProjectStatus `json:"project-status,omitempty"`
+	// FetchedAt is when the page was fetched from the upstream index
+	FetchedAt time.Time `json:"-"`
+}
+
+// file returns the file of the page with the given name, or nil
+func (p *Page) file(filename string) *File {
+	for _, f := range p.Files {
+		if f.Filename == filename {
+			return f
+		}
+	}
+	return nil
+}
+
+// Cache fetches projects from an upstream index, and caches them in a directory: project
+// pages for PageTTL, and distribution files, which never change, forever. In offline mode
+// only what is cached is served, however old.
+type Cache struct {
+	// BaseURL is the Simple API of the upstream index, such as https://pypi.org/simple/
+	BaseURL string
+	Dir     string
+	Client  *http.Client
+	PageTTL time.Duration
+	Offline bool
+}
+
+// New creates a cache of the upstream index at the base URL, in a directory
+func New(baseURL, dir string, client *http.Client) *Cache {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &Cache{BaseURL: baseURL, Dir: dir, Client: client, PageTTL: DefaultPageTTL}
+}
+
+// Project returns the page of a project, given by its normalized name. Cached pages are
+// served until they expire, and stale pages are served if the upstream index fails.
+func (u *Cache) Project(c context.Context, name string) (*Page, error) {
+	if !validName(name) {
+		return nil, ErrNotFound
+	}
+	fp := filepath.Join(u.Dir, "pages", name+".json")
+	cached, err := readPage(fp)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("Ignoring unreadable cached page", "file", fp, "error", err)
+	}
+	if cached != nil && (u.Offline || time.Since(cached.FetchedAt) < u.PageTTL) {
+		return cached, nil
+	}
+	if u.Offline {
+		return nil, fmt.Errorf("%w: %s is not cached", ErrNotFound, name)
+	}
+
+	page, err := u.fetchPage(c, name)
+	if errors.Is(err, ErrNotFound) {
+		// The project was removed upstream
+		os.Remove(fp)
+		return nil, err
+	} else if err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		slog.Warn("Serving stale page, the upstream index failed", "project", name, "error", err)
+		return cached, nil
+	}
+	if err := writeFile(fp, func(w io.Writer) error { return json.NewEncoder(w).Encode(page) }); err != nil {
+		slog.Warn("Unable to cache page", "project", name, "error", err)
+	}
+	return page, nil
+}
+
+// ProjectURL returns the URL of the page of a project on the upstream index
+func (u *Cache) ProjectURL(name string) string {
+	return u.BaseURL + url.PathEscape(name) + "/"
+}
+
+// fetchPage fetches the page of a project from the upstream index
+func (u *Cache) fetchPage(c context.Context, name string) (*Page, error) {
+	req, err := http.NewRequestWithContext(c, http.MethodGet, u.ProjectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", JSONHeader)
+	rsp, err := u.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", req.URL, err)
+	}
+	defer rsp.Body.Close()
+
+	switch {
+	case rsp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	case rsp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("error fetching %s: %s", req.URL, rsp.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(rsp.Header.Get("Content-Type")); mt != JSONHeader {
+		return nil, fmt.Errorf("%s does not support the JSON Simple API, got %q", req.URL, mt)
+	}
+	var page Page
+	if err := json.NewDecoder(io.LimitReader(rsp.Body, maxPageSize)).Decode(&page); err != nil {
+		return nil, fmt.Errorf("invalid page %s: %w", req.URL, err)
+	}
+	if major, _, _ := strings.Cut(page.Meta.APIVersion, "."); major != "1" && page.Meta.APIVersion != "" {
+		return nil, fmt.Errorf("unsupported Simple API version %q at %s", page.Meta.APIVersion, req.URL)
+	}
+
+	// File URLs may be relative to the page, which may have been redirected
+	for _, f := range page.Files {
+		ref, err := url.Parse(f.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL of %s at %s: %w", f.Filename, req.URL, err)
+		}
+		f.URL = rsp.Request.URL.ResolveReference(ref).String()
+	}
+	page.FetchedAt = time.Now()
+	return &page, nil
+}
+
+// File returns the path to the cached copy of a distribution file of a project, or of
+// its core metadata file, downloading it first if needed. Downloads are checked against
+// the SHA256 hash announced by the project page, and files without one are refused as they
+// would be cached forever unchecked.
+func (u *Cache) File(c context.Context, project, filename string) (string, error) {
+	if !validName(project) || !validName(filename) {
+		return "", ErrNotFound
+	}
+	fp := filepath.Join(u.Dir, "files", project, filename)
+	if _, err := os.Stat(fp); err == nil {
+		return fp, nil
+	}
+	if u.Offline {
+		return "", fmt.Errorf("%w: %s is not cached", ErrNotFound, filename)
+	}
+
+	page, err := u.Project(c, project)
+	if err != nil {
+		return "", err
+	}
+	var src string
+	var hashes map[string]string
+	if dist, ok := strings.CutSuffix(filename, MetadataFileSuffix); ok && page.file(dist) != nil {
+		f := page.file(dist)
+		if hashes = f.MetadataHashes(); hashes == nil {
+			return "", fmt.Errorf("%w: %s", ErrNotFound, filename)
+		}
+		src = strings.SplitN(f.URL, "#", 2)[0] + MetadataFileSuffix
+	} else if f := page.file(filename); f != nil {
+		src, hashes = strings.SplitN(f.URL, "#", 2)[0], f.Hashes
+	} else {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, filename)
+	}
+
+	if hashes["sha256"] == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoHash, filename)
+	}
+	if err := u.download(c, src, fp, hashes["sha256"]); err != nil {
+		return "", err
+	}
+	return fp, nil
+}
+
+// download downloads a file to a path, and checks its SHA256 hash
+func (u *Cache) download(c context.Context, src, fp, sha256Hex string) error {
+	req, err := http.NewRequestWithContext(c, http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+	rsp, err := u.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %w", src, err)
+	}
+	defer rsp.Body.Close()
+	switch {
+	case rsp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrNotFound, src)
+	case rsp.StatusCode != http.StatusOK:
+		return fmt.Errorf("error downloading %s: %s", src, rsp.Status)
+	}
+
+	return writeFile(fp, func(w io.Writer) error {
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(w, h), rsp.Body); err != nil {
+			return fmt.Errorf("error downloading %s: %w", src, err)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, sha256Hex) {
+			return fmt.Errorf("%w: %s has sha256 %s instead of %s", ErrHashMismatch, src, sum, sha256Hex)
+		}
+		return nil
+	})
+}
+
+// readPage reads a cached page, the modification time of the file being when the page
+// was fetched
+func readPage(fp string) (*Page, error) {
+	fh, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var page Page
+	if err := json.NewDecoder(fh).Decode(&page); err != nil {
+		return nil, err
+	}
+	page.FetchedAt = info.ModTime()
+	return &page, nil
+}
+
+// writeFile writes a file through a temporary file, which replaces it once complete so
+// that readers never see partial files
+func writeFile(fp string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fp), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = write(tmp)
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fp)
+}
+
+// validName checks that a project or file name can be used as a file name in the cache
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
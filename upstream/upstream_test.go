@@ -0,0 +1,188 @@
+package upstream
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubIndex serves the page of a project "demo" with one wheel and its metadata file
+type stubIndex struct {
+	*httptest.Server
+	pageRequests atomic.Int32
+	fileRequests atomic.Int32
+	failing      atomic.Bool
+	wheel        []byte
+}
+
+func newStubIndex(t *testing.T) *stubIndex {
+	s := &stubIndex{wheel: []byte("wheel content")}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /simple/demo/", func(w http.ResponseWriter, r *http.Request) {
+		s.pageRequests.Add(1)
+		if s.failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Accept") != JSONHeader {
+			t.Errorf("Unexpected Accept header %q", r.Header.Get("Accept"))
+		}
+		sum := sha256.Sum256(s.wheel)
+		metaSum := sha256.Sum256([]byte("Metadata-Version: 2.1\n"))
+		w.Header().Set("Content-Type", JSONHeader)
+		fmt.Fprintf(w, `{
+  "meta": {"api-version": "1.1", "_last-serial": 42},
+  "name": "demo",
+  "versions": ["1.0"],
+  "files": [
+    {"filename": "demo-1.0-py3-none-any.whl", "url": "../../files/demo-1.0-py3-none-any.whl",
+     "hashes": {"sha256": "%s"}, "core-metadata": {"sha256": "%s"}, "size": %d},
+    {"filename": "demo-1.0.tar.gz", "url": "/files/demo-1.0.tar.gz", "hashes": {"sha256": "%s"}, "yanked": "broken"},
+    {"filename": "demo-1.0.zip", "url": "/files/demo-1.0.zip", "hashes": {"md5": "%x"}, "core-metadata": true}
+  ]
+}`, hex.EncodeToString(sum[:]), hex.EncodeToString(metaSum[:]), len(s.wheel), hex.EncodeToString(sum[:]), md5.Sum(s.wheel))
+	})
+	mux.HandleFunc("GET /files/demo-1.0-py3-none-any.whl", func(w http.ResponseWriter, r *http.Request) {
+		s.fileRequests.Add(1)
+		w.Write(s.wheel)
+	})
+	mux.HandleFunc("GET /files/demo-1.0-py3-none-any.whl.metadata", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Metadata-Version: 2.1\n"))
+	})
+	mux.HandleFunc("GET /files/demo-1.0.zip", func(w http.ResponseWriter, r *http.Request) {
+		s.fileRequests.Add(1)
+		w.Write(s.wheel)
+	})
+	mux.HandleFunc("GET /files/demo-1.0.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered"))
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// TestProject tests fetching and caching project pages
+func TestProject(t *testing.T) {
+	stub := newStubIndex(t)
+	cache := New(stub.URL+"/simple", t.TempDir(), stub.Client())
+	ctx := context.Background()
+
+	page, err := cache.Project(ctx, "demo")
+	if err != nil {
+		t.Fatalf("Project failed: %v", err)
+	}
+	if page.Name != "demo" || page.Meta.LastSerial != 42 || len(page.Files) != 3 {
+		t.Fatalf("Unexpected page %+v", page)
+	}
+	if page.Files[0].URL != stub.URL+"/files/demo-1.0-py3-none-any.whl" {
+		t.Errorf("Expected the file URL to be resolved, got %s", page.Files[0].URL)
+	}
+	if page.Files[0].MetadataHashes()["sha256"] == "" || page.Files[1].MetadataHashes() != nil {
+		t.Errorf("Unexpected metadata hashes %v and %v", page.Files[0].MetadataHashes(), page.Files[1].MetadataHashes())
+	}
+	if yanked, reason := page.Files[1].YankedReason(); !yanked || reason != "broken" {
+		t.Errorf("Expected the sdist to be yanked, got %v %q", yanked, reason)
+	}
+
+	if _, err := cache.Project(ctx, "demo"); err != nil || stub.pageRequests.Load() != 1 {
+		t.Errorf("Expected the page to be cached, got %d requests (err: %v)", stub.pageRequests.Load(), err)
+	}
+	if _, err := cache.Project(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := cache.Project(ctx, "../demo"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an invalid name, got %v", err)
+	}
+
+	// Expired pages are fetched again, and served stale if the index fails
+	cache.PageTTL = 0
+	if _, err := cache.Project(ctx, "demo"); err != nil || stub.pageRequests.Load() != 2 {
+		t.Errorf("Expected the page to be fetched again, got %d requests (err: %v)", stub.pageRequests.Load(), err)
+	}
+	stub.failing.Store(true)
+	if page, err := cache.Project(ctx, "demo"); err != nil || page.Name != "demo" {
+		t.Errorf("Expected the stale page to be served, got %v", err)
+	}
+	if _, err := New(stub.URL+"/simple/", t.TempDir(), stub.Client()).Project(ctx, "demo"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an upstream error without a cached page, got %v", err)
+	}
+}
+
+// TestFile tests downloading and caching files
+func TestFile(t *testing.T) {
+	stub := newStubIndex(t)
+	dir := t.TempDir()
+	cache := New(stub.URL+"/simple/", dir, stub.Client())
+	ctx := context.Background()
+
+	fp, err := cache.File(ctx, "demo", "demo-1.0-py3-none-any.whl")
+	if err != nil {
+		t.Fatalf("File failed: %v", err)
+	}
+	if content, _ := os.ReadFile(fp); string(content) != "wheel content" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	if _, err := cache.File(ctx, "demo", "demo-1.0-py3-none-any.whl"); err != nil || stub.fileRequests.Load() != 1 {
+		t.Errorf("Expected the file to be cached, got %d requests (err: %v)", stub.fileRequests.Load(), err)
+	}
+	if _, err := cache.File(ctx, "demo", "demo-1.0-py3-none-any.whl.metadata"); err != nil {
+		t.Errorf("Expected the metadata file to be downloaded, got %v", err)
+	}
+
+	if _, err := cache.File(ctx, "demo", "demo-1.0.tar.gz"); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("Expected ErrHashMismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "files", "demo", "demo-1.0.tar.gz")); !os.IsNotExist(err) {
+		t.Errorf("Expected the mismatching file not to be cached (err: %v)", err)
+	}
+	// Files without a sha256 hash cannot be checked, and are not downloaded
+	for _, name := range []string{"demo-1.0.zip", "demo-1.0.zip.metadata"} {
+		if _, err := cache.File(ctx, "demo", name); !errors.Is(err, ErrNoHash) {
+			t.Errorf("Expected ErrNoHash for %s, got %v", name, err)
+		}
+	}
+	if stub.fileRequests.Load() != 1 {
+		t.Errorf("Expected files without a hash not to be downloaded, got %d requests", stub.fileRequests.Load())
+	}
+	for _, name := range []string{"demo-2.0.tar.gz", "demo-1.0.tar.gz.metadata", ".hidden"} {
+		if _, err := cache.File(ctx, "demo", name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for %s, got %v", name, err)
+		}
+	}
+}
+
+// TestOffline tests that only cached pages and files are served offline, however old
+func TestOffline(t *testing.T) {
+	stub := newStubIndex(t)
+	cache := New(stub.URL+"/simple/", t.TempDir(), stub.Client())
+	ctx := context.Background()
+	if _, err := cache.File(ctx, "demo", "demo-1.0-py3-none-any.whl"); err != nil {
+		t.Fatalf("File failed: %v", err)
+	}
+	stub.Close()
+
+	cache.Offline = true
+	cache.PageTTL = time.Nanosecond
+	if _, err := cache.Project(ctx, "demo"); err != nil {
+		t.Errorf("Expected the cached page to be served, got %v", err)
+	}
+	if _, err := cache.File(ctx, "demo", "demo-1.0-py3-none-any.whl"); err != nil {
+		t.Errorf("Expected the cached file to be served, got %v", err)
+	}
+	if _, err := cache.File(ctx, "demo", "demo-1.0-py3-none-any.whl.metadata"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a file which is not cached, got %v", err)
+	}
+	if _, err := cache.Project(ctx, "other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a page which is not cached, got %v", err)
+	}
+}
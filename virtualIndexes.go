@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-pip-server/repository"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// virtualProjectList merges the project lists of the layers of a virtual index. Projects
+// are listed once, with their name in the first layer which has them and their latest
+// serial in any layer.
+func (p *PipServer) virtualProjectList(c context.Context, idx *servedIndex) (*SimpleIdxResponse, error) {
+	rsp := SimpleIdxResponse{
+		Projects: make([]*repository.Project, 0, 64),
+		Metadata: APIMeta{Version: APIVersion},
+	}
+	listed := make(map[string]*repository.Project)
+	for _, layer := range idx.Layers {
+		lrsp, err := p.projectList(withServedIndex(c, layer))
+		if err != nil {
+			return nil, fmt.Errorf("error listing the projects of %s: %w", layer.Name, err)
+		}
+		for _, proj := range lrsp.Projects {
+			if first, ok := listed[proj.NormalizedName]; ok {
+				first.LastSerial = max(first.LastSerial, proj.LastSerial)
+			} else {
+				listed[proj.NormalizedName] = proj
+				rsp.Projects = append(rsp.Projects, proj)
+			}
+		}
+		// Serials come from one journal, so the highest one changes with every layer
+		rsp.Metadata.MaxId = max(rsp.Metadata.MaxId, lrsp.Metadata.MaxId)
+	}
+	slices.SortFunc(rsp.Projects, func(a, b *repository.Project) int {
+		return strings.Compare(a.NormalizedName, b.NormalizedName)
+	})
+	return &rsp, nil
+}
+
+// virtualProjectPage resolves a project through the layers of a virtual index in
+// priority order. With first-match, the first layer which has the project serves it. With
+// union, the files of the layers are merged, the files of earlier layers taking precedence
+// over files with the same name, until a layer where the project has the local source
+// policy. Layers which fail are not skipped, as a later layer could then serve another
+// project with the same name.
+func (p *PipServer) virtualProjectPage(r *http.Request, idx *servedIndex, name string) (*SimpleProjectResponse, error) {
+	var merged *SimpleProjectResponse
+	var locations []string
+	layers := 0
+	optedIn := true
+	for _, layer := range idx.Layers {
+		lr := r.WithContext(withServedIndex(r.Context(), layer))
+		rsp, err := p.projectPage(lr, name)
+		if errors.Is(err, repository.ErrProjectNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error resolving %s in %s: %w", name, layer.Name, err)
+		}
+		if idx.Resolution == repository.ResolveFirstMatch {
+			return rsp, nil
+		}
+
+		// Like upstream indexes, the next layers are only merged into projects which are
+		// not local
+		policy, err := p.Repo.GetSourcePolicy(name, lr.Context())
+		if err != nil {
+			return nil, fmt.Errorf("error fetching the source policy of %s in %s: %w", name, layer.Name, err)
+		}
+		layers++
+		optedIn = optedIn && policy != repository.SourceLocal
+		if u := p.pageURL(lr); u != "" {
+			locations = append(locations, u)
+		}
+		locations = append(locations, rsp.AlternateLocations...)
+		locations = append(locations, rsp.Tracks...)
+		if merged == nil {
+			merged = rsp
+		} else {
+			mergeProjectPages(merged, rsp)
+		}
+		// Local and quarantined projects hide the files of the next layers
+		if policy == repository.SourceLocal || rsp.ProjectStatus != nil && rsp.ProjectStatus.Status == string(repository.ProjectQuarantined) {
+			break
+		}
+	}
+	if merged == nil {
+		return nil, repository.ErrProjectNotFound
+	}
+
+	// Pages merged from several layers list them all as the same project (PEP 708), if
+	// all of them opted in to being merged
+	if layers > 1 {
+		merged.AlternateLocations, merged.Tracks = nil, nil
+		if optedIn {
+			slices.Sort(locations)
+			merged.AlternateLocations = slices.Compact(locations)
+		}
+	}
+	return merged, nil
+}
+
+// mergeProjectPages adds the files and versions of a project page to another, except the
+// files with the name of one it already has
+func mergeProjectPages(rsp, other *SimpleProjectResponse) {
+	files := make(map[string]bool, len(rsp.Files))
+	for _, f := range rsp.Files {
+		files[f.Filename] = true
+	}
+	for _, f := range other.Files {
+		if !files[f.Filename] {
+			rsp.Files = append(rsp.Files, f)
+		}
+	}
+	for _, v := range other.Versions {
+		if !slices.Contains(rsp.Versions, v) {
+			rsp.Versions = append(rsp.Versions, v)
+		}
+	}
+	rsp.Metadata.MaxId = max(rsp.Metadata.MaxId, other.Metadata.MaxId)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-pip-server/repository"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestServer returns a server with a database and data path of its own
+func newTestServer(t *testing.T) *PipServer {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite", repository.DataSourceName(filepath.Join(dir, "meta.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	p, err := NewPipServer(db, &Config{
+		QueriesSource: filepath.Join("assets", "queries"),
+		TemplatesDir:  filepath.Join("assets", "templates"),
+		DataPath:      filepath.Join(dir, "packages"),
+		MaxUploadSize: 10 << 20,
+		ExternalURL:   "https://pypi.example.com/",
+	})
+	if err != nil {
+		t.Fatalf("NewPipServer failed: %v", err)
+	}
+	return p
+}
+
+// testWheel builds a wheel with the core metadata of a project version
+func testWheel(t *testing.T, name, version string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, err := zw.Create(fmt.Sprintf("%s-%s.dist-info/METADATA", strings.ReplaceAll(name, "-", "_"), version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(fw, "Metadata-Version: 2.1\nName: %s\nVersion: %s\n", name, version)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// uploadWheel uploads a wheel of a project version to an upload URL like twine
+func uploadWheel(t *testing.T, p *PipServer, path, name, version string) *httptest.ResponseRecorder {
+	return serve(p, uploadRequest(t, path, name, version))
+}
+
+// uploadRequest builds the twine request uploading a wheel of a project version
+func uploadRequest(t *testing.T, path, name, version string) *http.Request {
+	content := testWheel(t, name, version)
+	sum := sha256.Sum256(content)
+	body, boundary := multipartFileBody(t, map[string]string{
+		":action":          "file_upload",
+		"protocol_version": "1",
+		"metadata_version": "2.1",
+		"name":             name,
+		"version":          version,
+		"filetype":         "bdist_wheel",
+		"pyversion":        "py3",
+		"sha256_digest":    hex.EncodeToString(sum[:]),
+	}, fmt.Sprintf("%s-%s-py3-none-any.whl", strings.ReplaceAll(name, "-", "_"), version), content)
+	r := httptest.NewRequest(http.MethodPost, path, body)
+	r.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	return r
+}
+
+// serve runs a request through the routes of a server
+func serve(p *PipServer, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	p.Server.Handler.ServeHTTP(w, r)
+	return w
+}
+
+// getJSON decodes the JSON Simple API response of a path
+func getJSON(t *testing.T, p *PipServer, path string, v any) {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Accept", JSONHeader)
+	w := httptest.NewRecorder()
+	p.Server.Handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s returned %d: %s", path, w.Code, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("Invalid response to GET %s: %v", path, err)
+	}
+}
+
+// filenames returns the names of the files of a project page
+func filenames(rsp *SimpleProjectResponse) []string {
+	names := make([]string, 0, len(rsp.Files))
+	for _, f := range rsp.Files {
+		names = append(names, f.Filename)
+	}
+	return names
+}
+
+// TestVirtualIndexPages tests how virtual indexes resolve the projects of their layers
+// with each resolution, and where uploads to them go
+func TestVirtualIndexPages(t *testing.T) {
+	p := newTestServer(t)
+	ctx := context.Background()
+
+	layers := make(map[string]*repository.Index)
+	for _, name := range []string{"team-a", "shared"} {
+		idx, err := p.Repo.CreateIndex(&repository.Index{Name: name, UploadPolicy: repository.UploadsAnonymous}, ctx)
+		if err != nil {
+			t.Fatalf("CreateIndex failed: %v", err)
+		}
+		layers[name] = idx
+	}
+	for _, res := range []repository.Resolution{repository.ResolveFirstMatch, repository.ResolveUnion} {
+		_, err := p.Repo.CreateIndex(&repository.Index{
+			Name:         "all-" + string(res),
+			Layers:       []string{"team-a", "shared"},
+			Resolution:   res,
+			UploadTarget: "team-a",
+		}, ctx)
+		if err != nil {
+			t.Fatalf("CreateIndex failed: %v", err)
+		}
+	}
+	for _, up := range []struct{ index, name, version string }{
+		{"team-a", "demo-pkg", "1.0"},
+		{"shared", "demo-pkg", "1.0"},
+		{"shared", "demo-pkg", "2.0"},
+		{"shared", "other-pkg", "0.1"},
+	} {
+		// The same file name can be uploaded to each index
+		if w := uploadWheel(t, p, "/"+up.index+"/upload/", up.name, up.version); w.Code != http.StatusOK {
+			t.Fatalf("Upload of %+v failed with %d: %s", up, w.Code, w.Body)
+		}
+	}
+	var teamA, shared SimpleProjectResponse
+	getJSON(t, p, "/team-a/simple/demo-pkg/", &teamA)
+	getJSON(t, p, "/shared/simple/demo-pkg/", &shared)
+
+	// With first-match, the first layer hides the project in the next ones
+	var rsp SimpleProjectResponse
+	getJSON(t, p, "/all-first-match/simple/demo-pkg/", &rsp)
+	if !slices.Equal(filenames(&rsp), []string{"demo_pkg-1.0-py3-none-any.whl"}) || !slices.Equal(rsp.Versions, []string{"1.0"}) {
+		t.Errorf("Expected the files of team-a, got %v %v", filenames(&rsp), rsp.Versions)
+	}
+	if rsp.Files[0].URL != teamA.Files[0].URL || rsp.Metadata.MaxId != teamA.Metadata.MaxId {
+		t.Errorf("Expected the page of team-a, got %+v", rsp)
+	}
+
+	// With union, local projects hide the next layers too
+	rsp = SimpleProjectResponse{}
+	getJSON(t, p, "/all-union/simple/demo-pkg/", &rsp)
+	if len(rsp.Files) != 1 || len(rsp.AlternateLocations) != 0 {
+		t.Errorf("Expected the local project of team-a only, got %+v", rsp)
+	}
+	teamACtx := repository.WithIndex(ctx, layers["team-a"].ID)
+	if err := p.Repo.SetSourcePolicy("demo-pkg", repository.SourceMerged, teamACtx); err != nil {
+		t.Fatalf("SetSourcePolicy failed: %v", err)
+	}
+	getJSON(t, p, "/team-a/simple/demo-pkg/", &teamA)
+	rsp = SimpleProjectResponse{}
+	getJSON(t, p, "/all-union/simple/demo-pkg/", &rsp)
+	expected := []string{"demo_pkg-1.0-py3-none-any.whl", "demo_pkg-2.0-py3-none-any.whl"}
+	if !slices.Equal(filenames(&rsp), expected) || !slices.Equal(rsp.Versions, []string{"1.0", "2.0"}) {
+		t.Errorf("Expected the files of both layers, got %v %v", filenames(&rsp), rsp.Versions)
+	}
+	if rsp.Files[0].URL != teamA.Files[0].URL || rsp.Files[1].URL != shared.Files[1].URL {
+		t.Errorf("Expected files to be downloaded from the layer which has them, got %+v %+v", rsp.Files[0], rsp.Files[1])
+	}
+	if rsp.Metadata.MaxId != max(teamA.Metadata.MaxId, shared.Metadata.MaxId) {
+		t.Errorf("Expected the latest serial of both layers, got %d", rsp.Metadata.MaxId)
+	}
+	if len(rsp.AlternateLocations) != 0 {
+		t.Errorf("Expected no alternate locations while shared did not opt in, got %v", rsp.AlternateLocations)
+	}
+	sharedCtx := repository.WithIndex(ctx, layers["shared"].ID)
+	if err := p.Repo.SetSourcePolicy("demo-pkg", repository.SourceMerged, sharedCtx); err != nil {
+		t.Fatalf("SetSourcePolicy failed: %v", err)
+	}
+	rsp = SimpleProjectResponse{}
+	getJSON(t, p, "/all-union/simple/demo-pkg/", &rsp)
+	if !slices.Equal(rsp.AlternateLocations, []string{"https://pypi.example.com/shared/simple/demo-pkg/", "https://pypi.example.com/team-a/simple/demo-pkg/"}) {
+		t.Errorf("Expected both layers as alternate locations, got %v", rsp.AlternateLocations)
+	}
+
+	// The project list has each project once, with its latest serial
+	var list SimpleIdxResponse
+	getJSON(t, p, "/all-union/simple/", &list)
+	if len(list.Projects) != 2 || list.Projects[0].Name != "demo-pkg" || list.Projects[1].Name != "other-pkg" {
+		t.Fatalf("Expected the projects of both layers, got %+v", list.Projects)
+	}
+	var sharedList SimpleIdxResponse
+	getJSON(t, p, "/shared/simple/", &sharedList)
+	if list.Metadata.MaxId != sharedList.Metadata.MaxId || list.Projects[0].LastSerial != rsp.Metadata.MaxId {
+		t.Errorf("Expected the latest serials of the layers, got %+v", list)
+	}
+
+	// Uploads go to the upload target
+	if w := uploadWheel(t, p, "/all-union/upload/", "demo-pkg", "1.1"); w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with %d: %s", w.Code, w.Body)
+	}
+	pf, err := p.Repo.GetProjectFiles("demo-pkg", teamACtx)
+	if err != nil || len(pf.Files) != 2 {
+		t.Fatalf("Expected the upload in team-a, got %+v (err: %v)", pf, err)
+	}
+	if _, err := os.Stat(filepath.Join(p.DataPath, IndexesDir, "team-a", "demo-pkg", "demo_pkg-1.1-py3-none-any.whl")); err != nil {
+		t.Errorf("Expected the file under the storage prefix of team-a: %v", err)
+	}
+	if pf, err := p.Repo.GetProjectFiles("demo-pkg", sharedCtx); err != nil || len(pf.Files) != 2 {
+		t.Errorf("Expected shared to be unchanged, got %+v (err: %v)", pf, err)
+	}
+}
+
+// TestMergeProjectPages tests that files of the first page win over files with the same
+// name
+func TestMergeProjectPages(t *testing.T) {
+	rsp := &SimpleProjectResponse{
+		Files:    []*SimpleFile{{Filename: "demo_pkg-1.0.tar.gz", URL: "/a/1.0"}},
+		Versions: []string{"1.0"},
+		Metadata: APIMeta{MaxId: 3},
+	}
+	mergeProjectPages(rsp, &SimpleProjectResponse{
+		Files:    []*SimpleFile{{Filename: "demo_pkg-1.0.tar.gz", URL: "/b/1.0"}, {Filename: "demo_pkg-2.0.tar.gz", URL: "/b/2.0"}},
+		Versions: []string{"1.0", "2.0"},
+		Metadata: APIMeta{MaxId: 7},
+	})
+	if len(rsp.Files) != 2 || rsp.Files[0].URL != "/a/1.0" || rsp.Files[1].URL != "/b/2.0" {
+		t.Errorf("Unexpected files %+v %+v", rsp.Files[0], rsp.Files[1])
+	}
+	if !slices.Equal(rsp.Versions, []string{"1.0", "2.0"}) || rsp.Metadata.MaxId != 7 {
+		t.Errorf("Unexpected versions %v or serial %d", rsp.Versions, rsp.Metadata.MaxId)
+	}
+}